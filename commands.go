@@ -0,0 +1,279 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"text/tabwriter"
+
+	"go-proxy-rotator/config"
+	"go-proxy-rotator/database"
+	"go-proxy-rotator/models"
+	"go-proxy-rotator/services"
+)
+
+const commandUsage = `Usage: go-proxy-rotator [command]
+
+Without a command the rotator starts its servers.
+
+Commands:
+  migrate status          Show the schema version and every migration
+  migrate up              Apply pending migrations and exit
+  credentials status      Count stored passwords by encryption key
+  credentials reencrypt   Encrypt every stored password with the first of CREDENTIAL_KEYS
+  keys create <name> [role]
+                          Create an API key with role viewer, operator or
+                          admin (the default) and print it
+  keys list               List API keys
+  keys revoke <id>        Revoke an API key
+`
+
+// runCommand runs a command line command and returns its exit code.
+func runCommand(cfg *config.Config, args []string) int {
+	switch {
+	case len(args) == 2 && args[0] == "migrate" && args[1] == "status":
+		return migrateStatus(cfg)
+	case len(args) == 2 && args[0] == "migrate" && args[1] == "up":
+		return migrateUp(cfg)
+	case len(args) == 2 && args[0] == "credentials" && args[1] == "status":
+		return credentialsStatus(cfg)
+	case len(args) == 2 && args[0] == "credentials" && args[1] == "reencrypt":
+		return credentialsReencrypt(cfg)
+	case len(args) == 3 && args[0] == "keys" && args[1] == "create":
+		return keysCreate(cfg, args[2], models.RoleAdmin)
+	case len(args) == 4 && args[0] == "keys" && args[1] == "create":
+		return keysCreate(cfg, args[2], args[3])
+	case len(args) == 2 && args[0] == "keys" && args[1] == "list":
+		return keysList(cfg)
+	case len(args) == 3 && args[0] == "keys" && args[1] == "revoke":
+		return keysRevoke(cfg, args[2])
+	case len(args) == 1 && (args[0] == "help" || args[0] == "-h" || args[0] == "--help"):
+		fmt.Print(commandUsage)
+		return 0
+	default:
+		fmt.Fprint(os.Stderr, commandUsage)
+		return 2
+	}
+}
+
+func migrateStatus(cfg *config.Config) int {
+	db, err := database.Open(cfg.DatabaseURL)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to open database: %v\n", err)
+		return 1
+	}
+	defer db.Close()
+
+	migrations, err := db.Migrations()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to get migrations: %v\n", err)
+		return 1
+	}
+	status := models.NewMigrationStatus(migrations)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tNAME\tSTATUS\tAPPLIED AT")
+	for _, m := range status.Migrations {
+		state, appliedAt := "pending", "-"
+		if m.Applied {
+			state = "applied"
+			appliedAt = m.AppliedAt.Local().Format("2006-01-02 15:04:05")
+		}
+		if m.Unknown {
+			state = "unknown"
+		}
+		fmt.Fprintf(w, "%04d\t%s\t%s\t%s\n", m.Version, m.Name, state, appliedAt)
+	}
+	w.Flush()
+
+	fmt.Printf("\nSchema version %d, latest %d, %d pending\n", status.Version, status.Latest, status.Pending)
+	if status.Version > status.Latest {
+		fmt.Println("The database was migrated by a newer version of the rotator")
+	}
+	return 0
+}
+
+func migrateUp(cfg *config.Config) int {
+	db, err := database.Open(cfg.DatabaseURL)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to open database: %v\n", err)
+		return 1
+	}
+	defer db.Close()
+
+	applied, err := db.Migrate()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to migrate database: %v\n", err)
+		return 1
+	}
+	fmt.Printf("Applied %d migration(s)\n", applied)
+	return 0
+}
+
+// openWithKeys opens the database with the credential keys of cfg.
+func openWithKeys(cfg *config.Config) (*database.DB, *database.Keyring, error) {
+	keyring, err := database.ParseKeyring(cfg.CredentialKeys)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid CREDENTIAL_KEYS: %w", err)
+	}
+	db, err := database.Open(cfg.DatabaseURL)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open database: %w", err)
+	}
+	db.SetKeyring(keyring)
+	return db, keyring, nil
+}
+
+// staleCredentials counts the passwords of usage, as returned by
+// CredentialKeyUsage, not encrypted with the primary key.
+func staleCredentials(usage map[string]int, keyring *database.Keyring) int {
+	stale := 0
+	for id, count := range usage {
+		if id != keyring.Primary() {
+			stale += count
+		}
+	}
+	return stale
+}
+
+func credentialsStatus(cfg *config.Config) int {
+	db, keyring, err := openWithKeys(cfg)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer db.Close()
+
+	usage, err := db.CredentialKeyUsage()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to inspect stored credentials: %v\n", err)
+		return 1
+	}
+	ids := make([]string, 0, len(usage))
+	for id := range usage {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "KEY\tPASSWORDS")
+	for _, id := range ids {
+		name := id
+		switch {
+		case id == "":
+			name = "(plaintext)"
+		case keyring != nil && id == keyring.Primary():
+			name += " (primary)"
+		}
+		fmt.Fprintf(w, "%s\t%d\n", name, usage[id])
+	}
+	w.Flush()
+
+	if keyring == nil {
+		fmt.Println("\nCREDENTIAL_KEYS is not set: new passwords are stored in plaintext")
+		return 0
+	}
+	fmt.Printf("\n%d password(s) to re-encrypt with key %s\n", staleCredentials(usage, keyring), keyring.Primary())
+	return 0
+}
+
+func credentialsReencrypt(cfg *config.Config) int {
+	db, keyring, err := openWithKeys(cfg)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer db.Close()
+
+	count, err := db.ReencryptCredentials()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to re-encrypt credentials: %v\n", err)
+		return 1
+	}
+	fmt.Printf("Re-encrypted %d password(s) with key %s\n", count, keyring.Primary())
+	return 0
+}
+
+// openAPIKeys opens the database, migrating it so the API key table
+// exists, and returns its API keys.
+func openAPIKeys(cfg *config.Config) (*database.DB, *services.APIKeys, error) {
+	db, err := database.New(cfg.DatabaseURL)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open database: %w", err)
+	}
+	return db, services.NewAPIKeys(db, ""), nil
+}
+
+func keysCreate(cfg *config.Config, name, role string) int {
+	if !models.ValidRole(role) {
+		fmt.Fprintf(os.Stderr, "Invalid role: %s\n", role)
+		return 2
+	}
+	db, keys, err := openAPIKeys(cfg)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer db.Close()
+
+	token, key, err := keys.Create(name, role)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to create API key: %v\n", err)
+		return 1
+	}
+	fmt.Printf("Created %s API key %d (%s). Store it now, it is not shown again:\n%s\n", key.Role, key.ID, key.Name, token)
+	return 0
+}
+
+func keysList(cfg *config.Config) int {
+	db, keys, err := openAPIKeys(cfg)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer db.Close()
+
+	list, err := keys.List()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to list API keys: %v\n", err)
+		return 1
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tNAME\tROLE\tPREFIX\tCREATED\tLAST USED\tSTATUS")
+	for _, key := range list {
+		lastUsed, status := "-", "active"
+		if key.LastUsedAt != nil {
+			lastUsed = key.LastUsedAt.Local().Format("2006-01-02 15:04")
+		}
+		if key.Revoked() {
+			status = "revoked " + key.RevokedAt.Local().Format("2006-01-02 15:04")
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s...\t%s\t%s\t%s\n", key.ID, key.Name, key.Role, key.Prefix,
+			key.CreatedAt.Local().Format("2006-01-02 15:04"), lastUsed, status)
+	}
+	w.Flush()
+	return 0
+}
+
+func keysRevoke(cfg *config.Config, arg string) int {
+	id, err := strconv.Atoi(arg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid API key ID: %s\n", arg)
+		return 2
+	}
+	db, keys, err := openAPIKeys(cfg)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer db.Close()
+
+	if err := keys.Revoke(id); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to revoke API key: %v\n", err)
+		return 1
+	}
+	fmt.Printf("Revoked API key %d\n", id)
+	return 0
+}
@@ -1,28 +1,228 @@
 package config
 
 import (
+	"net"
 	"os"
 	"strconv"
+	"strings"
+	"time"
 )
 
 type Config struct {
-	Port           string
-	DatabasePath   string
+	// AdminHost/AdminPort serve the API, docs and dashboard; ProxyHost and
+	// ProxyPort serve forwarded traffic. An empty host binds all interfaces.
+	AdminHost string
+	AdminPort string
+	ProxyHost string
+	ProxyPort string
+
+	// DatabaseURL selects the storage: a postgres:// URL, or the path of
+	// a SQLite database.
+	DatabaseURL    string
 	MaxFileSize    int64
 	HealthCheckURL string
 	LogLevel       string
+	ProxyTimeout   time.Duration
+
+	// HealthCheckInterval is how often active proxies are checked in the
+	// background, HealthCheckInactiveInterval how often proxies whose
+	// cooldown has run out are looked for and probed; zero disables either.
+	// HealthCheckJitter randomly shifts each run by up to that much.
+	HealthCheckInterval         time.Duration
+	HealthCheckInactiveInterval time.Duration
+	HealthCheckJitter           time.Duration
+	// HealthCheckConcurrency bounds how many proxies are checked at once;
+	// HealthCheckTimeout bounds each check.
+	HealthCheckConcurrency int
+	HealthCheckTimeout     time.Duration
+	// HealthJobHistory is how many finished health check jobs are kept;
+	// zero keeps all of them.
+	HealthJobHistory int
+	// JudgeURL is the anonymity judge health checks call through each
+	// working proxy: the rotator's own /judge endpoint, served on JudgeAddr,
+	// at an address the proxies can reach, or an external judge. Empty
+	// disables anonymity classification.
+	JudgeURL string
+	// JudgeAddr enables the listener serving only the anonymity judge
+	// (e.g. ":8081") when set. Every upstream proxy must reach it, so it is
+	// kept apart from the admin port, which can stay firewalled.
+	JudgeAddr string
+	// ExitIPHistory is how many exit IP changes are kept per proxy; zero
+	// keeps all of them.
+	ExitIPHistory int
+	// CheckHistoryRetention is how long every health check is kept,
+	// CheckRollupRetention how long their hourly rollups are kept.
+	// CheckRollupInterval is how often checks are rolled up and pruned;
+	// zero disables both.
+	CheckHistoryRetention time.Duration
+	CheckRollupRetention  time.Duration
+	CheckRollupInterval   time.Duration
+
+	// SelectionStrategy is the default proxy selection strategy: random,
+	// round-robin, weighted, least-conn, p2c or ewma.
+	SelectionStrategy string
+
+	// PoolFlushInterval is how often in-memory health updates are written
+	// back to the database; zero writes them only on shutdown.
+	// PoolSyncInterval is how often proxies and ban rules added, changed
+	// or deleted by other instances sharing the database are picked up;
+	// zero disables syncing.
+	PoolFlushInterval time.Duration
+	PoolSyncInterval  time.Duration
+
+	// CooldownBase is how long a failed proxy waits before its first
+	// re-probe, doubling after every failed probe up to CooldownMax;
+	// ReactivateAfter consecutive successful probes make it active again.
+	CooldownBase    time.Duration
+	CooldownMax     time.Duration
+	ReactivateAfter int
+
+	// Circuit breakers trip a proxy out of rotation for BreakerOpenDuration
+	// once BreakerErrorRate of at least BreakerMinRequests live requests in
+	// the last BreakerWindow failed; BreakerHalfOpenSuccesses successful
+	// trial requests close them again.
+	BreakerWindow            time.Duration
+	BreakerMinRequests       int
+	BreakerErrorRate         float64
+	BreakerOpenDuration      time.Duration
+	BreakerHalfOpenSuccesses int
+
+	// BanBodyLimit is how much of a response body, in bytes, ban rule body
+	// patterns are matched against. BanBodyWait is how long to wait for
+	// it, so streamed responses are not held back; zero waits for all of it.
+	BanBodyLimit int64
+	BanBodyWait  time.Duration
+
+	// DomainMaxFailures consecutive failures for a target domain, or a ban
+	// by it, put a proxy into cooldown for that domain, starting at
+	// DomainCooldownBase and doubling up to DomainCooldownMax. The health
+	// of a (proxy, domain) pair is forgotten DomainHealthTTL after its last
+	// request.
+	DomainMaxFailures  int
+	DomainCooldownBase time.Duration
+	DomainCooldownMax  time.Duration
+	DomainHealthTTL    time.Duration
+
+	// TunnelIdleTimeout closes CONNECT tunnels with no traffic in either
+	// direction for this long.
+	TunnelIdleTimeout time.Duration
+
+	// RetryMax is how many other proxies a failed request is retried
+	// through. RetryStatusCodes are upstream responses treated as proxy
+	// failures. Request bodies up to RetryBodyLimit are buffered so they
+	// can be retried; larger ones are streamed through a single attempt.
+	RetryMax         int
+	RetryStatusCodes []int
+	RetryBodyLimit   int64
+	// ProxyBodyLimit is the largest request body the proxy port accepts;
+	// zero means no limit.
+	ProxyBodyLimit int64
+
+	// SessionTTL is how long a sticky session keeps its proxy after the
+	// session's last request.
+	SessionTTL time.Duration
+
+	// SocksAddr enables the inbound SOCKS5 listener (e.g. ":1080") when set.
+	// SocksUsername and SocksPassword require clients to authenticate.
+	SocksAddr     string
+	SocksUsername string
+	SocksPassword string
+
+	// CredentialKeys encrypt stored proxy passwords: comma-separated
+	// id:base64-key pairs, the first of which encrypts. CredentialReveal
+	// lets API callers ask for passwords in the clear.
+	CredentialKeys   string
+	CredentialReveal bool
+
+	// APIAuth requires an API key on every /api/v1 request. AdminAPIKey is
+	// a key that always works, to create the stored ones with.
+	APIAuth     bool
+	AdminAPIKey string
+	// CORSAllowedOrigins are the origins, comma-separated, whose pages may
+	// call the API; empty allows none but the dashboard's own.
+	CORSAllowedOrigins string
 }
 
 func Load() *Config {
 	return &Config{
-		Port:           getEnv("PORT", "3000"),
-		DatabasePath:   getEnv("DATABASE_PATH", "./proxies.db"),
+		AdminHost: getEnv("ADMIN_HOST", ""),
+		AdminPort: getEnv("ADMIN_PORT", getEnv("PORT", "3000")),
+		ProxyHost: getEnv("PROXY_HOST", ""),
+		ProxyPort: getEnv("PROXY_PORT", "8080"),
+
+		DatabaseURL:    getEnv("DATABASE_URL", getEnv("DATABASE_PATH", "./proxies.db")),
 		MaxFileSize:    getEnvInt64("MAX_FILE_SIZE", 10*1024*1024), // 10MB default
 		HealthCheckURL: getEnv("HEALTH_CHECK_URL", "https://httpbin.org/ip"),
 		LogLevel:       getEnv("LOG_LEVEL", "info"),
+		ProxyTimeout:   getEnvDuration("PROXY_TIMEOUT", 30*time.Second),
+
+		HealthCheckInterval:         getEnvDuration("HEALTH_CHECK_INTERVAL", 5*time.Minute),
+		HealthCheckInactiveInterval: getEnvDuration("HEALTH_CHECK_INACTIVE_INTERVAL", 30*time.Second),
+		HealthCheckJitter:           getEnvDuration("HEALTH_CHECK_JITTER", 30*time.Second),
+		HealthCheckConcurrency:      getEnvInt("HEALTH_CHECK_CONCURRENCY", 50),
+		HealthCheckTimeout:          getEnvDuration("HEALTH_CHECK_TIMEOUT", 10*time.Second),
+		HealthJobHistory:            getEnvInt("HEALTH_JOB_HISTORY", 50),
+		JudgeURL:                    getEnv("JUDGE_URL", ""),
+		JudgeAddr:                   getEnv("JUDGE_ADDR", ""),
+		ExitIPHistory:               getEnvInt("EXIT_IP_HISTORY", 100),
+		CheckHistoryRetention:       getEnvDuration("CHECK_HISTORY_RETENTION", 7*24*time.Hour),
+		CheckRollupRetention:        getEnvDuration("CHECK_ROLLUP_RETENTION", 90*24*time.Hour),
+		CheckRollupInterval:         getEnvDuration("CHECK_ROLLUP_INTERVAL", 10*time.Minute),
+
+		SelectionStrategy: getEnv("SELECTION_STRATEGY", "random"),
+		PoolFlushInterval: getEnvDuration("POOL_FLUSH_INTERVAL", 2*time.Second),
+		PoolSyncInterval:  getEnvDuration("POOL_SYNC_INTERVAL", 30*time.Second),
+
+		CooldownBase:    getEnvDuration("COOLDOWN_BASE", time.Minute),
+		CooldownMax:     getEnvDuration("COOLDOWN_MAX", time.Hour),
+		ReactivateAfter: getEnvInt("REACTIVATE_AFTER", 3),
+
+		BreakerWindow:            getEnvDuration("BREAKER_WINDOW", 30*time.Second),
+		BreakerMinRequests:       getEnvInt("BREAKER_MIN_REQUESTS", 10),
+		BreakerErrorRate:         getEnvFloat("BREAKER_ERROR_RATE", 0.5),
+		BreakerOpenDuration:      getEnvDuration("BREAKER_OPEN_DURATION", 30*time.Second),
+		BreakerHalfOpenSuccesses: getEnvInt("BREAKER_HALF_OPEN_SUCCESSES", 2),
+
+		BanBodyLimit: getEnvInt64("BAN_BODY_LIMIT", 64*1024), // 64KB default
+		BanBodyWait:  getEnvDuration("BAN_BODY_WAIT", 500*time.Millisecond),
+
+		DomainMaxFailures:  getEnvInt("DOMAIN_MAX_FAILURES", 3),
+		DomainCooldownBase: getEnvDuration("DOMAIN_COOLDOWN_BASE", 5*time.Minute),
+		DomainCooldownMax:  getEnvDuration("DOMAIN_COOLDOWN_MAX", 6*time.Hour),
+		DomainHealthTTL:    getEnvDuration("DOMAIN_HEALTH_TTL", 24*time.Hour),
+
+		TunnelIdleTimeout: getEnvDuration("TUNNEL_IDLE_TIMEOUT", 5*time.Minute),
+
+		RetryMax:         getEnvInt("RETRY_MAX", 2),
+		RetryStatusCodes: getEnvIntList("RETRY_STATUS_CODES", []int{407, 429, 502, 503}),
+		RetryBodyLimit:   getEnvInt64("RETRY_BODY_LIMIT", 1024*1024),     // 1MB default
+		ProxyBodyLimit:   getEnvInt64("PROXY_BODY_LIMIT", 100*1024*1024), // 100MB default
+
+		SessionTTL: getEnvDuration("SESSION_TTL", 30*time.Minute),
+
+		SocksAddr:     getEnv("SOCKS_ADDR", ""),
+		SocksUsername: getEnv("SOCKS_USERNAME", ""),
+		SocksPassword: getEnv("SOCKS_PASSWORD", ""),
+
+		CredentialKeys:   getEnv("CREDENTIAL_KEYS", ""),
+		CredentialReveal: getEnvBool("CREDENTIAL_REVEAL", false),
+
+		APIAuth:            getEnvBool("API_AUTH", true),
+		AdminAPIKey:        getEnv("ADMIN_API_KEY", ""),
+		CORSAllowedOrigins: getEnv("CORS_ALLOWED_ORIGINS", ""),
 	}
 }
 
+// AdminAddr returns the listen address of the management server.
+func (c *Config) AdminAddr() string {
+	return net.JoinHostPort(c.AdminHost, c.AdminPort)
+}
+
+// ProxyAddr returns the listen address of the forwarding proxy.
+func (c *Config) ProxyAddr() string {
+	return net.JoinHostPort(c.ProxyHost, c.ProxyPort)
+}
+
 func getEnv(key, defaultValue string) string {
 	if value := os.Getenv(key); value != "" {
 		return value
@@ -30,6 +230,24 @@ func getEnv(key, defaultValue string) string {
 	return defaultValue
 }
 
+func getEnvInt(key string, defaultValue int) int {
+	if value := os.Getenv(key); value != "" {
+		if intValue, err := strconv.Atoi(value); err == nil {
+			return intValue
+		}
+	}
+	return defaultValue
+}
+
+func getEnvBool(key string, defaultValue bool) bool {
+	if value := os.Getenv(key); value != "" {
+		if boolValue, err := strconv.ParseBool(value); err == nil {
+			return boolValue
+		}
+	}
+	return defaultValue
+}
+
 func getEnvInt64(key string, defaultValue int64) int64 {
 	if value := os.Getenv(key); value != "" {
 		if intValue, err := strconv.ParseInt(value, 10, 64); err == nil {
@@ -38,3 +256,48 @@ func getEnvInt64(key string, defaultValue int64) int64 {
 	}
 	return defaultValue
 }
+
+func getEnvFloat(key string, defaultValue float64) float64 {
+	if value := os.Getenv(key); value != "" {
+		if floatValue, err := strconv.ParseFloat(value, 64); err == nil {
+			return floatValue
+		}
+	}
+	return defaultValue
+}
+
+// getEnvDuration accepts Go duration strings ("30s", "2m") or a plain
+// number of seconds.
+func getEnvDuration(key string, defaultValue time.Duration) time.Duration {
+	if value := os.Getenv(key); value != "" {
+		if d, err := time.ParseDuration(value); err == nil {
+			return d
+		}
+		if secs, err := strconv.Atoi(value); err == nil {
+			return time.Duration(secs) * time.Second
+		}
+	}
+	return defaultValue
+}
+
+// getEnvIntList parses a comma-separated list of integers. An invalid
+// entry falls back to the default.
+func getEnvIntList(key string, defaultValue []int) []int {
+	value, ok := os.LookupEnv(key)
+	if !ok {
+		return defaultValue
+	}
+	var list []int
+	for _, field := range strings.Split(value, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		n, err := strconv.Atoi(field)
+		if err != nil {
+			return defaultValue
+		}
+		list = append(list, n)
+	}
+	return list
+}
@@ -0,0 +1,109 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"go-proxy-rotator/models"
+)
+
+// AddAPIKey stores a new API key
+func (db *DB) AddAPIKey(key *models.APIKey) error {
+	query := `
+	INSERT INTO api_keys (name, prefix, key_hash, role, created_at)
+	VALUES (?, ?, ?, ?, ?)
+	RETURNING id
+	`
+	now := time.Now()
+	err := db.conn.QueryRow(query, key.Name, key.Prefix, key.Hash, key.Role, now).Scan(&key.ID)
+	if err != nil {
+		return fmt.Errorf("failed to add API key: %w", err)
+	}
+
+	key.CreatedAt = now
+	return nil
+}
+
+const apiKeyColumns = `id, name, prefix, key_hash, role, created_at, last_used_at, revoked_at`
+
+// GetAPIKeyByHash returns the API key with the given hash, revoked or not;
+// nil if there is none
+func (db *DB) GetAPIKeyByHash(hash string) (*models.APIKey, error) {
+	key, err := scanAPIKey(db.conn.QueryRow("SELECT "+apiKeyColumns+" FROM api_keys WHERE key_hash = ?", hash))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	return key, err
+}
+
+// ListAPIKeys returns all API keys in the order they were created
+func (db *DB) ListAPIKeys() ([]*models.APIKey, error) {
+	rows, err := db.conn.Query("SELECT " + apiKeyColumns + " FROM api_keys ORDER BY id ASC")
+	if err != nil {
+		return nil, fmt.Errorf("failed to query API keys: %w", err)
+	}
+	defer rows.Close()
+
+	var keys []*models.APIKey
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to query API keys: %w", err)
+	}
+
+	return keys, nil
+}
+
+// RevokeAPIKey stops an API key from authenticating
+func (db *DB) RevokeAPIKey(id int, at time.Time) error {
+	result, err := db.conn.Exec("UPDATE api_keys SET revoked_at = ? WHERE id = ? AND revoked_at IS NULL", at, id)
+	if err != nil {
+		return fmt.Errorf("failed to revoke API key: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	}
+
+	if rowsAffected == 0 {
+		return fmt.Errorf("API key with id %d not found", id)
+	}
+
+	return nil
+}
+
+// TouchAPIKey records when an API key was last used
+func (db *DB) TouchAPIKey(id int, at time.Time) error {
+	if _, err := db.conn.Exec("UPDATE api_keys SET last_used_at = ? WHERE id = ?", at, id); err != nil {
+		return fmt.Errorf("failed to update API key: %w", err)
+	}
+	return nil
+}
+
+// scanAPIKey reads a row selected with apiKeyColumns.
+func scanAPIKey(row rowScanner) (*models.APIKey, error) {
+	key := &models.APIKey{}
+	var lastUsedAt, revokedAt sql.NullTime
+	err := row.Scan(&key.ID, &key.Name, &key.Prefix, &key.Hash, &key.Role, &key.CreatedAt, &lastUsedAt, &revokedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan API key: %w", err)
+	}
+	if lastUsedAt.Valid {
+		key.LastUsedAt = &lastUsedAt.Time
+	}
+	if revokedAt.Valid {
+		key.RevokedAt = &revokedAt.Time
+	}
+	return key, nil
+}
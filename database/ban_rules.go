@@ -0,0 +1,128 @@
+package database
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"go-proxy-rotator/models"
+)
+
+// AddBanRule stores a new ban rule
+func (db *DB) AddBanRule(rule *models.BanRule) error {
+	query := `
+	INSERT INTO ban_rules (name, domain, status_codes, header, header_pattern, body_pattern, scope, enabled, created_at, updated_at)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	RETURNING id
+	`
+	now := time.Now()
+	err := db.conn.QueryRow(query, rule.Name, rule.Domain, joinStatusCodes(rule.StatusCodes),
+		rule.Header, rule.HeaderPattern, rule.BodyPattern, rule.Scope, rule.Enabled, now, now).Scan(&rule.ID)
+	if err != nil {
+		return fmt.Errorf("failed to add ban rule: %w", err)
+	}
+
+	rule.CreatedAt = now
+	rule.UpdatedAt = now
+	return nil
+}
+
+// GetBanRules returns all ban rules in the order they were created
+func (db *DB) GetBanRules() ([]*models.BanRule, error) {
+	query := `
+	SELECT id, name, domain, status_codes, header, header_pattern, body_pattern, scope, enabled, created_at, updated_at
+	FROM ban_rules
+	ORDER BY id ASC
+	`
+
+	rows, err := db.conn.Query(query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query ban rules: %w", err)
+	}
+	defer rows.Close()
+
+	var rules []*models.BanRule
+	for rows.Next() {
+		rule := &models.BanRule{}
+		var statusCodes string
+		err := rows.Scan(&rule.ID, &rule.Name, &rule.Domain, &statusCodes, &rule.Header,
+			&rule.HeaderPattern, &rule.BodyPattern, &rule.Scope, &rule.Enabled,
+			&rule.CreatedAt, &rule.UpdatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan ban rule: %w", err)
+		}
+		rule.StatusCodes = splitStatusCodes(statusCodes)
+		rules = append(rules, rule)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to query ban rules: %w", err)
+	}
+
+	return rules, nil
+}
+
+// UpdateBanRule writes every field of an existing ban rule
+func (db *DB) UpdateBanRule(rule *models.BanRule) error {
+	query := `
+	UPDATE ban_rules
+	SET name = ?, domain = ?, status_codes = ?, header = ?, header_pattern = ?,
+		body_pattern = ?, scope = ?, enabled = ?, updated_at = ?
+	WHERE id = ?
+	`
+	result, err := db.conn.Exec(query, rule.Name, rule.Domain, joinStatusCodes(rule.StatusCodes),
+		rule.Header, rule.HeaderPattern, rule.BodyPattern, rule.Scope, rule.Enabled,
+		rule.UpdatedAt, rule.ID)
+	if err != nil {
+		return fmt.Errorf("failed to update ban rule: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	}
+
+	if rowsAffected == 0 {
+		return fmt.Errorf("ban rule with id %d not found", rule.ID)
+	}
+
+	return nil
+}
+
+// DeleteBanRule deletes a ban rule by ID
+func (db *DB) DeleteBanRule(id int) error {
+	result, err := db.conn.Exec("DELETE FROM ban_rules WHERE id = ?", id)
+	if err != nil {
+		return fmt.Errorf("failed to delete ban rule: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	}
+
+	if rowsAffected == 0 {
+		return fmt.Errorf("ban rule with id %d not found", id)
+	}
+
+	return nil
+}
+
+// joinStatusCodes stores status codes as a comma-separated list
+func joinStatusCodes(codes []int) string {
+	fields := make([]string, len(codes))
+	for i, code := range codes {
+		fields[i] = strconv.Itoa(code)
+	}
+	return strings.Join(fields, ",")
+}
+
+func splitStatusCodes(codes string) []int {
+	var list []int
+	for _, field := range strings.Split(codes, ",") {
+		if code, err := strconv.Atoi(field); err == nil {
+			list = append(list, code)
+		}
+	}
+	return list
+}
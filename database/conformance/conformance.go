@@ -0,0 +1,778 @@
+// Package conformance checks that a database.Store behaves the way the
+// rest of the rotator relies on, so every backend can be held to the same
+// contract. database's TestStoreConformance runs it against an empty
+// database of each backend.
+package conformance
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"time"
+
+	"go-proxy-rotator/database"
+	"go-proxy-rotator/models"
+)
+
+// Result is the outcome of one check; Err is nil when it passed.
+type Result struct {
+	Name string
+	Err  error
+}
+
+type check struct {
+	name string
+	run  func(s database.Store, now time.Time) error
+}
+
+var checks = []check{
+	{"proxies", checkProxies},
+	{"proxy health", checkProxyHealth},
+	{"health jobs", checkHealthJobs},
+	{"domain health", checkDomainHealth},
+	{"exit ips", checkExitIPs},
+	{"check history", checkCheckHistory},
+	{"check rollups", checkRollups},
+	{"ban rules", checkBanRules},
+	{"proxy deletion", checkProxyDeletion},
+	{"api keys", checkAPIKeys},
+	{"schema migrations", checkMigrations},
+}
+
+// ErrNotEmpty is returned by Run for a store that already holds data,
+// which the checks would delete.
+var ErrNotEmpty = errors.New("store is not empty")
+
+// Run runs every check in order against s, which must be empty; the checks
+// write to it and clear it up again.
+func Run(s database.Store) ([]Result, error) {
+	proxies, err := s.GetAllProxies()
+	if err != nil {
+		return nil, err
+	}
+	jobs, err := s.ListHealthJobs(1)
+	if err != nil {
+		return nil, err
+	}
+	rules, err := s.GetBanRules()
+	if err != nil {
+		return nil, err
+	}
+	if len(proxies) > 0 || len(jobs) > 0 || len(rules) > 0 {
+		return nil, ErrNotEmpty
+	}
+
+	// Backends keep timestamps to the microsecond at best
+	now := time.Now().Truncate(time.Microsecond)
+	results := make([]Result, 0, len(checks))
+	for _, c := range checks {
+		err := c.run(s, now)
+		if resetErr := reset(s); err == nil {
+			err = resetErr
+		}
+		results = append(results, Result{Name: c.name, Err: err})
+	}
+	return results, nil
+}
+
+// reset removes what a check left behind.
+func reset(s database.Store) error {
+	if err := s.ClearAllProxies(); err != nil {
+		return err
+	}
+	if err := s.PruneHealthJobs(0); err != nil {
+		return err
+	}
+	rules, err := s.GetBanRules()
+	if err != nil {
+		return err
+	}
+	for _, rule := range rules {
+		if err := s.DeleteBanRule(rule.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func checkProxies(s database.Store, now time.Time) error {
+	a := &models.Proxy{Host: "10.0.0.1", Port: 8080, Username: "user", Password: "p@ss:word",
+		Protocol: "socks5", IsActive: true, State: models.ProxyStateActive, Tags: []string{"dc", "fast"}, Country: "DE"}
+	b := &models.Proxy{Host: "10.0.0.2", Port: 8080, Protocol: "http", IsActive: true, State: models.ProxyStateActive}
+	for _, p := range []*models.Proxy{a, b} {
+		if err := s.AddProxy(p); err != nil {
+			return err
+		}
+	}
+	if a.ID == 0 || b.ID == 0 || a.ID == b.ID {
+		return fmt.Errorf("AddProxy assigned IDs %d and %d", a.ID, b.ID)
+	}
+
+	dup := &models.Proxy{Host: "10.0.0.1", Port: 8080, Protocol: "http", IsActive: true}
+	if err := s.AddProxy(dup); !errors.Is(err, database.ErrDuplicateProxy) {
+		return fmt.Errorf("AddProxy of a duplicate returned %v, want ErrDuplicateProxy", err)
+	}
+
+	all, err := s.GetAllProxies()
+	if err != nil {
+		return err
+	}
+	if len(all) != 2 {
+		return fmt.Errorf("GetAllProxies returned %d proxies, want 2", len(all))
+	}
+	got := find(all, a.ID)
+	if got == nil || got.Host != a.Host || got.Port != a.Port || got.Username != a.Username ||
+		got.Password != a.Password || got.Protocol != a.Protocol || !got.IsActive ||
+		got.State != a.State || !slices.Equal(got.Tags, a.Tags) || got.Country != a.Country {
+		return fmt.Errorf("GetAllProxies returned %+v, want %+v", got, a)
+	}
+	if !sameTime(got.CreatedAt, a.CreatedAt) {
+		return fmt.Errorf("created_at is %v, want %v", got.CreatedAt, a.CreatedAt)
+	}
+
+	a.Tags = []string{"residential"}
+	a.Country = "FR"
+	a.UpdatedAt = now
+	if err := s.UpdateProxyLabels(a); err != nil {
+		return err
+	}
+	if err := s.UpdateProxyLabels(&models.Proxy{ID: b.ID + 1000}); err == nil {
+		return errors.New("UpdateProxyLabels of an unknown proxy did not fail")
+	}
+	all, err = s.GetAllProxies()
+	if err != nil {
+		return err
+	}
+	if got := find(all, a.ID); got == nil || !slices.Equal(got.Tags, a.Tags) || got.Country != "FR" {
+		return fmt.Errorf("labels after UpdateProxyLabels are %+v", got)
+	}
+	if got := find(all, b.ID); got == nil || len(got.Tags) != 0 {
+		return fmt.Errorf("proxy without tags has tags %v", got.Tags)
+	}
+	return nil
+}
+
+func checkProxyHealth(s database.Store, now time.Time) error {
+	var proxies []*models.Proxy
+	for i := 0; i < 3; i++ {
+		p := &models.Proxy{Host: fmt.Sprintf("10.0.1.%d", i), Port: 3128, Protocol: "http",
+			IsActive: true, State: models.ProxyStateActive}
+		if err := s.AddProxy(p); err != nil {
+			return err
+		}
+		proxies = append(proxies, p)
+	}
+
+	probe := now.Add(time.Minute)
+	seen := now.Add(-time.Second)
+	fast, slow, down := *proxies[0], *proxies[1], *proxies[2]
+	fast.LastChecked, fast.ResponseTime, fast.Anonymity = now, 120, models.AnonymityElite
+	fast.ExitIP, fast.ExitIPSeenAt, fast.UpdatedAt = "203.0.113.7", &seen, now
+	slow.LastChecked, slow.ResponseTime, slow.FailCount, slow.UpdatedAt = now, 15000, 2, now
+	down.LastChecked, down.IsActive, down.FailCount, down.UpdatedAt = now, false, 5, now
+	down.State, down.NextProbeAt, down.Cooldowns = models.ProxyStateCooldown, &probe, 1
+	if err := s.SaveProxyHealth([]*models.Proxy{&fast, &slow, &down}); err != nil {
+		return err
+	}
+
+	all, err := s.GetAllProxies()
+	if err != nil {
+		return err
+	}
+	got := find(all, fast.ID)
+	if got == nil || got.ResponseTime != 120 || got.Anonymity != models.AnonymityElite ||
+		got.ExitIP != fast.ExitIP || got.ExitIPSeenAt == nil || !sameTime(*got.ExitIPSeenAt, seen) ||
+		got.NextProbeAt != nil || !sameTime(got.LastChecked, now) {
+		return fmt.Errorf("health after SaveProxyHealth is %+v, want %+v", got, fast)
+	}
+	got = find(all, down.ID)
+	if got == nil || got.IsActive || got.State != models.ProxyStateCooldown || got.Cooldowns != 1 ||
+		got.NextProbeAt == nil || !sameTime(*got.NextProbeAt, probe) {
+		return fmt.Errorf("health after SaveProxyHealth is %+v, want %+v", got, down)
+	}
+
+	active, err := s.GetActiveProxies()
+	if err != nil {
+		return err
+	}
+	if len(active) != 2 || active[0].ID != fast.ID || active[1].ID != slow.ID {
+		return fmt.Errorf("GetActiveProxies returned %v, want [%d %d]", ids(active), fast.ID, slow.ID)
+	}
+
+	stats, err := s.GetProxyStats()
+	if err != nil {
+		return err
+	}
+	want := models.ProxyStats{TotalProxies: 3, ActiveProxies: 2, HealthyProxies: 1, FailedProxies: 1}
+	if *stats != want {
+		return fmt.Errorf("GetProxyStats returned %+v, want %+v", *stats, want)
+	}
+	return nil
+}
+
+func checkHealthJobs(s database.Store, now time.Time) error {
+	var jobs []*models.HealthJob
+	for i := 0; i < 3; i++ {
+		job := &models.HealthJob{ID: fmt.Sprintf("job-%d", i), Status: models.HealthJobRunning,
+			Trigger: models.HealthJobManual, URL: "https://httpbin.org/ip", Total: 2,
+			StartedAt: now.Add(time.Duration(i) * time.Minute)}
+		if err := s.CreateHealthJob(job); err != nil {
+			return err
+		}
+		jobs = append(jobs, job)
+	}
+
+	finished := now.Add(time.Hour)
+	job := jobs[0]
+	job.Status, job.Checked, job.Passed, job.Failed, job.FinishedAt = models.HealthJobCompleted, 2, 1, 1, &finished
+	job.Results = []models.HealthCheckResult{
+		{ProxyID: 1, Host: "10.0.0.1", Port: 80, Success: true, ResponseTime: 90, StatusCode: 200,
+			Anonymity: models.AnonymityAnonymous, ExitIP: "203.0.113.1", CheckedAt: now},
+		{ProxyID: 2, Host: "10.0.0.2", Port: 80, Error: "timeout", ErrorClass: models.CheckErrorTimeout,
+			CheckedAt: now.Add(time.Second)},
+	}
+	if err := s.FinishHealthJob(job); err != nil {
+		return err
+	}
+
+	got, err := s.GetHealthJob(job.ID)
+	if err != nil {
+		return err
+	}
+	if got == nil || got.Status != models.HealthJobCompleted || got.Passed != 1 || got.Failed != 1 ||
+		got.FinishedAt == nil || !sameTime(*got.FinishedAt, finished) || !sameTime(got.StartedAt, job.StartedAt) {
+		return fmt.Errorf("GetHealthJob returned %+v, want %+v", got, job)
+	}
+	if len(got.Results) != 2 {
+		return fmt.Errorf("GetHealthJob returned %d results, want 2", len(got.Results))
+	}
+	if r := got.Results[0]; !r.Success || r.StatusCode != 200 || r.Anonymity != models.AnonymityAnonymous || r.ExitIP != "203.0.113.1" {
+		return fmt.Errorf("first result is %+v, want %+v", r, job.Results[0])
+	}
+	if r := got.Results[1]; r.Success || r.ErrorClass != models.CheckErrorTimeout || r.Error != "timeout" {
+		return fmt.Errorf("second result is %+v, want %+v", r, job.Results[1])
+	}
+	if missing, err := s.GetHealthJob("missing"); err != nil || missing != nil {
+		return fmt.Errorf("GetHealthJob of an unknown job returned %v, %v; want nil, nil", missing, err)
+	}
+
+	list, err := s.ListHealthJobs(2)
+	if err != nil {
+		return err
+	}
+	if len(list) != 2 || list[0].ID != "job-2" || list[1].ID != "job-1" || list[0].Results != nil {
+		return fmt.Errorf("ListHealthJobs(2) returned %v, want [job-2 job-1] without results", jobIDs(list))
+	}
+
+	if err := s.InterruptHealthJobs(); err != nil {
+		return err
+	}
+	if got, err := s.GetHealthJob("job-1"); err != nil || got.Status != models.HealthJobFailed || got.Error != "interrupted" {
+		return fmt.Errorf("running job after InterruptHealthJobs is %+v (%v)", got, err)
+	}
+	if got, err := s.GetHealthJob(job.ID); err != nil || got.Status != models.HealthJobCompleted {
+		return fmt.Errorf("finished job after InterruptHealthJobs is %+v (%v)", got, err)
+	}
+
+	if err := s.PruneHealthJobs(1); err != nil {
+		return err
+	}
+	list, err = s.ListHealthJobs(10)
+	if err != nil {
+		return err
+	}
+	if len(list) != 1 || list[0].ID != "job-2" {
+		return fmt.Errorf("jobs after PruneHealthJobs(1) are %v, want [job-2]", jobIDs(list))
+	}
+	if got, err := s.GetHealthJob(job.ID); err != nil || got != nil {
+		return fmt.Errorf("pruned job is still there: %+v (%v)", got, err)
+	}
+	return nil
+}
+
+func checkDomainHealth(s database.Store, now time.Time) error {
+	until := now.Add(time.Hour)
+	entries := []*models.DomainHealth{
+		{ProxyID: 1, Domain: "example.com", Requests: 10, Successes: 7, Failures: 3, Bans: 1,
+			FailCount: 3, Cooldowns: 1, CooldownUntil: &until, LastUsedAt: now.Add(-48 * time.Hour)},
+		{ProxyID: 1, Domain: "example.org", Requests: 1, Successes: 1, LastUsedAt: now.Add(-48 * time.Hour)},
+		{ProxyID: 2, Domain: "example.com", Requests: 2, Successes: 2, LastUsedAt: now},
+	}
+	if err := s.SaveDomainHealth(entries); err != nil {
+		return err
+	}
+	entries[2].Requests, entries[2].Failures = 3, 1
+	if err := s.SaveDomainHealth(entries[2:]); err != nil {
+		return err
+	}
+
+	got, err := s.GetDomainHealth()
+	if err != nil {
+		return err
+	}
+	if len(got) != 3 {
+		return fmt.Errorf("GetDomainHealth returned %d entries, want 3", len(got))
+	}
+	first := findDomain(got, 1, "example.com")
+	if first == nil || first.Bans != 1 || first.FailCount != 3 || first.CooldownUntil == nil ||
+		!sameTime(*first.CooldownUntil, until) || !sameTime(first.LastUsedAt, entries[0].LastUsedAt) {
+		return fmt.Errorf("entry is %+v, want %+v", first, entries[0])
+	}
+	if updated := findDomain(got, 2, "example.com"); updated == nil || updated.Requests != 3 || updated.Failures != 1 {
+		return fmt.Errorf("SaveDomainHealth did not update an existing entry: %+v", updated)
+	}
+
+	// Unused for a day, but only the entry not in cooldown goes
+	if err := s.PruneDomainHealth(now.Add(-24 * time.Hour)); err != nil {
+		return err
+	}
+	if err := s.DeleteDomainHealth(2, "example.com"); err != nil {
+		return err
+	}
+	got, err = s.GetDomainHealth()
+	if err != nil {
+		return err
+	}
+	if len(got) != 1 || findDomain(got, 1, "example.com") == nil {
+		return fmt.Errorf("entries after pruning are %d, want only proxy 1 on example.com", len(got))
+	}
+	return s.DeleteDomainHealth(1, "example.com")
+}
+
+func checkExitIPs(s database.Store, now time.Time) error {
+	at := func(minutes int) time.Time { return now.Add(time.Duration(minutes) * time.Minute) }
+	observe := func(proxyID int, ip string, minutes int) error {
+		return s.RecordExitIPs([]models.ExitIP{{ProxyID: proxyID, IP: ip, FirstSeenAt: at(minutes), LastSeenAt: at(minutes)}}, 2)
+	}
+	steps := []struct {
+		proxyID int
+		ip      string
+		minutes int
+	}{
+		{1, "203.0.113.1", -30}, {1, "203.0.113.1", -20}, {1, "203.0.113.2", -10},
+		{1, "203.0.113.3", 0}, {2, "203.0.113.3", -60},
+	}
+	for _, step := range steps {
+		if err := observe(step.proxyID, step.ip, step.minutes); err != nil {
+			return err
+		}
+	}
+
+	// Three changes, of which the newest two are kept
+	history, err := s.GetExitIPHistory(1, 10)
+	if err != nil {
+		return err
+	}
+	if len(history) != 2 || history[0].IP != "203.0.113.3" || history[1].IP != "203.0.113.2" {
+		return fmt.Errorf("GetExitIPHistory returned %+v, want 203.0.113.3 then 203.0.113.2", history)
+	}
+	if limited, err := s.GetExitIPHistory(1, 1); err != nil || len(limited) != 1 {
+		return fmt.Errorf("GetExitIPHistory(1) returned %d entries (%v), want 1", len(limited), err)
+	}
+
+	if err := observe(1, "203.0.113.3", 5); err != nil {
+		return err
+	}
+	history, err = s.GetExitIPHistory(1, 10)
+	if err != nil {
+		return err
+	}
+	if len(history) != 2 || !sameTime(history[0].FirstSeenAt, at(0)) || !sameTime(history[0].LastSeenAt, at(5)) {
+		return fmt.Errorf("repeated observation gave %+v, want one entry seen from %v to %v", history, at(0), at(5))
+	}
+
+	since, err := s.GetExitIPsSince(at(-5))
+	if err != nil {
+		return err
+	}
+	if len(since) != 1 || since[0].ProxyID != 1 {
+		return fmt.Errorf("GetExitIPsSince returned %+v, want the current entry of proxy 1", since)
+	}
+	return nil
+}
+
+func checkCheckHistory(s database.Store, now time.Time) error {
+	if _, ok, err := s.EarliestProxyCheck(); err != nil || ok {
+		return fmt.Errorf("EarliestProxyCheck of an empty history returned ok=%v (%v)", ok, err)
+	}
+
+	var recorded []models.ProxyCheck
+	for i := 0; i < 4; i++ {
+		recorded = append(recorded, models.ProxyCheck{ProxyID: 1, CheckedAt: now.Add(time.Duration(-i) * time.Hour),
+			Latency: 100 + i, Success: true, StatusCode: 200, ExitIP: "203.0.113.1"})
+	}
+	recorded = append(recorded,
+		models.ProxyCheck{ProxyID: 1, CheckedAt: now.Add(-90 * time.Minute), Success: false,
+			ErrorClass: models.CheckErrorConnection},
+		models.ProxyCheck{ProxyID: 2, CheckedAt: now.Add(-time.Hour), Latency: 50, Success: true})
+	if err := s.RecordProxyChecks(recorded); err != nil {
+		return err
+	}
+
+	all, err := s.GetProxyChecks(1, now.Add(-24*time.Hour), now.Add(time.Second), 0)
+	if err != nil {
+		return err
+	}
+	if len(all) != 5 {
+		return fmt.Errorf("GetProxyChecks returned %d checks, want 5", len(all))
+	}
+	for i := 1; i < len(all); i++ {
+		if all[i].CheckedAt.After(all[i-1].CheckedAt) {
+			return errors.New("GetProxyChecks did not return the newest check first")
+		}
+	}
+	if c := all[0]; !sameTime(c.CheckedAt, now) || c.Latency != 100 || !c.Success || c.StatusCode != 200 || c.ExitIP != "203.0.113.1" {
+		return fmt.Errorf("newest check is %+v, want %+v", c, recorded[0])
+	}
+	if c := all[2]; c.Success || c.ErrorClass != models.CheckErrorConnection {
+		return fmt.Errorf("failed check is %+v, want %+v", c, recorded[4])
+	}
+
+	// The range includes from and excludes to
+	ranged, err := s.GetProxyChecks(1, now.Add(-2*time.Hour), now, 10)
+	if err != nil {
+		return err
+	}
+	if len(ranged) != 3 {
+		return fmt.Errorf("GetProxyChecks over [-2h, now) returned %d checks, want 3", len(ranged))
+	}
+	if limited, err := s.GetProxyChecks(1, now.Add(-24*time.Hour), now.Add(time.Second), 2); err != nil || len(limited) != 2 {
+		return fmt.Errorf("GetProxyChecks with limit 2 returned %d checks (%v)", len(limited), err)
+	}
+
+	between, err := s.GetChecksBetween(now.Add(-time.Hour), now)
+	if err != nil {
+		return err
+	}
+	if len(between) != 2 {
+		return fmt.Errorf("GetChecksBetween returned %d checks, want one of each proxy", len(between))
+	}
+
+	earliest, ok, err := s.EarliestProxyCheck()
+	if err != nil || !ok || !sameTime(earliest, now.Add(-3*time.Hour)) {
+		return fmt.Errorf("EarliestProxyCheck returned %v, %v (%v); want %v", earliest, ok, err, now.Add(-3*time.Hour))
+	}
+
+	if err := s.PruneProxyChecks(now.Add(-time.Hour)); err != nil {
+		return err
+	}
+	left, err := s.GetProxyChecks(1, now.Add(-24*time.Hour), now.Add(time.Second), 0)
+	if err != nil {
+		return err
+	}
+	if len(left) != 2 {
+		return fmt.Errorf("PruneProxyChecks left %d checks of proxy 1, want 2", len(left))
+	}
+	return nil
+}
+
+func checkRollups(s database.Store, now time.Time) error {
+	if _, ok, err := s.LatestCheckRollup(); err != nil || ok {
+		return fmt.Errorf("LatestCheckRollup without rollups returned ok=%v (%v)", ok, err)
+	}
+
+	hour := now.Truncate(time.Hour)
+	rollups := []models.CheckRollup{
+		{ProxyID: 1, Hour: hour.Add(-2 * time.Hour), Checks: 10, Successes: 9, P50: 100, P95: 200, P99: 300},
+		{ProxyID: 1, Hour: hour.Add(-time.Hour), Checks: 12, Successes: 12, P50: 110, P95: 210, P99: 310},
+		{ProxyID: 2, Hour: hour.Add(-time.Hour), Checks: 3, Successes: 0},
+	}
+	if err := s.SaveCheckRollups(rollups); err != nil {
+		return err
+	}
+	rollups[1].Checks, rollups[1].P99 = 13, 999
+	if err := s.SaveCheckRollups(rollups[1:2]); err != nil {
+		return err
+	}
+
+	got, err := s.GetCheckRollups(1, hour.Add(-24*time.Hour), hour)
+	if err != nil {
+		return err
+	}
+	if len(got) != 2 || !sameTime(got[0].Hour, rollups[0].Hour) || got[0].P95 != 200 {
+		return fmt.Errorf("GetCheckRollups returned %+v, want the two hours of proxy 1 oldest first", got)
+	}
+	if got[1].Checks != 13 || got[1].P99 != 999 {
+		return fmt.Errorf("SaveCheckRollups did not replace a rollup: %+v", got[1])
+	}
+
+	latest, ok, err := s.LatestCheckRollup()
+	if err != nil || !ok || !sameTime(latest, hour.Add(-time.Hour)) {
+		return fmt.Errorf("LatestCheckRollup returned %v, %v (%v); want %v", latest, ok, err, hour.Add(-time.Hour))
+	}
+
+	if err := s.PruneCheckRollups(hour.Add(-time.Hour)); err != nil {
+		return err
+	}
+	got, err = s.GetCheckRollups(1, hour.Add(-24*time.Hour), hour)
+	if err != nil {
+		return err
+	}
+	if len(got) != 1 {
+		return fmt.Errorf("PruneCheckRollups left %d rollups of proxy 1, want 1", len(got))
+	}
+	return nil
+}
+
+func checkBanRules(s database.Store, now time.Time) error {
+	rule := &models.BanRule{Name: "captcha", Domain: "example.com", StatusCodes: []int{403, 429},
+		Header: "Server", HeaderPattern: "(?i)cloudflare", BodyPattern: "captcha",
+		Scope: models.BanScopeDomain, Enabled: true}
+	other := &models.BanRule{Name: "blocked", StatusCodes: []int{407}, Scope: models.BanScopeProxy}
+	for _, r := range []*models.BanRule{rule, other} {
+		if err := s.AddBanRule(r); err != nil {
+			return err
+		}
+	}
+	if rule.ID == 0 || other.ID <= rule.ID {
+		return fmt.Errorf("AddBanRule assigned IDs %d and %d", rule.ID, other.ID)
+	}
+
+	rules, err := s.GetBanRules()
+	if err != nil {
+		return err
+	}
+	if len(rules) != 2 || rules[0].ID != rule.ID {
+		return fmt.Errorf("GetBanRules returned %d rules, want 2 oldest first", len(rules))
+	}
+	got := rules[0]
+	if got.Name != rule.Name || got.Domain != rule.Domain || !slices.Equal(got.StatusCodes, rule.StatusCodes) ||
+		got.Header != rule.Header || got.HeaderPattern != rule.HeaderPattern || got.BodyPattern != rule.BodyPattern ||
+		got.Scope != rule.Scope || !got.Enabled || rules[1].Enabled {
+		return fmt.Errorf("GetBanRules returned %+v, want %+v", got, rule)
+	}
+
+	rule.Enabled = false
+	rule.StatusCodes = nil
+	rule.UpdatedAt = now
+	if err := s.UpdateBanRule(rule); err != nil {
+		return err
+	}
+	if err := s.UpdateBanRule(&models.BanRule{ID: other.ID + 1000}); err == nil {
+		return errors.New("UpdateBanRule of an unknown rule did not fail")
+	}
+	rules, err = s.GetBanRules()
+	if err != nil {
+		return err
+	}
+	if rules[0].Enabled || len(rules[0].StatusCodes) != 0 {
+		return fmt.Errorf("rule after UpdateBanRule is %+v", rules[0])
+	}
+
+	if err := s.DeleteBanRule(rule.ID); err != nil {
+		return err
+	}
+	if err := s.DeleteBanRule(rule.ID); err == nil {
+		return errors.New("DeleteBanRule of a deleted rule did not fail")
+	}
+	return nil
+}
+
+// checkAPIKeys leaves its keys behind, revoked, as keys are never deleted;
+// their hashes are unique to the run.
+func checkAPIKeys(s database.Store, now time.Time) error {
+	run := strconv.FormatInt(now.UnixNano(), 36)
+	a := &models.APIKey{Name: "ci", Prefix: "gpr_aaaaaa", Hash: "conformance-a-" + run, Role: models.RoleViewer}
+	b := &models.APIKey{Name: "dashboard", Prefix: "gpr_bbbbbb", Hash: "conformance-b-" + run, Role: models.RoleAdmin}
+	for _, k := range []*models.APIKey{a, b} {
+		if err := s.AddAPIKey(k); err != nil {
+			return err
+		}
+	}
+	if a.ID == 0 || b.ID <= a.ID || a.CreatedAt.IsZero() {
+		return fmt.Errorf("AddAPIKey assigned IDs %d and %d", a.ID, b.ID)
+	}
+	if err := s.AddAPIKey(&models.APIKey{Name: "copy", Prefix: "gpr_aaaaaa", Hash: a.Hash, Role: models.RoleViewer}); err == nil {
+		return errors.New("AddAPIKey of a duplicate hash did not fail")
+	}
+
+	got, err := s.GetAPIKeyByHash(a.Hash)
+	if err != nil {
+		return err
+	}
+	if got == nil || got.ID != a.ID || got.Name != a.Name || got.Prefix != a.Prefix ||
+		got.Hash != a.Hash || got.Role != a.Role || got.LastUsedAt != nil || got.Revoked() {
+		return fmt.Errorf("GetAPIKeyByHash returned %+v, want %+v", got, a)
+	}
+	if got, err := s.GetAPIKeyByHash("conformance-missing-" + run); err != nil || got != nil {
+		return fmt.Errorf("GetAPIKeyByHash of an unknown hash returned %+v, %v; want nil, nil", got, err)
+	}
+
+	if err := s.TouchAPIKey(a.ID, now); err != nil {
+		return err
+	}
+	if err := s.RevokeAPIKey(b.ID, now); err != nil {
+		return err
+	}
+	if err := s.RevokeAPIKey(b.ID, now); err == nil {
+		return errors.New("RevokeAPIKey of a revoked key did not fail")
+	}
+	keys, err := s.ListAPIKeys()
+	if err != nil {
+		return err
+	}
+	var listed []*models.APIKey
+	for _, k := range keys {
+		if k.ID == a.ID || k.ID == b.ID {
+			listed = append(listed, k)
+		}
+	}
+	if len(listed) != 2 || listed[0].ID != a.ID {
+		return fmt.Errorf("ListAPIKeys returned %d of the 2 keys, want them oldest first", len(listed))
+	}
+	if listed[0].LastUsedAt == nil || !listed[0].LastUsedAt.Equal(now) || listed[0].Revoked() {
+		return fmt.Errorf("key after TouchAPIKey is %+v", listed[0])
+	}
+	if listed[1].Role != b.Role || !listed[1].Revoked() || !listed[1].RevokedAt.Equal(now) {
+		return fmt.Errorf("key after RevokeAPIKey is %+v", listed[1])
+	}
+	if err := s.RevokeAPIKey(a.ID, now); err != nil {
+		return err
+	}
+	return nil
+}
+
+func checkMigrations(s database.Store, now time.Time) error {
+	migrations, err := s.Migrations()
+	if err != nil {
+		return err
+	}
+	if len(migrations) == 0 {
+		return errors.New("Migrations returned no migrations")
+	}
+	for i, m := range migrations {
+		if m.Version != i+1 {
+			return fmt.Errorf("migration %d has version %d, want %d", i, m.Version, i+1)
+		}
+		if !m.Applied || m.AppliedAt == nil || m.AppliedAt.After(now.Add(time.Minute)) || m.Unknown {
+			return fmt.Errorf("migration %04d_%s is %+v, want applied by this version", m.Version, m.Name, m)
+		}
+	}
+	return nil
+}
+
+func checkProxyDeletion(s database.Store, now time.Time) error {
+	keep := &models.Proxy{Host: "10.0.2.1", Port: 1080, Protocol: "socks5", IsActive: true, State: models.ProxyStateActive}
+	gone := &models.Proxy{Host: "10.0.2.2", Port: 1080, Protocol: "socks5", IsActive: true, State: models.ProxyStateActive}
+	for _, p := range []*models.Proxy{keep, gone} {
+		if err := s.AddProxy(p); err != nil {
+			return err
+		}
+		if err := s.SaveDomainHealth([]*models.DomainHealth{{ProxyID: p.ID, Domain: "example.com", LastUsedAt: now}}); err != nil {
+			return err
+		}
+		if err := s.RecordExitIPs([]models.ExitIP{{ProxyID: p.ID, IP: "203.0.113.9", FirstSeenAt: now, LastSeenAt: now}}, 0); err != nil {
+			return err
+		}
+		if err := s.RecordProxyChecks([]models.ProxyCheck{{ProxyID: p.ID, CheckedAt: now, Success: true}}); err != nil {
+			return err
+		}
+		if err := s.SaveCheckRollups([]models.CheckRollup{{ProxyID: p.ID, Hour: now.Truncate(time.Hour), Checks: 1}}); err != nil {
+			return err
+		}
+	}
+
+	if err := s.DeleteProxy(gone.ID); err != nil {
+		return err
+	}
+	if err := s.DeleteProxy(gone.ID); err == nil {
+		return errors.New("DeleteProxy of a deleted proxy did not fail")
+	}
+	counts, err := historyCounts(s, gone.ID, now)
+	if err != nil {
+		return err
+	}
+	if counts != [4]int{} {
+		return fmt.Errorf("history of a deleted proxy is left: %v", counts)
+	}
+	counts, err = historyCounts(s, keep.ID, now)
+	if err != nil {
+		return err
+	}
+	if counts != [4]int{1, 1, 1, 1} {
+		return fmt.Errorf("history of another proxy was deleted: %v", counts)
+	}
+
+	if err := s.ClearAllProxies(); err != nil {
+		return err
+	}
+	counts, err = historyCounts(s, keep.ID, now)
+	if err != nil {
+		return err
+	}
+	if counts != [4]int{} {
+		return fmt.Errorf("ClearAllProxies left history: %v", counts)
+	}
+	return nil
+}
+
+// historyCounts returns how many domain health entries, exit IPs, checks
+// and rollups a proxy has.
+func historyCounts(s database.Store, proxyID int, now time.Time) ([4]int, error) {
+	var counts [4]int
+	domains, err := s.GetDomainHealth()
+	if err != nil {
+		return counts, err
+	}
+	for _, d := range domains {
+		if d.ProxyID == proxyID {
+			counts[0]++
+		}
+	}
+	exitIPs, err := s.GetExitIPHistory(proxyID, 10)
+	if err != nil {
+		return counts, err
+	}
+	checks, err := s.GetProxyChecks(proxyID, now.Add(-time.Hour), now.Add(time.Hour), 0)
+	if err != nil {
+		return counts, err
+	}
+	rollups, err := s.GetCheckRollups(proxyID, now.Add(-2*time.Hour), now.Add(time.Hour))
+	if err != nil {
+		return counts, err
+	}
+	counts[1], counts[2], counts[3] = len(exitIPs), len(checks), len(rollups)
+	return counts, nil
+}
+
+// sameTime compares timestamps to the microsecond, the precision every
+// backend keeps.
+func sameTime(a, b time.Time) bool {
+	d := a.Sub(b)
+	return d > -time.Microsecond && d < time.Microsecond
+}
+
+func find(proxies []*models.Proxy, id int) *models.Proxy {
+	for _, p := range proxies {
+		if p.ID == id {
+			return p
+		}
+	}
+	return nil
+}
+
+func findDomain(entries []*models.DomainHealth, proxyID int, domain string) *models.DomainHealth {
+	for _, e := range entries {
+		if e.ProxyID == proxyID && e.Domain == domain {
+			return e
+		}
+	}
+	return nil
+}
+
+func ids(proxies []*models.Proxy) []int {
+	list := make([]int, len(proxies))
+	for i, p := range proxies {
+		list[i] = p.ID
+	}
+	return list
+}
+
+func jobIDs(jobs []*models.HealthJob) []string {
+	list := make([]string, len(jobs))
+	for i, j := range jobs {
+		list[i] = j.ID
+	}
+	return list
+}
@@ -0,0 +1,231 @@
+package database
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Encrypted passwords are stored as enc:v1:<key id>:<base64 of nonce and
+// AES-256-GCM ciphertext>. Anything without the prefix is a plaintext
+// password written before keys were configured; plaintext passwords that
+// themselves start with either prefix are stored behind plainPrefix
+// (migration 0004 escapes the ones stored before it).
+const (
+	encryptedPrefix = "enc:v1:"
+	plainPrefix     = "plain:v1:"
+)
+
+// ErrNoCredentialKeys is returned when encrypted passwords are read, or
+// re-encrypted, without credential keys.
+var ErrNoCredentialKeys = errors.New("no credential keys configured")
+
+// Keyring holds the keys proxy passwords are encrypted with. The first key
+// encrypts; all of them decrypt, so a key can be rotated by putting the new
+// one first and keeping the old one until every password is re-encrypted.
+type Keyring struct {
+	primary string
+	keys    map[string]cipher.AEAD
+}
+
+// ParseKeyring parses a comma-separated list of id:key pairs, each key the
+// base64 encoding of 32 random bytes. An empty spec returns a nil Keyring:
+// passwords are stored in plaintext.
+func ParseKeyring(spec string) (*Keyring, error) {
+	if strings.TrimSpace(spec) == "" {
+		return nil, nil
+	}
+
+	k := &Keyring{keys: make(map[string]cipher.AEAD)}
+	for _, entry := range strings.Split(spec, ",") {
+		id, encoded, ok := strings.Cut(strings.TrimSpace(entry), ":")
+		if !ok || id == "" {
+			return nil, fmt.Errorf("invalid credential key %q: want id:base64-key", entry)
+		}
+		if _, dup := k.keys[id]; dup {
+			return nil, fmt.Errorf("credential key %s is listed twice", id)
+		}
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil || len(key) != 32 {
+			return nil, fmt.Errorf("credential key %s must be 32 bytes, base64 encoded", id)
+		}
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create cipher for key %s: %w", id, err)
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create cipher for key %s: %w", id, err)
+		}
+		if k.primary == "" {
+			k.primary = id
+		}
+		k.keys[id] = aead
+	}
+	return k, nil
+}
+
+// Primary returns the ID of the key new passwords are encrypted with.
+func (k *Keyring) Primary() string {
+	return k.primary
+}
+
+// encrypt seals password with the primary key.
+func (k *Keyring) encrypt(password string) (string, error) {
+	aead := k.keys[k.primary]
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(password)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	sealed := aead.Seal(nonce, nonce, []byte(password), []byte(k.primary))
+	return encryptedPrefix + k.primary + ":" + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// keyOf returns the ID of the key stored was encrypted with, and false for
+// plaintext.
+func keyOf(stored string) (string, bool) {
+	rest, ok := strings.CutPrefix(stored, encryptedPrefix)
+	if !ok {
+		return "", false
+	}
+	id, _, _ := strings.Cut(rest, ":")
+	return id, true
+}
+
+// sealPassword returns password as it is stored: encrypted with the
+// primary key of k, or as it is without one.
+func sealPassword(k *Keyring, password string) (string, error) {
+	if password == "" {
+		return password, nil
+	}
+	if k == nil {
+		if strings.HasPrefix(password, encryptedPrefix) || strings.HasPrefix(password, plainPrefix) {
+			return plainPrefix + password, nil
+		}
+		return password, nil
+	}
+	return k.encrypt(password)
+}
+
+// openPassword reverses sealPassword with whichever key of k stored was
+// encrypted with.
+func openPassword(k *Keyring, stored string) (string, error) {
+	if password, ok := strings.CutPrefix(stored, plainPrefix); ok {
+		return password, nil
+	}
+	id, encrypted := keyOf(stored)
+	if !encrypted {
+		return stored, nil
+	}
+	if k == nil {
+		return "", ErrNoCredentialKeys
+	}
+	aead, ok := k.keys[id]
+	if !ok {
+		return "", fmt.Errorf("password is encrypted with unknown credential key %s", id)
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(stored, encryptedPrefix+id+":"))
+	if err != nil || len(sealed) < aead.NonceSize() {
+		return "", errors.New("malformed encrypted password")
+	}
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	password, err := aead.Open(nil, nonce, ciphertext, []byte(id))
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt password with key %s: %w", id, err)
+	}
+	return string(password), nil
+}
+
+// SetKeyring makes the database encrypt passwords it writes with k and
+// decrypt the ones it reads. Passwords written before are left as they
+// are until ReencryptCredentials.
+func (db *DB) SetKeyring(k *Keyring) {
+	db.keys = k
+}
+
+// CredentialKeyUsage counts the stored passwords by the ID of the key they
+// are encrypted with; plaintext ones count under "".
+func (db *DB) CredentialKeyUsage() (map[string]int, error) {
+	rows, err := db.conn.Query("SELECT password FROM proxies WHERE password <> ''")
+	if err != nil {
+		return nil, fmt.Errorf("failed to query passwords: %w", err)
+	}
+	defer rows.Close()
+
+	usage := make(map[string]int)
+	for rows.Next() {
+		var stored string
+		if err := rows.Scan(&stored); err != nil {
+			return nil, fmt.Errorf("failed to scan password: %w", err)
+		}
+		id, _ := keyOf(stored)
+		usage[id]++
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to query passwords: %w", err)
+	}
+	return usage, nil
+}
+
+// ReencryptCredentials encrypts every stored password that is in plaintext
+// or under an older key with the primary key, in one transaction, and
+// returns how many it rewrote.
+func (db *DB) ReencryptCredentials() (int, error) {
+	if db.keys == nil {
+		return 0, ErrNoCredentialKeys
+	}
+
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	type stale struct {
+		id       int
+		password string
+	}
+	rows, err := tx.Query("SELECT id, password FROM proxies WHERE password <> ''")
+	if err != nil {
+		return 0, fmt.Errorf("failed to query passwords: %w", err)
+	}
+	var pending []stale
+	for rows.Next() {
+		var s stale
+		if err := rows.Scan(&s.id, &s.password); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("failed to scan password: %w", err)
+		}
+		if id, encrypted := keyOf(s.password); !encrypted || id != db.keys.primary {
+			pending = append(pending, s)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("failed to query passwords: %w", err)
+	}
+
+	for _, s := range pending {
+		password, err := openPassword(db.keys, s.password)
+		if err != nil {
+			return 0, fmt.Errorf("failed to decrypt password of proxy %d: %w", s.id, err)
+		}
+		sealed, err := db.keys.encrypt(password)
+		if err != nil {
+			return 0, err
+		}
+		if _, err := tx.Exec("UPDATE proxies SET password = ? WHERE id = ?", sealed, s.id); err != nil {
+			return 0, fmt.Errorf("failed to update password of proxy %d: %w", s.id, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return len(pending), nil
+}
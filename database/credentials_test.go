@@ -0,0 +1,271 @@
+package database
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"go-proxy-rotator/models"
+)
+
+// testKey returns a credential key spec entry for id made of fill bytes.
+func testKey(id string, fill byte) string {
+	return id + ":" + base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{fill}, 32))
+}
+
+func mustKeyring(t *testing.T, spec string) *Keyring {
+	t.Helper()
+	k, err := ParseKeyring(spec)
+	if err != nil {
+		t.Fatalf("ParseKeyring(%q): %v", spec, err)
+	}
+	return k
+}
+
+func TestParseKeyring(t *testing.T) {
+	tests := []struct {
+		name    string
+		spec    string
+		primary string
+		wantErr bool
+	}{
+		{name: "empty", spec: ""},
+		{name: "blank", spec: "  "},
+		{name: "one key", spec: testKey("a", 1), primary: "a"},
+		{name: "first key is primary", spec: testKey("new", 2) + ", " + testKey("old", 1), primary: "new"},
+		{name: "missing id", spec: ":" + base64.StdEncoding.EncodeToString(make([]byte, 32)), wantErr: true},
+		{name: "missing key", spec: "a", wantErr: true},
+		{name: "short key", spec: "a:" + base64.StdEncoding.EncodeToString(make([]byte, 16)), wantErr: true},
+		{name: "not base64", spec: "a:not-base64!", wantErr: true},
+		{name: "duplicate id", spec: testKey("a", 1) + "," + testKey("a", 2), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k, err := ParseKeyring(tt.spec)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("ParseKeyring(%q) succeeded, want an error", tt.spec)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseKeyring(%q): %v", tt.spec, err)
+			}
+			if tt.primary == "" {
+				if k != nil {
+					t.Fatalf("ParseKeyring(%q) = %+v, want nil", tt.spec, k)
+				}
+				return
+			}
+			if k.Primary() != tt.primary {
+				t.Errorf("Primary() = %q, want %q", k.Primary(), tt.primary)
+			}
+		})
+	}
+}
+
+func TestPasswordRoundTrip(t *testing.T) {
+	keys := mustKeyring(t, testKey("a", 1))
+	passwords := []string{
+		"",
+		"secret",
+		"pässwörd with spaces",
+		"enc:v1:a:looks-encrypted",
+		"enc:v1:",
+		"plain:v1:looks-escaped",
+	}
+	for _, keyring := range []struct {
+		name string
+		keys *Keyring
+	}{{"plaintext", nil}, {"encrypted", keys}} {
+		for _, password := range passwords {
+			t.Run(keyring.name+"/"+password, func(t *testing.T) {
+				stored, err := sealPassword(keyring.keys, password)
+				if err != nil {
+					t.Fatalf("sealPassword: %v", err)
+				}
+				if keyring.keys != nil && password != "" {
+					if id, encrypted := keyOf(stored); !encrypted || id != "a" {
+						t.Errorf("stored %q is not encrypted with key a", stored)
+					}
+					if strings.Contains(strings.TrimPrefix(stored, encryptedPrefix+"a:"), password) {
+						t.Errorf("stored %q contains the password", stored)
+					}
+				}
+				got, err := openPassword(keyring.keys, stored)
+				if err != nil {
+					t.Fatalf("openPassword(%q): %v", stored, err)
+				}
+				if got != password {
+					t.Errorf("round trip of %q = %q", password, got)
+				}
+			})
+		}
+	}
+}
+
+func TestSealPasswordUsesFreshNonces(t *testing.T) {
+	keys := mustKeyring(t, testKey("a", 1))
+	first, err := sealPassword(keys, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := sealPassword(keys, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first == second {
+		t.Errorf("the same password was encrypted to the same value twice: %q", first)
+	}
+}
+
+func TestOpenPasswordErrors(t *testing.T) {
+	keyA := mustKeyring(t, testKey("a", 1))
+	sealed, err := sealPassword(keyA, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload := strings.TrimPrefix(sealed, encryptedPrefix+"a:")
+	raw, err := base64.StdEncoding.DecodeString(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw[len(raw)-1] ^= 0xff
+	tampered := encryptedPrefix + "a:" + base64.StdEncoding.EncodeToString(raw)
+
+	tests := []struct {
+		name    string
+		keys    *Keyring
+		stored  string
+		wantErr string
+	}{
+		{name: "no keys", keys: nil, stored: sealed, wantErr: ErrNoCredentialKeys.Error()},
+		{name: "unknown key id", keys: mustKeyring(t, testKey("b", 2)), stored: sealed, wantErr: "unknown credential key a"},
+		// The key ID is authenticated: ciphertext moved under another
+		// key's ID does not decrypt, even when that key is known.
+		{name: "wrong key id", keys: mustKeyring(t, testKey("a", 1)+","+testKey("b", 1)),
+			stored: encryptedPrefix + "b:" + payload, wantErr: "failed to decrypt"},
+		{name: "wrong key", keys: mustKeyring(t, testKey("a", 2)), stored: sealed, wantErr: "failed to decrypt"},
+		{name: "tampered", keys: keyA, stored: tampered, wantErr: "failed to decrypt"},
+		{name: "not base64", keys: keyA, stored: encryptedPrefix + "a:%%%", wantErr: "malformed"},
+		{name: "too short", keys: keyA, stored: encryptedPrefix + "a:AAAA", wantErr: "malformed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := openPassword(tt.keys, tt.stored)
+			if err == nil {
+				t.Fatalf("openPassword(%q) = %q, want an error", tt.stored, got)
+			}
+			if !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("openPassword error = %v, want it to contain %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestReencryptCredentials(t *testing.T) {
+	db, err := New(filepath.Join(t.TempDir(), "credentials.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if _, err := db.ReencryptCredentials(); !errors.Is(err, ErrNoCredentialKeys) {
+		t.Fatalf("ReencryptCredentials without keys = %v, want ErrNoCredentialKeys", err)
+	}
+
+	add := func(port int, password string) {
+		t.Helper()
+		proxy := &models.Proxy{Host: "10.0.0.1", Port: port, Password: password, Protocol: "http", IsActive: true, State: "active"}
+		if err := db.AddProxy(proxy); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Written before keys were configured, under the old key and under
+	// the new one
+	add(1, "plain")
+	add(2, "enc:v1:not-really")
+	db.SetKeyring(mustKeyring(t, testKey("old", 1)))
+	add(3, "old")
+	rotated := mustKeyring(t, testKey("new", 2)+","+testKey("old", 1))
+	db.SetKeyring(rotated)
+	add(4, "new")
+	add(5, "")
+
+	usage, err := db.CredentialKeyUsage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if usage[""] != 2 || usage["old"] != 1 || usage["new"] != 1 {
+		t.Fatalf("usage before re-encryption = %v", usage)
+	}
+
+	count, err := db.ReencryptCredentials()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 3 {
+		t.Errorf("ReencryptCredentials rewrote %d passwords, want 3", count)
+	}
+	usage, err = db.CredentialKeyUsage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(usage) != 1 || usage["new"] != 4 {
+		t.Errorf("usage after re-encryption = %v, want all 4 under new", usage)
+	}
+	if count, err := db.ReencryptCredentials(); err != nil || count != 0 {
+		t.Errorf("second ReencryptCredentials = %d, %v; want 0, nil", count, err)
+	}
+
+	// The old key can go once nothing uses it
+	db.SetKeyring(mustKeyring(t, testKey("new", 2)))
+	proxies, err := db.GetAllProxies()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[int]string{1: "plain", 2: "enc:v1:not-really", 3: "old", 4: "new", 5: ""}
+	for _, proxy := range proxies {
+		if proxy.Password != want[proxy.Port] {
+			t.Errorf("password of proxy on port %d = %q, want %q", proxy.Port, proxy.Password, want[proxy.Port])
+		}
+	}
+}
+
+func TestEscapePlaintextPasswordsMigration(t *testing.T) {
+	db, err := New(filepath.Join(t.TempDir(), "credentials.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	// Stored in plaintext before the escape prefix existed
+	legacy := []string{"plain:v1:legacy", "plain", "PLAIN:V1:upper"}
+	for i, password := range legacy {
+		_, err := db.conn.Exec(`INSERT INTO proxies (host, port, password, protocol, is_active, state, created_at, updated_at)
+			VALUES (?, ?, ?, 'http', ?, 'active', ?, ?)`, "10.0.0.1", i+1, password, true, time.Now(), time.Now())
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := db.conn.Exec("DELETE FROM schema_migrations WHERE version = 4"); err != nil {
+		t.Fatal(err)
+	}
+	if applied, err := db.Migrate(); err != nil || applied != 1 {
+		t.Fatalf("Migrate = %d, %v; want 1, nil", applied, err)
+	}
+
+	proxies, err := db.GetAllProxies()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, proxy := range proxies {
+		if want := legacy[proxy.Port-1]; proxy.Password != want {
+			t.Errorf("password of proxy on port %d = %q, want %q", proxy.Port, proxy.Password, want)
+		}
+	}
+}
@@ -3,28 +3,40 @@ package database
 import (
 	"database/sql"
 	"fmt"
+	"strings"
 	"time"
 
 	"go-proxy-rotator/models"
-
-	_ "github.com/mattn/go-sqlite3"
 )
 
+// DB is the Store on a SQL database.
 type DB struct {
-	conn *sql.DB
+	conn *conn
+	// keys encrypt stored passwords; nil stores them in plaintext.
+	keys *Keyring
 }
 
-func New(dbPath string) (*DB, error) {
-	conn, err := sql.Open("sqlite3", dbPath)
+// Open opens the database named by dsn: a postgres:// URL, or the path of
+// a SQLite database. Its schema is left as it is; see Migrate.
+func Open(dsn string) (*DB, error) {
+	d, source := dialectFor(dsn)
+	sqlDB, err := sql.Open(d.driver, source)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open database: %w", err)
 	}
+	return &DB{conn: &conn{DB: sqlDB, dialect: d}}, nil
+}
 
-	db := &DB{conn: conn}
-	if err := db.createTables(); err != nil {
-		return nil, fmt.Errorf("failed to create tables: %w", err)
+// New opens the database named by dsn and applies its pending migrations.
+func New(dsn string) (*DB, error) {
+	db, err := Open(dsn)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := db.Migrate(); err != nil {
+		db.Close()
+		return nil, err
 	}
-
 	return db, nil
 }
 
@@ -32,51 +44,27 @@ func (db *DB) Close() error {
 	return db.conn.Close()
 }
 
-func (db *DB) createTables() error {
-	query := `
-	CREATE TABLE IF NOT EXISTS proxies (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		host TEXT NOT NULL,
-		port INTEGER NOT NULL,
-		username TEXT DEFAULT '',
-		password TEXT DEFAULT '',
-		protocol TEXT DEFAULT 'http',
-		is_active BOOLEAN DEFAULT 1,
-		last_checked DATETIME DEFAULT CURRENT_TIMESTAMP,
-		response_time INTEGER DEFAULT 0,
-		fail_count INTEGER DEFAULT 0,
-		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-		UNIQUE(host, port)
-	);
-	
-	CREATE INDEX IF NOT EXISTS idx_proxies_active ON proxies(is_active);
-	CREATE INDEX IF NOT EXISTS idx_proxies_health ON proxies(is_active, fail_count);
-	`
-
-	_, err := db.conn.Exec(query)
-	return err
-}
-
 // AddProxy adds a new proxy to the database
 func (db *DB) AddProxy(proxy *models.Proxy) error {
 	query := `
-	INSERT INTO proxies (host, port, username, password, protocol, is_active, created_at, updated_at)
-	VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	INSERT INTO proxies (host, port, username, password, protocol, is_active, state, tags, country, created_at, updated_at)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	RETURNING id
 	`
-	now := time.Now()
-	result, err := db.conn.Exec(query, proxy.Host, proxy.Port, proxy.Username,
-		proxy.Password, proxy.Protocol, proxy.IsActive, now, now)
+	password, err := sealPassword(db.keys, proxy.Password)
 	if err != nil {
-		return fmt.Errorf("failed to add proxy: %w", err)
+		return fmt.Errorf("failed to encrypt password: %w", err)
+	}
+	now := time.Now()
+	err = db.conn.QueryRow(query, proxy.Host, proxy.Port, proxy.Username,
+		password, proxy.Protocol, proxy.IsActive, proxy.State, joinTags(proxy.Tags), proxy.Country, now, now).Scan(&proxy.ID)
+	if db.conn.dialect.isUniqueViolation(err) {
+		return fmt.Errorf("failed to add proxy %s:%d: %w", proxy.Host, proxy.Port, ErrDuplicateProxy)
 	}
-
-	id, err := result.LastInsertId()
 	if err != nil {
-		return fmt.Errorf("failed to get last insert id: %w", err)
+		return fmt.Errorf("failed to add proxy: %w", err)
 	}
 
-	proxy.ID = int(id)
 	proxy.CreatedAt = now
 	proxy.UpdatedAt = now
 	return nil
@@ -85,10 +73,9 @@ func (db *DB) AddProxy(proxy *models.Proxy) error {
 // GetActiveProxies returns all active proxies
 func (db *DB) GetActiveProxies() ([]*models.Proxy, error) {
 	query := `
-	SELECT id, host, port, username, password, protocol, is_active, 
-		   last_checked, response_time, fail_count, created_at, updated_at
-	FROM proxies 
-	WHERE is_active = 1 AND fail_count < 5
+	SELECT ` + proxyColumns + `
+	FROM proxies
+	WHERE is_active = TRUE AND fail_count < 5
 	ORDER BY response_time ASC, fail_count ASC
 	`
 
@@ -98,27 +85,14 @@ func (db *DB) GetActiveProxies() ([]*models.Proxy, error) {
 	}
 	defer rows.Close()
 
-	var proxies []*models.Proxy
-	for rows.Next() {
-		proxy := &models.Proxy{}
-		err := rows.Scan(&proxy.ID, &proxy.Host, &proxy.Port, &proxy.Username,
-			&proxy.Password, &proxy.Protocol, &proxy.IsActive, &proxy.LastChecked,
-			&proxy.ResponseTime, &proxy.FailCount, &proxy.CreatedAt, &proxy.UpdatedAt)
-		if err != nil {
-			return nil, fmt.Errorf("failed to scan proxy: %w", err)
-		}
-		proxies = append(proxies, proxy)
-	}
-
-	return proxies, nil
+	return db.scanProxies(rows)
 }
 
 // GetAllProxies returns all proxies
 func (db *DB) GetAllProxies() ([]*models.Proxy, error) {
 	query := `
-	SELECT id, host, port, username, password, protocol, is_active, 
-		   last_checked, response_time, fail_count, created_at, updated_at
-	FROM proxies 
+	SELECT ` + proxyColumns + `
+	FROM proxies
 	ORDER BY created_at DESC
 	`
 
@@ -128,55 +102,75 @@ func (db *DB) GetAllProxies() ([]*models.Proxy, error) {
 	}
 	defer rows.Close()
 
-	var proxies []*models.Proxy
-	for rows.Next() {
-		proxy := &models.Proxy{}
-		err := rows.Scan(&proxy.ID, &proxy.Host, &proxy.Port, &proxy.Username,
-			&proxy.Password, &proxy.Protocol, &proxy.IsActive, &proxy.LastChecked,
-			&proxy.ResponseTime, &proxy.FailCount, &proxy.CreatedAt, &proxy.UpdatedAt)
+	return db.scanProxies(rows)
+}
+
+// SaveProxyHealth writes the health fields of the given proxies in a single
+// transaction
+func (db *DB) SaveProxyHealth(proxies []*models.Proxy) error {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(`
+	UPDATE proxies
+	SET is_active = ?, last_checked = ?, response_time = ?, fail_count = ?,
+		state = ?, next_probe_at = ?, cooldowns = ?, success_count = ?, anonymity = ?,
+		exit_ip = ?, exit_ip_seen_at = ?, updated_at = ?
+	WHERE id = ?
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to prepare health update: %w", err)
+	}
+	defer stmt.Close()
+
+	for _, proxy := range proxies {
+		_, err := stmt.Exec(proxy.IsActive, proxy.LastChecked, proxy.ResponseTime,
+			proxy.FailCount, proxy.State, proxy.NextProbeAt, proxy.Cooldowns,
+			proxy.SuccessCount, proxy.Anonymity, proxy.ExitIP, proxy.ExitIPSeenAt,
+			proxy.UpdatedAt, proxy.ID)
 		if err != nil {
-			return nil, fmt.Errorf("failed to scan proxy: %w", err)
+			return fmt.Errorf("failed to update proxy health: %w", err)
 		}
-		proxies = append(proxies, proxy)
 	}
 
-	return proxies, nil
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit health updates: %w", err)
+	}
+	return nil
 }
 
-// UpdateProxyHealth updates proxy health information
-func (db *DB) UpdateProxyHealth(id int, responseTime int, success bool) error {
-	var query string
-	var args []interface{}
-
-	if success {
-		query = `
-		UPDATE proxies 
-		SET last_checked = ?, response_time = ?, fail_count = 0, updated_at = ?
-		WHERE id = ?
-		`
-		now := time.Now()
-		args = []interface{}{now, responseTime, now, id}
-	} else {
-		query = `
-		UPDATE proxies 
-		SET last_checked = ?, fail_count = fail_count + 1, updated_at = ?,
-			is_active = CASE WHEN fail_count + 1 >= 5 THEN 0 ELSE is_active END
-		WHERE id = ?
-		`
-		now := time.Now()
-		args = []interface{}{now, now, id}
-	}
-
-	_, err := db.conn.Exec(query, args...)
+// UpdateProxyLabels writes a proxy's tags and country
+func (db *DB) UpdateProxyLabels(proxy *models.Proxy) error {
+	query := "UPDATE proxies SET tags = ?, country = ?, updated_at = ? WHERE id = ?"
+	result, err := db.conn.Exec(query, joinTags(proxy.Tags), proxy.Country, proxy.UpdatedAt, proxy.ID)
 	if err != nil {
-		return fmt.Errorf("failed to update proxy health: %w", err)
+		return fmt.Errorf("failed to update proxy: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	}
+
+	if rowsAffected == 0 {
+		return fmt.Errorf("proxy with id %d not found", proxy.ID)
 	}
 
 	return nil
 }
 
-// DeleteProxy deletes a proxy by ID
+// DeleteProxy deletes a proxy by ID, along with its per-domain health, exit
+// IP history and check history
 func (db *DB) DeleteProxy(id int) error {
+	for _, table := range proxyHistoryTables {
+		if _, err := db.conn.Exec("DELETE FROM "+table+" WHERE proxy_id = ?", id); err != nil {
+			return fmt.Errorf("failed to delete proxy history from %s: %w", table, err)
+		}
+	}
+
 	query := "DELETE FROM proxies WHERE id = ?"
 	result, err := db.conn.Exec(query, id)
 	if err != nil {
@@ -200,9 +194,9 @@ func (db *DB) GetProxyStats() (*models.ProxyStats, error) {
 	query := `
 	SELECT 
 		COUNT(*) as total,
-		COALESCE(SUM(CASE WHEN is_active = 1 THEN 1 ELSE 0 END), 0) as active,
-		COALESCE(SUM(CASE WHEN is_active = 1 AND fail_count < 5 AND response_time < 10000 THEN 1 ELSE 0 END), 0) as healthy,
-		COALESCE(SUM(CASE WHEN is_active = 0 OR fail_count >= 5 THEN 1 ELSE 0 END), 0) as failed
+		COALESCE(SUM(CASE WHEN is_active = TRUE THEN 1 ELSE 0 END), 0) as active,
+		COALESCE(SUM(CASE WHEN is_active = TRUE AND fail_count < 5 AND response_time < 10000 THEN 1 ELSE 0 END), 0) as healthy,
+		COALESCE(SUM(CASE WHEN is_active = FALSE OR fail_count >= 5 THEN 1 ELSE 0 END), 0) as failed
 	FROM proxies
 	`
 
@@ -218,6 +212,11 @@ func (db *DB) GetProxyStats() (*models.ProxyStats, error) {
 
 // ClearAllProxies removes all proxies from the database
 func (db *DB) ClearAllProxies() error {
+	for _, table := range proxyHistoryTables {
+		if _, err := db.conn.Exec("DELETE FROM " + table); err != nil {
+			return fmt.Errorf("failed to clear %s: %w", table, err)
+		}
+	}
 	query := "DELETE FROM proxies"
 	_, err := db.conn.Exec(query)
 	if err != nil {
@@ -225,3 +224,63 @@ func (db *DB) ClearAllProxies() error {
 	}
 	return nil
 }
+
+// proxyHistoryTables hold per-proxy rows that go away with their proxy.
+var proxyHistoryTables = []string{"proxy_domains", "proxy_exit_ips", "proxy_checks", "proxy_check_rollups"}
+
+// proxyColumns lists the proxies columns in the order scanProxies reads
+// them.
+const proxyColumns = `id, host, port, username, password, protocol, is_active,
+		   last_checked, response_time, fail_count, state, next_probe_at, cooldowns,
+		   success_count, tags, country, anonymity, exit_ip, exit_ip_seen_at,
+		   created_at, updated_at`
+
+// scanProxies reads rows selected with proxyColumns, decrypting passwords.
+func (db *DB) scanProxies(rows *sql.Rows) ([]*models.Proxy, error) {
+	var proxies []*models.Proxy
+	for rows.Next() {
+		proxy := &models.Proxy{}
+		var (
+			tags         string
+			nextProbeAt  sql.NullTime
+			exitIPSeenAt sql.NullTime
+		)
+		err := rows.Scan(&proxy.ID, &proxy.Host, &proxy.Port, &proxy.Username,
+			&proxy.Password, &proxy.Protocol, &proxy.IsActive, &proxy.LastChecked,
+			&proxy.ResponseTime, &proxy.FailCount, &proxy.State, &nextProbeAt,
+			&proxy.Cooldowns, &proxy.SuccessCount, &tags, &proxy.Country,
+			&proxy.Anonymity, &proxy.ExitIP, &exitIPSeenAt, &proxy.CreatedAt, &proxy.UpdatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan proxy: %w", err)
+		}
+		if nextProbeAt.Valid {
+			proxy.NextProbeAt = &nextProbeAt.Time
+		}
+		if exitIPSeenAt.Valid {
+			proxy.ExitIPSeenAt = &exitIPSeenAt.Time
+		}
+		proxy.Password, err = openPassword(db.keys, proxy.Password)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read password of proxy %d: %w", proxy.ID, err)
+		}
+		proxy.Tags = splitTags(tags)
+		proxies = append(proxies, proxy)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to scan proxy: %w", err)
+	}
+
+	return proxies, nil
+}
+
+// joinTags stores tags as a comma-separated list
+func joinTags(tags []string) string {
+	return strings.Join(tags, ",")
+}
+
+func splitTags(tags string) []string {
+	if tags == "" {
+		return nil
+	}
+	return strings.Split(tags, ",")
+}
@@ -0,0 +1,133 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/lib/pq"
+	"github.com/mattn/go-sqlite3"
+)
+
+// dialect holds what differs between the SQL backends. Queries are written
+// once with ? placeholders in the SQL both understand.
+type dialect struct {
+	// name is also the directory of the dialect's migrations.
+	name   string
+	driver string
+	// timestamp is the column type of times.
+	timestamp string
+	// numbered placeholders ($1, $2, ...) instead of ?
+	numbered bool
+	// tableExists counts the tables with the name given as its argument.
+	tableExists string
+	// lockMigrations, when set, is run at the start of every migration's
+	// transaction to keep instances from migrating at the same time.
+	lockMigrations string
+	// isUniqueViolation reports whether err is a unique constraint failure.
+	isUniqueViolation func(err error) bool
+}
+
+var sqliteDialect = &dialect{
+	name:        "sqlite",
+	driver:      "sqlite3",
+	timestamp:   "DATETIME",
+	tableExists: "SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?",
+	isUniqueViolation: func(err error) bool {
+		var sqliteErr sqlite3.Error
+		return errors.As(err, &sqliteErr) && sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique
+	},
+}
+
+var postgresDialect = &dialect{
+	name:           "postgres",
+	driver:         "postgres",
+	timestamp:      "TIMESTAMPTZ",
+	numbered:       true,
+	tableExists:    "SELECT COUNT(*) FROM information_schema.tables WHERE table_schema = current_schema() AND table_name = ?",
+	lockMigrations: "SELECT pg_advisory_xact_lock(7262070)",
+	isUniqueViolation: func(err error) bool {
+		var pqErr *pq.Error
+		return errors.As(err, &pqErr) && pqErr.Code == "23505"
+	},
+}
+
+// dialectFor picks the backend of a DSN: postgres:// and postgresql:// URLs
+// use PostgreSQL, anything else is the path of a SQLite database, with an
+// optional sqlite:// prefix.
+func dialectFor(dsn string) (*dialect, string) {
+	switch {
+	case strings.HasPrefix(dsn, "postgres://"), strings.HasPrefix(dsn, "postgresql://"):
+		return postgresDialect, dsn
+	default:
+		return sqliteDialect, strings.TrimPrefix(dsn, "sqlite://")
+	}
+}
+
+// rebind rewrites the ? placeholders of query for the dialect.
+func (d *dialect) rebind(query string) string {
+	if !d.numbered {
+		return query
+	}
+	var b strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			b.WriteByte('$')
+			b.WriteString(strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// conn is a connection pool that rebinds queries for its dialect.
+type conn struct {
+	*sql.DB
+	dialect *dialect
+}
+
+func (c *conn) Exec(query string, args ...any) (sql.Result, error) {
+	return c.DB.Exec(c.dialect.rebind(query), args...)
+}
+
+func (c *conn) Query(query string, args ...any) (*sql.Rows, error) {
+	return c.DB.Query(c.dialect.rebind(query), args...)
+}
+
+func (c *conn) QueryRow(query string, args ...any) *sql.Row {
+	return c.DB.QueryRow(c.dialect.rebind(query), args...)
+}
+
+func (c *conn) Begin() (*tx, error) {
+	t, err := c.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	return &tx{Tx: t, dialect: c.dialect}, nil
+}
+
+// tx is a transaction that rebinds queries for its dialect.
+type tx struct {
+	*sql.Tx
+	dialect *dialect
+}
+
+func (t *tx) Exec(query string, args ...any) (sql.Result, error) {
+	return t.Tx.Exec(t.dialect.rebind(query), args...)
+}
+
+func (t *tx) Query(query string, args ...any) (*sql.Rows, error) {
+	return t.Tx.Query(t.dialect.rebind(query), args...)
+}
+
+func (t *tx) QueryRow(query string, args ...any) *sql.Row {
+	return t.Tx.QueryRow(t.dialect.rebind(query), args...)
+}
+
+func (t *tx) Prepare(query string) (*sql.Stmt, error) {
+	return t.Tx.Prepare(t.dialect.rebind(query))
+}
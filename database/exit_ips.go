@@ -0,0 +1,103 @@
+package database
+
+import (
+	"fmt"
+	"time"
+
+	"go-proxy-rotator/models"
+)
+
+// RecordExitIPs adds exit IP observations to the history of their proxies
+// in a single transaction. An observation of a proxy's latest address only
+// moves its last_seen_at; a new address starts a new entry, after which
+// only the newest keep entries of that proxy are kept (zero keeps all).
+func (db *DB) RecordExitIPs(observations []models.ExitIP, keep int) error {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	for _, o := range observations {
+		result, err := tx.Exec(`
+		UPDATE proxy_exit_ips SET last_seen_at = ?
+		WHERE id = (SELECT MAX(id) FROM proxy_exit_ips WHERE proxy_id = ?) AND ip = ?
+		`, o.LastSeenAt, o.ProxyID, o.IP)
+		if err != nil {
+			return fmt.Errorf("failed to update exit ip: %w", err)
+		}
+		if n, err := result.RowsAffected(); err != nil {
+			return fmt.Errorf("failed to get rows affected: %w", err)
+		} else if n > 0 {
+			continue
+		}
+
+		_, err = tx.Exec(`
+		INSERT INTO proxy_exit_ips (proxy_id, ip, first_seen_at, last_seen_at)
+		VALUES (?, ?, ?, ?)
+		`, o.ProxyID, o.IP, o.FirstSeenAt, o.LastSeenAt)
+		if err != nil {
+			return fmt.Errorf("failed to add exit ip: %w", err)
+		}
+		if keep > 0 {
+			_, err = tx.Exec(`
+			DELETE FROM proxy_exit_ips
+			WHERE proxy_id = ? AND id NOT IN (
+				SELECT id FROM proxy_exit_ips WHERE proxy_id = ? ORDER BY id DESC LIMIT ?
+			)
+			`, o.ProxyID, o.ProxyID, keep)
+			if err != nil {
+				return fmt.Errorf("failed to prune exit ips: %w", err)
+			}
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit exit ips: %w", err)
+	}
+	return nil
+}
+
+// GetExitIPHistory returns up to limit exit IPs of a proxy, newest first
+func (db *DB) GetExitIPHistory(proxyID, limit int) ([]models.ExitIP, error) {
+	query := `
+	SELECT proxy_id, ip, first_seen_at, last_seen_at
+	FROM proxy_exit_ips
+	WHERE proxy_id = ?
+	ORDER BY id DESC
+	LIMIT ?
+	`
+	return db.queryExitIPs(query, proxyID, limit)
+}
+
+// GetExitIPsSince returns every exit IP entry last seen at or after since
+func (db *DB) GetExitIPsSince(since time.Time) ([]models.ExitIP, error) {
+	query := `
+	SELECT proxy_id, ip, first_seen_at, last_seen_at
+	FROM proxy_exit_ips
+	WHERE last_seen_at >= ?
+	ORDER BY id ASC
+	`
+	return db.queryExitIPs(query, since)
+}
+
+func (db *DB) queryExitIPs(query string, args ...interface{}) ([]models.ExitIP, error) {
+	rows, err := db.conn.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query exit ips: %w", err)
+	}
+	defer rows.Close()
+
+	var entries []models.ExitIP
+	for rows.Next() {
+		var e models.ExitIP
+		if err := rows.Scan(&e.ProxyID, &e.IP, &e.FirstSeenAt, &e.LastSeenAt); err != nil {
+			return nil, fmt.Errorf("failed to scan exit ip: %w", err)
+		}
+		entries = append(entries, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to query exit ips: %w", err)
+	}
+	return entries, nil
+}
@@ -0,0 +1,191 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"go-proxy-rotator/models"
+)
+
+// CreateHealthJob records a newly started health check job
+func (db *DB) CreateHealthJob(job *models.HealthJob) error {
+	query := `
+	INSERT INTO health_jobs (id, status, triggered_by, url, total, started_at)
+	VALUES (?, ?, ?, ?, ?, ?)
+	`
+	_, err := db.conn.Exec(query, job.ID, job.Status, job.Trigger, job.URL, job.Total, job.StartedAt)
+	if err != nil {
+		return fmt.Errorf("failed to create health job: %w", err)
+	}
+	return nil
+}
+
+// FinishHealthJob writes the final state of a job together with its
+// per-proxy results in a single transaction
+func (db *DB) FinishHealthJob(job *models.HealthJob) error {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+	UPDATE health_jobs
+	SET status = ?, checked = ?, passed = ?, failed = ?, error = ?, finished_at = ?
+	WHERE id = ?
+	`, job.Status, job.Checked, job.Passed, job.Failed, job.Error, job.FinishedAt, job.ID)
+	if err != nil {
+		return fmt.Errorf("failed to update health job: %w", err)
+	}
+
+	stmt, err := tx.Prepare(`
+	INSERT INTO health_job_results (job_id, proxy_id, host, port, success, response_time, error, error_class, status_code, anonymity, exit_ip, checked_at)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to prepare health job results: %w", err)
+	}
+	defer stmt.Close()
+
+	for _, r := range job.Results {
+		_, err := stmt.Exec(job.ID, r.ProxyID, r.Host, r.Port, r.Success, r.ResponseTime, r.Error, r.ErrorClass, r.StatusCode,
+			r.Anonymity, r.ExitIP, r.CheckedAt)
+		if err != nil {
+			return fmt.Errorf("failed to save health job result: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit health job: %w", err)
+	}
+	return nil
+}
+
+// GetHealthJob returns a job with its per-proxy results, or nil if there is
+// no job with that ID
+func (db *DB) GetHealthJob(id string) (*models.HealthJob, error) {
+	query := `
+	SELECT id, status, triggered_by, url, total, checked, passed, failed, error, started_at, finished_at
+	FROM health_jobs
+	WHERE id = ?
+	`
+	job, err := scanHealthJob(db.conn.QueryRow(query, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.conn.Query(`
+	SELECT proxy_id, host, port, success, response_time, error, error_class, status_code, anonymity, exit_ip, checked_at
+	FROM health_job_results
+	WHERE job_id = ?
+	ORDER BY checked_at ASC
+	`, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query health job results: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var r models.HealthCheckResult
+		err := rows.Scan(&r.ProxyID, &r.Host, &r.Port, &r.Success, &r.ResponseTime, &r.Error,
+			&r.ErrorClass, &r.StatusCode, &r.Anonymity, &r.ExitIP, &r.CheckedAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan health job result: %w", err)
+		}
+		job.Results = append(job.Results, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to query health job results: %w", err)
+	}
+
+	return job, nil
+}
+
+// ListHealthJobs returns the most recent jobs, newest first, without their
+// per-proxy results
+func (db *DB) ListHealthJobs(limit int) ([]*models.HealthJob, error) {
+	query := `
+	SELECT id, status, triggered_by, url, total, checked, passed, failed, error, started_at, finished_at
+	FROM health_jobs
+	ORDER BY started_at DESC
+	LIMIT ?
+	`
+
+	rows, err := db.conn.Query(query, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query health jobs: %w", err)
+	}
+	defer rows.Close()
+
+	var jobs []*models.HealthJob
+	for rows.Next() {
+		job, err := scanHealthJob(rows)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, job)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to query health jobs: %w", err)
+	}
+
+	return jobs, nil
+}
+
+// PruneHealthJobs deletes all but the newest keep jobs
+func (db *DB) PruneHealthJobs(keep int) error {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	newest := `SELECT id FROM health_jobs ORDER BY started_at DESC LIMIT ?`
+	if _, err := tx.Exec("DELETE FROM health_job_results WHERE job_id NOT IN ("+newest+")", keep); err != nil {
+		return fmt.Errorf("failed to prune health job results: %w", err)
+	}
+	if _, err := tx.Exec("DELETE FROM health_jobs WHERE id NOT IN ("+newest+")", keep); err != nil {
+		return fmt.Errorf("failed to prune health jobs: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit health job pruning: %w", err)
+	}
+	return nil
+}
+
+// InterruptHealthJobs marks jobs left running by a previous process as
+// failed
+func (db *DB) InterruptHealthJobs() error {
+	query := "UPDATE health_jobs SET status = ?, error = ? WHERE status = ?"
+	_, err := db.conn.Exec(query, models.HealthJobFailed, "interrupted", models.HealthJobRunning)
+	if err != nil {
+		return fmt.Errorf("failed to update interrupted health jobs: %w", err)
+	}
+	return nil
+}
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanHealthJob(row rowScanner) (*models.HealthJob, error) {
+	job := &models.HealthJob{}
+	var finishedAt sql.NullTime
+	err := row.Scan(&job.ID, &job.Status, &job.Trigger, &job.URL, &job.Total, &job.Checked,
+		&job.Passed, &job.Failed, &job.Error, &job.StartedAt, &finishedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan health job: %w", err)
+	}
+	if finishedAt.Valid {
+		job.FinishedAt = &finishedAt.Time
+	}
+	return job, nil
+}
@@ -0,0 +1,288 @@
+package database
+
+import (
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"go-proxy-rotator/models"
+)
+
+// Migrations live in migrations/<dialect>/NNNN_name.sql. Each one runs in
+// a transaction together with its row in schema_migrations, so it is
+// applied entirely or not at all. Applied migrations must never change;
+// schema changes go into a new file with the next number.
+//
+//go:embed migrations
+var migrationFiles embed.FS
+
+// ErrSchemaTooNew is returned by Migrate for a database migrated by a newer
+// version of the rotator.
+var ErrSchemaTooNew = errors.New("database schema is newer than this version supports")
+
+type migration struct {
+	version int
+	name    string
+	sql     string
+}
+
+// migrations returns the migrations of the dialect in order.
+func (d *dialect) migrations() ([]migration, error) {
+	dir := path.Join("migrations", d.name)
+	entries, err := migrationFiles.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read migrations: %w", err)
+	}
+
+	var list []migration
+	seen := make(map[int]string)
+	for _, entry := range entries {
+		file := entry.Name()
+		number, name, ok := strings.Cut(strings.TrimSuffix(file, ".sql"), "_")
+		version, err := strconv.Atoi(number)
+		if !ok || err != nil || version < 1 || !strings.HasSuffix(file, ".sql") {
+			return nil, fmt.Errorf("invalid migration file name %s", file)
+		}
+		if other, ok := seen[version]; ok {
+			return nil, fmt.Errorf("migrations %s and %s have the same version", other, file)
+		}
+		seen[version] = file
+
+		body, err := migrationFiles.ReadFile(path.Join(dir, file))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read migration %s: %w", file, err)
+		}
+		list = append(list, migration{version: version, name: name, sql: string(body)})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].version < list[j].version })
+	return list, nil
+}
+
+// Migrate applies the pending migrations in order and returns how many it
+// applied.
+func (db *DB) Migrate() (int, error) {
+	pending, err := db.conn.dialect.migrations()
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = db.conn.Exec(`
+	CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		name TEXT NOT NULL,
+		applied_at ` + db.conn.dialect.timestamp + ` NOT NULL
+	)`)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create schema_migrations: %w", err)
+	}
+	applied, err := db.appliedMigrations()
+	if err != nil {
+		return 0, err
+	}
+	if len(pending) > 0 {
+		latest := pending[len(pending)-1].version
+		for version := range applied {
+			if version > latest {
+				return 0, fmt.Errorf("%w: version %d, latest known %d", ErrSchemaTooNew, version, latest)
+			}
+		}
+	}
+
+	count := 0
+	first := len(applied) == 0
+	for _, m := range pending {
+		if _, ok := applied[m.version]; ok {
+			continue
+		}
+		ran, err := db.applyMigration(m, first)
+		if err != nil {
+			return count, err
+		}
+		if ran {
+			count++
+		}
+		first = false
+	}
+	return count, nil
+}
+
+// applyMigration runs m in a transaction and reports whether it ran, as
+// opposed to being applied by another instance in the meantime. first is
+// set for the first migration a database sees.
+func (db *DB) applyMigration(m migration, first bool) (bool, error) {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return false, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if lock := db.conn.dialect.lockMigrations; lock != "" {
+		if _, err := tx.Exec(lock); err != nil {
+			return false, fmt.Errorf("failed to lock migrations: %w", err)
+		}
+	}
+	var done int
+	if err := tx.QueryRow("SELECT COUNT(*) FROM schema_migrations WHERE version = ?", m.version).Scan(&done); err != nil {
+		return false, fmt.Errorf("failed to query schema_migrations: %w", err)
+	}
+	if done > 0 {
+		return false, nil
+	}
+
+	if first && db.conn.dialect == sqliteDialect {
+		if err := adoptLegacySchema(tx); err != nil {
+			return false, err
+		}
+	}
+	if _, err := tx.Exec(m.sql); err != nil {
+		return false, fmt.Errorf("failed to apply migration %04d_%s: %w", m.version, m.name, err)
+	}
+	_, err = tx.Exec("INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)",
+		m.version, m.name, time.Now())
+	if err != nil {
+		return false, fmt.Errorf("failed to record migration %04d_%s: %w", m.version, m.name, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("failed to commit migration %04d_%s: %w", m.version, m.name, err)
+	}
+	return true, nil
+}
+
+// Migrations returns every migration known to this version or applied to
+// the database, in order of version.
+func (db *DB) Migrations() ([]models.Migration, error) {
+	known, err := db.conn.dialect.migrations()
+	if err != nil {
+		return nil, err
+	}
+	applied, err := db.appliedMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	list := make([]models.Migration, 0, len(known))
+	for _, m := range known {
+		entry := models.Migration{Version: m.version, Name: m.name}
+		if a, ok := applied[m.version]; ok {
+			entry.Applied = true
+			entry.AppliedAt = a.AppliedAt
+			delete(applied, m.version)
+		}
+		list = append(list, entry)
+	}
+	for _, a := range applied {
+		a.Unknown = true
+		list = append(list, a)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Version < list[j].Version })
+	return list, nil
+}
+
+// appliedMigrations returns the rows of schema_migrations by version; none
+// if the table does not exist yet.
+func (db *DB) appliedMigrations() (map[int]models.Migration, error) {
+	applied := make(map[int]models.Migration)
+	exists, err := tableExists(db.conn, db.conn.dialect, "schema_migrations")
+	if err != nil || !exists {
+		return applied, err
+	}
+
+	rows, err := db.conn.Query("SELECT version, name, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, fmt.Errorf("failed to query schema_migrations: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		m := models.Migration{Applied: true}
+		var appliedAt time.Time
+		if err := rows.Scan(&m.Version, &m.Name, &appliedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan schema_migrations: %w", err)
+		}
+		m.AppliedAt = &appliedAt
+		applied[m.Version] = m
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to query schema_migrations: %w", err)
+	}
+	return applied, nil
+}
+
+type rowQuerier interface {
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+func tableExists(q rowQuerier, d *dialect, table string) (bool, error) {
+	var n int
+	if err := q.QueryRow(d.tableExists, table).Scan(&n); err != nil {
+		return false, fmt.Errorf("failed to look up table %s: %w", table, err)
+	}
+	return n > 0, nil
+}
+
+// adoptLegacySchema brings a SQLite database created before migrations
+// existed up to the schema of the first migration. Its tables were created
+// by the release that made the file and only grew new columns on later
+// starts, so any of those may be missing.
+func adoptLegacySchema(tx *tx) error {
+	legacy := map[string][]struct{ name, definition string }{
+		"proxies": {
+			{"tags", "TEXT DEFAULT ''"},
+			{"country", "TEXT DEFAULT ''"},
+			{"state", "TEXT DEFAULT 'active'"},
+			{"next_probe_at", "DATETIME"},
+			{"cooldowns", "INTEGER DEFAULT 0"},
+			{"success_count", "INTEGER DEFAULT 0"},
+			{"anonymity", "TEXT DEFAULT ''"},
+			{"exit_ip", "TEXT DEFAULT ''"},
+			{"exit_ip_seen_at", "DATETIME"},
+		},
+		"health_job_results": {
+			{"error_class", "TEXT DEFAULT ''"},
+			{"status_code", "INTEGER DEFAULT 0"},
+			{"anonymity", "TEXT DEFAULT ''"},
+			{"exit_ip", "TEXT DEFAULT ''"},
+		},
+	}
+	for table, columns := range legacy {
+		exists, err := tableExists(tx, tx.dialect, table)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			continue
+		}
+		for _, column := range columns {
+			if err := addColumnIfMissing(tx, table, column.name, column.definition); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// addColumnIfMissing adds column to a SQLite table unless it already
+// exists.
+func addColumnIfMissing(tx *tx, table, column, definition string) error {
+	var n int
+	err := tx.QueryRow("SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?", table, column).Scan(&n)
+	if err != nil {
+		return fmt.Errorf("failed to inspect table %s: %w", table, err)
+	}
+	if n > 0 {
+		return nil
+	}
+
+	_, err = tx.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+	if err != nil {
+		return fmt.Errorf("failed to add column %s.%s: %w", table, column, err)
+	}
+	return nil
+}
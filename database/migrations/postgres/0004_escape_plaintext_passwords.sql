@@ -0,0 +1,5 @@
+-- Plaintext passwords that start with the escape prefix read back without
+-- it. Escape the ones written before the prefix existed, so they keep
+-- their value.
+
+UPDATE proxies SET password = 'plain:v1:' || password WHERE substr(password, 1, 9) = 'plain:v1:';
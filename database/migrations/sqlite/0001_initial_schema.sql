@@ -0,0 +1,132 @@
+-- Schema at the time migrations were introduced. IF NOT EXISTS lets it
+-- adopt databases created before then, whose missing columns are added
+-- before it runs.
+
+CREATE TABLE IF NOT EXISTS proxies (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	host TEXT NOT NULL,
+	port INTEGER NOT NULL,
+	username TEXT DEFAULT '',
+	password TEXT DEFAULT '',
+	protocol TEXT DEFAULT 'http',
+	is_active BOOLEAN DEFAULT 1,
+	last_checked DATETIME DEFAULT CURRENT_TIMESTAMP,
+	response_time INTEGER DEFAULT 0,
+	fail_count INTEGER DEFAULT 0,
+	state TEXT DEFAULT 'active',
+	next_probe_at DATETIME,
+	cooldowns INTEGER DEFAULT 0,
+	success_count INTEGER DEFAULT 0,
+	tags TEXT DEFAULT '',
+	country TEXT DEFAULT '',
+	anonymity TEXT DEFAULT '',
+	exit_ip TEXT DEFAULT '',
+	exit_ip_seen_at DATETIME,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	UNIQUE(host, port)
+);
+
+CREATE INDEX IF NOT EXISTS idx_proxies_active ON proxies(is_active);
+CREATE INDEX IF NOT EXISTS idx_proxies_health ON proxies(is_active, fail_count);
+
+CREATE TABLE IF NOT EXISTS health_jobs (
+	id TEXT PRIMARY KEY,
+	status TEXT NOT NULL,
+	triggered_by TEXT NOT NULL,
+	url TEXT NOT NULL,
+	total INTEGER DEFAULT 0,
+	checked INTEGER DEFAULT 0,
+	passed INTEGER DEFAULT 0,
+	failed INTEGER DEFAULT 0,
+	error TEXT DEFAULT '',
+	started_at DATETIME NOT NULL,
+	finished_at DATETIME
+);
+
+CREATE INDEX IF NOT EXISTS idx_health_jobs_started ON health_jobs(started_at);
+
+CREATE TABLE IF NOT EXISTS health_job_results (
+	job_id TEXT NOT NULL REFERENCES health_jobs(id) ON DELETE CASCADE,
+	proxy_id INTEGER NOT NULL,
+	host TEXT NOT NULL,
+	port INTEGER NOT NULL,
+	success BOOLEAN NOT NULL,
+	response_time INTEGER DEFAULT 0,
+	error TEXT DEFAULT '',
+	error_class TEXT DEFAULT '',
+	status_code INTEGER DEFAULT 0,
+	anonymity TEXT DEFAULT '',
+	exit_ip TEXT DEFAULT '',
+	checked_at DATETIME NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_health_job_results_job ON health_job_results(job_id);
+
+CREATE TABLE IF NOT EXISTS proxy_domains (
+	proxy_id INTEGER NOT NULL,
+	domain TEXT NOT NULL,
+	requests INTEGER DEFAULT 0,
+	successes INTEGER DEFAULT 0,
+	failures INTEGER DEFAULT 0,
+	bans INTEGER DEFAULT 0,
+	fail_count INTEGER DEFAULT 0,
+	cooldowns INTEGER DEFAULT 0,
+	cooldown_until DATETIME,
+	last_used_at DATETIME NOT NULL,
+	PRIMARY KEY (proxy_id, domain)
+);
+
+CREATE INDEX IF NOT EXISTS idx_proxy_domains_last_used ON proxy_domains(last_used_at);
+
+CREATE TABLE IF NOT EXISTS proxy_exit_ips (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	proxy_id INTEGER NOT NULL,
+	ip TEXT NOT NULL,
+	first_seen_at DATETIME NOT NULL,
+	last_seen_at DATETIME NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_proxy_exit_ips_proxy ON proxy_exit_ips(proxy_id);
+CREATE INDEX IF NOT EXISTS idx_proxy_exit_ips_last_seen ON proxy_exit_ips(last_seen_at);
+
+CREATE TABLE IF NOT EXISTS proxy_checks (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	proxy_id INTEGER NOT NULL,
+	checked_at DATETIME NOT NULL,
+	latency INTEGER DEFAULT 0,
+	success BOOLEAN NOT NULL,
+	error_class TEXT DEFAULT '',
+	status_code INTEGER DEFAULT 0,
+	exit_ip TEXT DEFAULT ''
+);
+
+CREATE INDEX IF NOT EXISTS idx_proxy_checks_proxy ON proxy_checks(proxy_id, checked_at);
+CREATE INDEX IF NOT EXISTS idx_proxy_checks_checked ON proxy_checks(checked_at);
+
+CREATE TABLE IF NOT EXISTS proxy_check_rollups (
+	proxy_id INTEGER NOT NULL,
+	hour DATETIME NOT NULL,
+	checks INTEGER DEFAULT 0,
+	successes INTEGER DEFAULT 0,
+	latency_p50 INTEGER DEFAULT 0,
+	latency_p95 INTEGER DEFAULT 0,
+	latency_p99 INTEGER DEFAULT 0,
+	PRIMARY KEY (proxy_id, hour)
+);
+
+CREATE INDEX IF NOT EXISTS idx_proxy_check_rollups_hour ON proxy_check_rollups(hour);
+
+CREATE TABLE IF NOT EXISTS ban_rules (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	name TEXT NOT NULL,
+	domain TEXT DEFAULT '',
+	status_codes TEXT DEFAULT '',
+	header TEXT DEFAULT '',
+	header_pattern TEXT DEFAULT '',
+	body_pattern TEXT DEFAULT '',
+	scope TEXT DEFAULT 'proxy',
+	enabled BOOLEAN DEFAULT 1,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
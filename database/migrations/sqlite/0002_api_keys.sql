@@ -0,0 +1,12 @@
+-- API keys of the management API. Only a SHA-256 hash of each key is
+-- stored; prefix is its first characters, kept to tell keys apart.
+
+CREATE TABLE api_keys (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	name TEXT NOT NULL,
+	prefix TEXT NOT NULL,
+	key_hash TEXT NOT NULL UNIQUE,
+	created_at DATETIME NOT NULL,
+	last_used_at DATETIME,
+	revoked_at DATETIME
+);
@@ -0,0 +1,4 @@
+-- Roles of API keys: viewer, operator or admin. Keys created before roles
+-- existed had full access and keep it.
+
+ALTER TABLE api_keys ADD COLUMN role TEXT NOT NULL DEFAULT 'admin';
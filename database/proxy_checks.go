@@ -0,0 +1,197 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"go-proxy-rotator/models"
+)
+
+// RecordProxyChecks adds health checks to the check history in a single
+// transaction
+func (db *DB) RecordProxyChecks(checks []models.ProxyCheck) error {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(`
+	INSERT INTO proxy_checks (proxy_id, checked_at, latency, success, error_class, status_code, exit_ip)
+	VALUES (?, ?, ?, ?, ?, ?, ?)
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to prepare proxy checks: %w", err)
+	}
+	defer stmt.Close()
+
+	for _, c := range checks {
+		_, err := stmt.Exec(c.ProxyID, c.CheckedAt, c.Latency, c.Success, c.ErrorClass, c.StatusCode, c.ExitIP)
+		if err != nil {
+			return fmt.Errorf("failed to save proxy check: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit proxy checks: %w", err)
+	}
+	return nil
+}
+
+// GetProxyChecks returns the checks of a proxy made in [from, to), newest
+// first; a limit of zero returns all of them
+func (db *DB) GetProxyChecks(proxyID int, from, to time.Time, limit int) ([]models.ProxyCheck, error) {
+	query := `
+	SELECT ` + proxyCheckColumns + `
+	FROM proxy_checks
+	WHERE proxy_id = ? AND checked_at >= ? AND checked_at < ?
+	ORDER BY checked_at DESC
+	`
+	if limit <= 0 {
+		return db.queryProxyChecks(query, proxyID, from, to)
+	}
+	return db.queryProxyChecks(query+"LIMIT ?", proxyID, from, to, limit)
+}
+
+// GetChecksBetween returns the checks of every proxy made in [from, to)
+func (db *DB) GetChecksBetween(from, to time.Time) ([]models.ProxyCheck, error) {
+	query := `
+	SELECT ` + proxyCheckColumns + `
+	FROM proxy_checks
+	WHERE checked_at >= ? AND checked_at < ?
+	`
+	return db.queryProxyChecks(query, from, to)
+}
+
+// EarliestProxyCheck returns when the oldest check in the history was
+// made; ok is false if the history is empty
+func (db *DB) EarliestProxyCheck() (at time.Time, ok bool, err error) {
+	err = db.conn.QueryRow("SELECT checked_at FROM proxy_checks ORDER BY checked_at ASC LIMIT 1").Scan(&at)
+	if errors.Is(err, sql.ErrNoRows) {
+		return time.Time{}, false, nil
+	}
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf("failed to query proxy checks: %w", err)
+	}
+	return at, true, nil
+}
+
+// PruneProxyChecks deletes the checks made before before
+func (db *DB) PruneProxyChecks(before time.Time) error {
+	if _, err := db.conn.Exec("DELETE FROM proxy_checks WHERE checked_at < ?", before); err != nil {
+		return fmt.Errorf("failed to prune proxy checks: %w", err)
+	}
+	return nil
+}
+
+// SaveCheckRollups inserts or replaces the given rollups in a single
+// transaction
+func (db *DB) SaveCheckRollups(rollups []models.CheckRollup) error {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(`
+	INSERT INTO proxy_check_rollups (proxy_id, hour, checks, successes, latency_p50, latency_p95, latency_p99)
+	VALUES (?, ?, ?, ?, ?, ?, ?)
+	ON CONFLICT (proxy_id, hour) DO UPDATE SET
+		checks = excluded.checks, successes = excluded.successes,
+		latency_p50 = excluded.latency_p50, latency_p95 = excluded.latency_p95,
+		latency_p99 = excluded.latency_p99
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to prepare check rollups: %w", err)
+	}
+	defer stmt.Close()
+
+	for _, r := range rollups {
+		_, err := stmt.Exec(r.ProxyID, r.Hour, r.Checks, r.Successes, r.P50, r.P95, r.P99)
+		if err != nil {
+			return fmt.Errorf("failed to save check rollup: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit check rollups: %w", err)
+	}
+	return nil
+}
+
+// GetCheckRollups returns the rollups of a proxy for the hours starting in
+// [from, to), oldest first
+func (db *DB) GetCheckRollups(proxyID int, from, to time.Time) ([]models.CheckRollup, error) {
+	rows, err := db.conn.Query(`
+	SELECT proxy_id, hour, checks, successes, latency_p50, latency_p95, latency_p99
+	FROM proxy_check_rollups
+	WHERE proxy_id = ? AND hour >= ? AND hour < ?
+	ORDER BY hour ASC
+	`, proxyID, from, to)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query check rollups: %w", err)
+	}
+	defer rows.Close()
+
+	var rollups []models.CheckRollup
+	for rows.Next() {
+		var r models.CheckRollup
+		if err := rows.Scan(&r.ProxyID, &r.Hour, &r.Checks, &r.Successes, &r.P50, &r.P95, &r.P99); err != nil {
+			return nil, fmt.Errorf("failed to scan check rollup: %w", err)
+		}
+		rollups = append(rollups, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to query check rollups: %w", err)
+	}
+	return rollups, nil
+}
+
+// LatestCheckRollup returns the hour of the newest rollup; ok is false if
+// there is none
+func (db *DB) LatestCheckRollup() (hour time.Time, ok bool, err error) {
+	err = db.conn.QueryRow("SELECT hour FROM proxy_check_rollups ORDER BY hour DESC LIMIT 1").Scan(&hour)
+	if errors.Is(err, sql.ErrNoRows) {
+		return time.Time{}, false, nil
+	}
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf("failed to query check rollups: %w", err)
+	}
+	return hour, true, nil
+}
+
+// PruneCheckRollups deletes the rollups of hours starting before before
+func (db *DB) PruneCheckRollups(before time.Time) error {
+	if _, err := db.conn.Exec("DELETE FROM proxy_check_rollups WHERE hour < ?", before); err != nil {
+		return fmt.Errorf("failed to prune check rollups: %w", err)
+	}
+	return nil
+}
+
+// proxyCheckColumns lists the proxy_checks columns in the order
+// queryProxyChecks reads them.
+const proxyCheckColumns = `proxy_id, checked_at, latency, success, error_class, status_code, exit_ip`
+
+func (db *DB) queryProxyChecks(query string, args ...interface{}) ([]models.ProxyCheck, error) {
+	rows, err := db.conn.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query proxy checks: %w", err)
+	}
+	defer rows.Close()
+
+	var checks []models.ProxyCheck
+	for rows.Next() {
+		var c models.ProxyCheck
+		err := rows.Scan(&c.ProxyID, &c.CheckedAt, &c.Latency, &c.Success, &c.ErrorClass, &c.StatusCode, &c.ExitIP)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan proxy check: %w", err)
+		}
+		checks = append(checks, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to query proxy checks: %w", err)
+	}
+	return checks, nil
+}
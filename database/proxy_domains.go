@@ -0,0 +1,105 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"go-proxy-rotator/models"
+)
+
+// GetDomainHealth returns the per-domain health of every proxy
+func (db *DB) GetDomainHealth() ([]*models.DomainHealth, error) {
+	query := `
+	SELECT proxy_id, domain, requests, successes, failures, bans, fail_count,
+		   cooldowns, cooldown_until, last_used_at
+	FROM proxy_domains
+	`
+
+	rows, err := db.conn.Query(query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query domain health: %w", err)
+	}
+	defer rows.Close()
+
+	var entries []*models.DomainHealth
+	for rows.Next() {
+		entry := &models.DomainHealth{}
+		var cooldownUntil sql.NullTime
+		err := rows.Scan(&entry.ProxyID, &entry.Domain, &entry.Requests, &entry.Successes,
+			&entry.Failures, &entry.Bans, &entry.FailCount, &entry.Cooldowns,
+			&cooldownUntil, &entry.LastUsedAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan domain health: %w", err)
+		}
+		if cooldownUntil.Valid {
+			entry.CooldownUntil = &cooldownUntil.Time
+		}
+		entries = append(entries, entry)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to query domain health: %w", err)
+	}
+
+	return entries, nil
+}
+
+// SaveDomainHealth inserts or updates the given entries in a single
+// transaction
+func (db *DB) SaveDomainHealth(entries []*models.DomainHealth) error {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(`
+	INSERT INTO proxy_domains (proxy_id, domain, requests, successes, failures, bans,
+		fail_count, cooldowns, cooldown_until, last_used_at)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	ON CONFLICT (proxy_id, domain) DO UPDATE SET
+		requests = excluded.requests, successes = excluded.successes,
+		failures = excluded.failures, bans = excluded.bans,
+		fail_count = excluded.fail_count, cooldowns = excluded.cooldowns,
+		cooldown_until = excluded.cooldown_until, last_used_at = excluded.last_used_at
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to prepare domain health update: %w", err)
+	}
+	defer stmt.Close()
+
+	for _, e := range entries {
+		_, err := stmt.Exec(e.ProxyID, e.Domain, e.Requests, e.Successes, e.Failures,
+			e.Bans, e.FailCount, e.Cooldowns, e.CooldownUntil, e.LastUsedAt)
+		if err != nil {
+			return fmt.Errorf("failed to update domain health: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit domain health updates: %w", err)
+	}
+	return nil
+}
+
+// DeleteDomainHealth deletes the health of one proxy for one domain
+func (db *DB) DeleteDomainHealth(proxyID int, domain string) error {
+	query := "DELETE FROM proxy_domains WHERE proxy_id = ? AND domain = ?"
+	if _, err := db.conn.Exec(query, proxyID, domain); err != nil {
+		return fmt.Errorf("failed to delete domain health: %w", err)
+	}
+	return nil
+}
+
+// PruneDomainHealth deletes entries unused since before that are not in
+// cooldown
+func (db *DB) PruneDomainHealth(before time.Time) error {
+	query := `
+	DELETE FROM proxy_domains
+	WHERE last_used_at < ? AND (cooldown_until IS NULL OR cooldown_until < ?)
+	`
+	if _, err := db.conn.Exec(query, before, time.Now()); err != nil {
+		return fmt.Errorf("failed to prune domain health: %w", err)
+	}
+	return nil
+}
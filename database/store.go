@@ -0,0 +1,76 @@
+package database
+
+import (
+	"errors"
+	"time"
+
+	"go-proxy-rotator/models"
+)
+
+// ErrDuplicateProxy is returned by AddProxy for a host and port that are
+// already stored.
+var ErrDuplicateProxy = errors.New("proxy already exists")
+
+// Store is the persistent storage of the rotator. DB implements it on
+// SQLite and on PostgreSQL; several rotator instances pointed at the same
+// PostgreSQL database share one pool.
+type Store interface {
+	Close() error
+	// Migrations reports the state of every schema migration.
+	Migrations() ([]models.Migration, error)
+
+	// Proxies
+	AddProxy(proxy *models.Proxy) error
+	GetActiveProxies() ([]*models.Proxy, error)
+	GetAllProxies() ([]*models.Proxy, error)
+	SaveProxyHealth(proxies []*models.Proxy) error
+	UpdateProxyLabels(proxy *models.Proxy) error
+	DeleteProxy(id int) error
+	GetProxyStats() (*models.ProxyStats, error)
+	ClearAllProxies() error
+
+	// Health check jobs
+	CreateHealthJob(job *models.HealthJob) error
+	FinishHealthJob(job *models.HealthJob) error
+	GetHealthJob(id string) (*models.HealthJob, error)
+	ListHealthJobs(limit int) ([]*models.HealthJob, error)
+	PruneHealthJobs(keep int) error
+	InterruptHealthJobs() error
+
+	// Per-domain health
+	GetDomainHealth() ([]*models.DomainHealth, error)
+	SaveDomainHealth(entries []*models.DomainHealth) error
+	DeleteDomainHealth(proxyID int, domain string) error
+	PruneDomainHealth(before time.Time) error
+
+	// Exit IPs
+	RecordExitIPs(observations []models.ExitIP, keep int) error
+	GetExitIPHistory(proxyID, limit int) ([]models.ExitIP, error)
+	GetExitIPsSince(since time.Time) ([]models.ExitIP, error)
+
+	// Check history
+	RecordProxyChecks(checks []models.ProxyCheck) error
+	GetProxyChecks(proxyID int, from, to time.Time, limit int) ([]models.ProxyCheck, error)
+	GetChecksBetween(from, to time.Time) ([]models.ProxyCheck, error)
+	EarliestProxyCheck() (at time.Time, ok bool, err error)
+	PruneProxyChecks(before time.Time) error
+	SaveCheckRollups(rollups []models.CheckRollup) error
+	GetCheckRollups(proxyID int, from, to time.Time) ([]models.CheckRollup, error)
+	LatestCheckRollup() (hour time.Time, ok bool, err error)
+	PruneCheckRollups(before time.Time) error
+
+	// Ban rules
+	AddBanRule(rule *models.BanRule) error
+	GetBanRules() ([]*models.BanRule, error)
+	UpdateBanRule(rule *models.BanRule) error
+	DeleteBanRule(id int) error
+
+	// API keys
+	AddAPIKey(key *models.APIKey) error
+	GetAPIKeyByHash(hash string) (*models.APIKey, error)
+	ListAPIKeys() ([]*models.APIKey, error)
+	RevokeAPIKey(id int, at time.Time) error
+	TouchAPIKey(id int, at time.Time) error
+}
+
+var _ Store = (*DB)(nil)
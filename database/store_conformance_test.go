@@ -0,0 +1,48 @@
+package database_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"go-proxy-rotator/database"
+	"go-proxy-rotator/database/conformance"
+)
+
+// TestStoreConformance holds every backend to the contract of
+// database.Store: SQLite always, PostgreSQL when TEST_POSTGRES_DSN names
+// an empty database.
+func TestStoreConformance(t *testing.T) {
+	t.Run("sqlite", func(t *testing.T) {
+		runConformance(t, filepath.Join(t.TempDir(), "conformance.db"))
+	})
+	t.Run("postgres", func(t *testing.T) {
+		dsn := os.Getenv("TEST_POSTGRES_DSN")
+		if dsn == "" {
+			t.Skip("TEST_POSTGRES_DSN is not set")
+		}
+		runConformance(t, dsn)
+	})
+}
+
+func runConformance(t *testing.T, dsn string) {
+	t.Helper()
+
+	db, err := database.New(dsn)
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	results, err := conformance.Run(db)
+	if err != nil {
+		t.Fatalf("failed to run conformance suite: %v", err)
+	}
+	for _, r := range results {
+		t.Run(r.Name, func(t *testing.T) {
+			if r.Err != nil {
+				t.Error(r.Err)
+			}
+		})
+	}
+}
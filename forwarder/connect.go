@@ -0,0 +1,124 @@
+package forwarder
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"go-proxy-rotator/models"
+	"go-proxy-rotator/socks"
+)
+
+// Dial opens a connection to target ("host:port") tunneled through the
+// upstream proxy, using an HTTP CONNECT request or a SOCKS handshake
+// depending on the proxy's protocol.
+func (f *Forwarder) Dial(ctx context.Context, proxy *models.Proxy, target string) (net.Conn, error) {
+	var conn net.Conn
+	var err error
+	if socks.IsSOCKS(proxy.Protocol) {
+		var d *socks.Dialer
+		if d, err = socksDialer(proxy, f.timeout); err == nil {
+			conn, err = d.DialContext(ctx, "tcp", target)
+		}
+	} else {
+		conn, err = f.dialConnect(ctx, proxy, target)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("upstream %s:%d: %w", proxy.Host, proxy.Port, err)
+	}
+	return conn, nil
+}
+
+func (f *Forwarder) dialConnect(ctx context.Context, proxy *models.Proxy, target string) (net.Conn, error) {
+	if f.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, f.timeout)
+		defer cancel()
+	}
+
+	proxyAddr := net.JoinHostPort(proxy.Host, fmt.Sprint(proxy.Port))
+	dialer := &net.Dialer{KeepAlive: 30 * time.Second}
+	conn, err := dialer.DialContext(ctx, "tcp", proxyAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	if proxy.Protocol == "https" {
+		tlsConn := tls.Client(conn, &tls.Config{ServerName: proxy.Host})
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		conn = tlsConn
+	}
+
+	// Bound the handshake by the context deadline, then clear it so the
+	// tunnel itself is governed by the caller's idle timeout.
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: target},
+		Host:   target,
+		Header: make(http.Header),
+	}
+	if proxy.Username != "" && proxy.Password != "" {
+		req.Header.Set("Proxy-Authorization", basicAuth(proxy.Username, proxy.Password))
+	}
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to send CONNECT: %w", err)
+	}
+
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to read CONNECT response: %w", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		conn.Close()
+		return nil, fmt.Errorf("CONNECT %s rejected: %s", target, resp.Status)
+	}
+
+	conn.SetDeadline(time.Time{})
+
+	// The proxy may have sent tunneled bytes right behind its response.
+	if br.Buffered() > 0 {
+		return &bufferedConn{Conn: conn, r: br}, nil
+	}
+	return conn, nil
+}
+
+// bufferedConn is a net.Conn whose first reads drain a bufio.Reader that
+// already consumed data from the underlying connection.
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
+
+func (c *bufferedConn) CloseWrite() error {
+	if cw, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return c.Conn.Close()
+}
+
+// basicAuth returns the value of a Basic authorization header.
+func basicAuth(username, password string) string {
+	auth := username + ":" + password
+	return "Basic " + base64.StdEncoding.EncodeToString([]byte(auth))
+}
@@ -0,0 +1,143 @@
+package forwarder
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"go-proxy-rotator/models"
+)
+
+// startConnectProxy starts an upstream that accepts CONNECT requests and
+// plays the origin itself: it reads the tunneled data until the client
+// half-closes, then answers "got:<data>" and closes. reply is written as
+// the CONNECT response; an empty reply never answers.
+func startConnectProxy(t *testing.T, wantAuth, reply string) *models.Proxy {
+	t.Helper()
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodConnect || r.Host != "origin.test:443" {
+			http.Error(w, "unexpected request", http.StatusBadRequest)
+			return
+		}
+		if r.Header.Get("Proxy-Authorization") != wantAuth {
+			w.WriteHeader(http.StatusProxyAuthRequired)
+			return
+		}
+		conn, brw, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		if reply == "" {
+			// Hold the connection until the client gives up
+			io.Copy(io.Discard, brw)
+			return
+		}
+		io.WriteString(conn, reply)
+
+		data, _ := io.ReadAll(brw)
+		io.WriteString(conn, "got:"+string(data))
+	}))
+	t.Cleanup(upstream.Close)
+
+	host, port, _ := net.SplitHostPort(upstream.Listener.Addr().String())
+	portNum, _ := strconv.Atoi(port)
+	return &models.Proxy{ID: 1, Host: host, Port: portNum, Protocol: "http"}
+}
+
+func TestDialConnect(t *testing.T) {
+	const established = "HTTP/1.1 200 Connection established\r\n\r\n"
+	tests := []struct {
+		name     string
+		username string
+		password string
+		wantAuth string
+		reply    string
+		want     string // everything read from the tunnel
+		wantErr  string
+	}{
+		{name: "tunnel", reply: established, want: "got:ping"},
+		{name: "credentials", username: "user", password: "secret", wantAuth: basicAuth("user", "secret"),
+			reply: established, want: "got:ping"},
+		{name: "credentials rejected", username: "user", password: "wrong", wantAuth: basicAuth("user", "secret"),
+			wantErr: "407 Proxy Authentication Required"},
+		{name: "data right behind the response", reply: established + "banner;", want: "banner;got:ping"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			proxy := startConnectProxy(t, tt.wantAuth, tt.reply)
+			proxy.Username, proxy.Password = tt.username, tt.password
+
+			f := New(200 * time.Millisecond)
+			conn, err := f.Dial(context.Background(), proxy, "origin.test:443")
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Dial error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+
+			// The tunnel outlives the dial timeout and supports half-close
+			time.Sleep(300 * time.Millisecond)
+			conn.SetDeadline(time.Now().Add(5 * time.Second))
+			io.WriteString(conn, "ping")
+			cw, ok := conn.(interface{ CloseWrite() error })
+			if !ok {
+				t.Fatalf("%T does not support CloseWrite", conn)
+			}
+			cw.CloseWrite()
+			got, err := io.ReadAll(conn)
+			if err != nil || string(got) != tt.want {
+				t.Errorf("read %q, %v; want %q", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestDialConnectErrors(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := l.Addr().(*net.TCPAddr).Port
+	l.Close()
+
+	silent := startConnectProxy(t, "", "")
+
+	tests := []struct {
+		name    string
+		proxy   *models.Proxy
+		refused bool
+		timeout bool
+	}{
+		{name: "proxy down", proxy: &models.Proxy{ID: 2, Host: "127.0.0.1", Port: port, Protocol: "http"}, refused: true},
+		{name: "proxy silent", proxy: silent, timeout: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := New(100*time.Millisecond).Dial(context.Background(), tt.proxy, "origin.test:443")
+			if err == nil {
+				t.Fatal("Dial succeeded")
+			}
+			if refused := errors.Is(err, syscall.ECONNREFUSED); refused != tt.refused {
+				t.Errorf("Dial error %v: refused = %v, want %v", err, refused, tt.refused)
+			}
+			var ne net.Error
+			if timeout := errors.As(err, &ne) && ne.Timeout(); timeout != tt.timeout {
+				t.Errorf("Dial error %v: timeout = %v, want %v", err, timeout, tt.timeout)
+			}
+		})
+	}
+}
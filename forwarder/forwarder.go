@@ -0,0 +1,155 @@
+package forwarder
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"go-proxy-rotator/models"
+	"go-proxy-rotator/socks"
+)
+
+// Forwarder sends client requests through upstream proxies over HTTP,
+// HTTPS or SOCKS. It keeps one http.Transport per upstream so keep-alive
+// connections to each proxy are reused across requests.
+type Forwarder struct {
+	timeout time.Duration
+
+	mu         sync.Mutex
+	transports map[int]*upstreamTransport
+}
+
+type upstreamTransport struct {
+	key       string
+	transport *http.Transport
+}
+
+// New creates a forwarder. timeout bounds dialing the upstream and waiting
+// for the response headers of a forwarded request.
+func New(timeout time.Duration) *Forwarder {
+	return &Forwarder{
+		timeout:    timeout,
+		transports: make(map[int]*upstreamTransport),
+	}
+}
+
+// RoundTrip sends req through the given upstream proxy. req must carry an
+// absolute URL; the caller owns the returned response body.
+func (f *Forwarder) RoundTrip(proxy *models.Proxy, req *http.Request) (*http.Response, error) {
+	transport, err := f.transport(proxy)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := transport.RoundTrip(req)
+	if err != nil {
+		return nil, fmt.Errorf("upstream %s:%d: %w", proxy.Host, proxy.Port, err)
+	}
+	return resp, nil
+}
+
+// Client returns an HTTP client that sends every request through proxy,
+// sharing the forwarder's cached transport for it. Unlike RoundTrip it
+// follows redirects.
+func (f *Forwarder) Client(proxy *models.Proxy) *http.Client {
+	return &http.Client{Transport: proxyRoundTripper{f: f, proxy: proxy}}
+}
+
+type proxyRoundTripper struct {
+	f     *Forwarder
+	proxy *models.Proxy
+}
+
+func (rt proxyRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	return rt.f.RoundTrip(rt.proxy, req)
+}
+
+// Forget drops the cached transport for a proxy, closing its idle
+// connections. It should be called when a proxy is removed.
+func (f *Forwarder) Forget(proxyID int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if t, ok := f.transports[proxyID]; ok {
+		t.transport.CloseIdleConnections()
+		delete(f.transports, proxyID)
+	}
+}
+
+// transport returns the cached transport for proxy, rebuilding it when the
+// proxy's address or credentials have changed.
+func (f *Forwarder) transport(proxy *models.Proxy) (*http.Transport, error) {
+	key := fmt.Sprintf("%s|%s|%s", proxy.GetURL(), proxy.Username, proxy.Password)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if t, ok := f.transports[proxy.ID]; ok {
+		if t.key == key {
+			return t.transport, nil
+		}
+		t.transport.CloseIdleConnections()
+	}
+
+	transport, err := NewTransport(proxy, f.timeout)
+	if err != nil {
+		return nil, err
+	}
+	f.transports[proxy.ID] = &upstreamTransport{key: key, transport: transport}
+	return transport, nil
+}
+
+// NewTransport returns an http.Transport that sends every request through
+// proxy. HTTP(S) upstreams receive absolute-form requests and CONNECT
+// tunnels carrying Proxy-Authorization; SOCKS upstreams are used as the
+// dialer for direct connections to the origin.
+func NewTransport(proxy *models.Proxy, timeout time.Duration) (*http.Transport, error) {
+	dialer := &net.Dialer{Timeout: timeout, KeepAlive: 30 * time.Second}
+	transport := &http.Transport{
+		DialContext:           dialer.DialContext,
+		MaxIdleConnsPerHost:   16,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   timeout,
+		ResponseHeaderTimeout: timeout,
+		// Pass bodies through untouched; the client negotiates encoding.
+		DisableCompression: true,
+	}
+
+	if socks.IsSOCKS(proxy.Protocol) {
+		d, err := socksDialer(proxy, timeout)
+		if err != nil {
+			return nil, err
+		}
+		transport.DialContext = d.DialContext
+		return transport, nil
+	}
+
+	proxyURL, err := upstreamURL(proxy)
+	if err != nil {
+		return nil, err
+	}
+	transport.Proxy = http.ProxyURL(proxyURL)
+	return transport, nil
+}
+
+func socksDialer(proxy *models.Proxy, timeout time.Duration) (*socks.Dialer, error) {
+	addr := net.JoinHostPort(proxy.Host, strconv.Itoa(proxy.Port))
+	return socks.NewDialer(proxy.Protocol, addr, proxy.Username, proxy.Password, timeout)
+}
+
+// upstreamURL builds the proxy URL including credentials, which the
+// transport turns into a Proxy-Authorization header.
+func upstreamURL(proxy *models.Proxy) (*url.URL, error) {
+	u, err := url.Parse(proxy.GetURL())
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy URL: %w", err)
+	}
+	if proxy.Username != "" && proxy.Password != "" {
+		u.User = url.UserPassword(proxy.Username, proxy.Password)
+	}
+	return u, nil
+}
@@ -0,0 +1,225 @@
+package forwarder
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/textproto"
+	"strings"
+	"sync"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// ErrNotProxyRequest is returned for requests that are not in absolute-form,
+// i.e. clients talking to the rotator as an origin server instead of a proxy.
+var ErrNotProxyRequest = errors.New("request target must be an absolute URI")
+
+// ErrBodyTooLarge is returned for request bodies over BodyLimits.Max, up
+// front when the client declared the length and otherwise once the body
+// is read past it.
+var ErrBodyTooLarge = errors.New("request body too large")
+
+// BodyLimits bound the request bodies NewRequest accepts.
+type BodyLimits struct {
+	// Replay is the largest body that is buffered, so that the request can
+	// be sent more than once; larger bodies are streamed from the client.
+	Replay int64
+	// Max is the largest body accepted at all; zero means no limit.
+	Max int64
+}
+
+// hopHeaders are connection-specific headers that must not be forwarded
+// (RFC 7230, section 6.1), plus the client's credentials for the rotator.
+var hopHeaders = []string{
+	"Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Proxy-Connection",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// IsProxyRequest reports whether c is addressed to a forward proxy: either a
+// CONNECT request or one with an absolute-form request target.
+func IsProxyRequest(c *fiber.Ctx) bool {
+	return c.Request().Header.IsConnect() ||
+		isAbsoluteURI(string(c.Request().Header.RequestURI()))
+}
+
+// ConnectTarget returns the "host:port" authority of a CONNECT request.
+func ConnectTarget(c *fiber.Ctx) (string, error) {
+	target := string(c.Request().Header.RequestURI())
+	if target == "" {
+		target = string(c.Request().Header.Host())
+	}
+	if _, port, err := net.SplitHostPort(target); err != nil || port == "" {
+		return "", fmt.Errorf("invalid CONNECT target %q", target)
+	}
+	return target, nil
+}
+
+// NewRequest converts the proxy request held by c into an outbound
+// net/http request addressed at the origin server. Requests whose body is
+// buffered have GetBody set and can be replayed; streamed bodies are read
+// from the client while the request is sent, and must be closed before c is
+// released.
+func NewRequest(c *fiber.Ctx, limits BodyLimits) (*http.Request, error) {
+	rawURI := string(c.Request().Header.RequestURI())
+	if !isAbsoluteURI(rawURI) {
+		return nil, ErrNotProxyRequest
+	}
+	contentLength := int64(c.Request().Header.ContentLength())
+	if limits.Max > 0 && contentLength > limits.Max {
+		return nil, ErrBodyTooLarge
+	}
+
+	body, err := requestBody(c, limits)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(c.UserContext(), c.Method(), rawURI, body)
+	if err != nil {
+		return nil, err
+	}
+	if _, streamed := body.(*streamedBody); streamed {
+		req.ContentLength = -1
+		if contentLength >= 0 {
+			req.ContentLength = contentLength
+		}
+	}
+
+	c.Request().Header.VisitAll(func(key, value []byte) {
+		req.Header.Add(string(key), string(value))
+	})
+	req.Header.Del("Host")
+	req.Header.Del("Content-Length")
+	removeHopHeaders(req.Header)
+	if host := string(c.Request().Header.Host()); host != "" {
+		req.Host = host
+	}
+
+	return req, nil
+}
+
+// requestBody returns the body of the request held by c: a copy when it is
+// at most limits.Replay bytes, as the transport may still be reading it
+// after the handler returns and fasthttp recycles the request buffer, and
+// otherwise a stream from the client.
+func requestBody(c *fiber.Ctx, limits BodyLimits) (io.Reader, error) {
+	stream := c.Request().BodyStream()
+	if stream == nil {
+		// Read in full by fasthttp, within its own body limit
+		body := append([]byte(nil), c.Request().Body()...)
+		if limits.Max > 0 && int64(len(body)) > limits.Max {
+			return nil, ErrBodyTooLarge
+		}
+		return bytes.NewReader(body), nil
+	}
+
+	head, err := io.ReadAll(io.LimitReader(stream, limits.Replay+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(head)) <= limits.Replay {
+		return bytes.NewReader(head), nil
+	}
+	var rest io.Reader = io.MultiReader(bytes.NewReader(head), stream)
+	if limits.Max > 0 {
+		rest = &maxBodyReader{r: rest, left: limits.Max}
+	}
+	return &streamedBody{r: rest}, nil
+}
+
+// maxBodyReader fails with ErrBodyTooLarge once more than left bytes are
+// read.
+type maxBodyReader struct {
+	r    io.Reader
+	left int64
+}
+
+func (m *maxBodyReader) Read(p []byte) (int, error) {
+	if m.left < 0 {
+		return 0, ErrBodyTooLarge
+	}
+	if int64(len(p)) > m.left+1 {
+		p = p[:m.left+1]
+	}
+	n, err := m.r.Read(p)
+	m.left -= int64(n)
+	if m.left < 0 {
+		return n, ErrBodyTooLarge
+	}
+	return n, err
+}
+
+// streamedBody reads a request body from the client until it is closed.
+// The transport may read from it, or close it, from its own goroutine
+// after the round trip returned; closing it stops any use of the fasthttp
+// stream, which is recycled with the request.
+type streamedBody struct {
+	mu     sync.Mutex
+	r      io.Reader
+	closed bool
+}
+
+func (b *streamedBody) Read(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return 0, io.ErrClosedPipe
+	}
+	return b.r.Read(p)
+}
+
+func (b *streamedBody) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed = true
+	return nil
+}
+
+// WriteResponse copies resp onto the client response and streams its body.
+// The body is closed once it has been written to the client.
+func WriteResponse(c *fiber.Ctx, resp *http.Response) {
+	c.Status(resp.StatusCode)
+
+	header := &c.Response().Header
+	header.SetNoDefaultContentType(true)
+	removeHopHeaders(resp.Header)
+	for key, values := range resp.Header {
+		if key == "Content-Length" {
+			continue
+		}
+		for _, value := range values {
+			header.Add(key, value)
+		}
+	}
+
+	c.Context().SetBodyStream(resp.Body, int(resp.ContentLength))
+}
+
+func isAbsoluteURI(uri string) bool {
+	return strings.HasPrefix(uri, "http://") || strings.HasPrefix(uri, "https://")
+}
+
+// removeHopHeaders deletes hop-by-hop headers, including any listed in the
+// Connection header itself.
+func removeHopHeaders(h http.Header) {
+	for _, value := range h.Values("Connection") {
+		for _, name := range strings.Split(value, ",") {
+			if name = textproto.TrimString(name); name != "" {
+				h.Del(name)
+			}
+		}
+	}
+	for _, name := range hopHeaders {
+		h.Del(name)
+	}
+}
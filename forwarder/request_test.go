@@ -0,0 +1,64 @@
+package forwarder
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestNewRequestBodyLimits(t *testing.T) {
+	limits := BodyLimits{Replay: 16, Max: 64}
+	tests := []struct {
+		name       string
+		size       int
+		chunked    bool
+		status     int
+		replayable bool
+	}{
+		{name: "buffered", size: 10, status: http.StatusCreated, replayable: true},
+		{name: "buffered chunked", size: 10, chunked: true, status: http.StatusCreated, replayable: true},
+		{name: "streamed", size: 40, status: http.StatusCreated},
+		{name: "streamed chunked", size: 40, chunked: true, status: http.StatusCreated},
+		{name: "declared over the limit", size: 100, status: http.StatusRequestEntityTooLarge},
+		{name: "chunked over the limit", size: 100, chunked: true, status: http.StatusRequestEntityTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upstream, got := startUpstream(t, "", "")
+			client := proxyClient(startFrontend(t, upstream, limits))
+
+			payload := strings.Repeat("x", tt.size)
+			var body io.Reader = strings.NewReader(payload)
+			if tt.chunked {
+				// Hide the length so the client sends the body chunked
+				body = struct{ io.Reader }{body}
+			}
+			req, _ := http.NewRequest("POST", "http://origin.test/upload", body)
+			resp, err := client.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+
+			if resp.StatusCode != tt.status {
+				t.Fatalf("status = %d, want %d", resp.StatusCode, tt.status)
+			}
+			if tt.status != http.StatusCreated {
+				return
+			}
+			if replayable := resp.Header.Get("X-Replayable") == "true"; replayable != tt.replayable {
+				t.Errorf("replayable = %v, want %v", replayable, tt.replayable)
+			}
+
+			r := <-got
+			if r.body != payload {
+				t.Errorf("upstream got %d bytes, want %d", len(r.body), len(payload))
+			}
+			if !tt.chunked && r.contentLength != int64(tt.size) {
+				t.Errorf("upstream Content-Length = %d, want %d", r.contentLength, tt.size)
+			}
+		})
+	}
+}
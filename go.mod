@@ -4,6 +4,7 @@ go 1.22
 
 require (
 	github.com/gofiber/fiber/v2 v2.52.8
+	github.com/lib/pq v1.10.9
 	github.com/mattn/go-sqlite3 v1.14.22
 )
 
@@ -0,0 +1,98 @@
+package handlers
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"go-proxy-rotator/models"
+	"go-proxy-rotator/services"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// APIKeyHandler manages the keys of the management API.
+type APIKeyHandler struct {
+	keys *services.APIKeys
+}
+
+func NewAPIKeyHandler(keys *services.APIKeys) *APIKeyHandler {
+	return &APIKeyHandler{keys: keys}
+}
+
+// GetKeys returns every API key, without the keys themselves
+func (h *APIKeyHandler) GetKeys(c *fiber.Ctx) error {
+	keys, err := h.keys.List()
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": fmt.Sprintf("Failed to get API keys: %v", err),
+		})
+	}
+	if keys == nil {
+		keys = []*models.APIKey{}
+	}
+
+	return c.JSON(fiber.Map{
+		"keys":  keys,
+		"count": len(keys),
+	})
+}
+
+// CreateKey generates an API key. The response is the only place the key
+// appears.
+func (h *APIKeyHandler) CreateKey(c *fiber.Ctx) error {
+	var body struct {
+		Name string `json:"name"`
+		Role string `json:"role"`
+	}
+	if err := c.BodyParser(&body); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+	name := strings.TrimSpace(body.Name)
+	if name == "" || len(name) > 100 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Name is required and at most 100 characters",
+		})
+	}
+
+	if !models.ValidRole(body.Role) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": fmt.Sprintf("Role is required, one of %s", strings.Join(models.Roles, ", ")),
+		})
+	}
+
+	token, key, err := h.keys.Create(name, body.Role)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": fmt.Sprintf("Failed to create API key: %v", err),
+		})
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"message": "API key created; store it now, it is not shown again",
+		"key":     token,
+		"api_key": key,
+	})
+}
+
+// RevokeKey stops an API key from authenticating
+func (h *APIKeyHandler) RevokeKey(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid API key ID",
+		})
+	}
+
+	if err := h.keys.Revoke(id); err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "API key revoked successfully",
+	})
+}
@@ -0,0 +1,138 @@
+package handlers
+
+import (
+	"fmt"
+	"strings"
+
+	"go-proxy-rotator/models"
+	"go-proxy-rotator/services"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// apiKeyLocal is where RequireAPIKey leaves the caller's key.
+const apiKeyLocal = "apiKey"
+
+// RequireAPIKey rejects requests without a valid API key, given as
+// "Authorization: Bearer <key>" or in the X-API-Key header.
+func RequireAPIKey(keys *services.APIKeys) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		token := requestAPIKey(c)
+		if token == "" {
+			c.Set(fiber.HeaderWWWAuthenticate, "Bearer")
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+				"error": "API key required",
+			})
+		}
+
+		key, err := keys.Authenticate(token)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": fmt.Sprintf("Failed to check API key: %v", err),
+			})
+		}
+		if key == nil {
+			c.Set(fiber.HeaderWWWAuthenticate, `Bearer error="invalid_token"`)
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+				"error": "Invalid or revoked API key",
+			})
+		}
+
+		c.Locals(apiKeyLocal, key)
+		return c.Next()
+	}
+}
+
+// RequirePermission rejects callers whose role lacks permission. Without
+// authentication every request is allowed.
+func RequirePermission(permission string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if err := checkPermission(c, permission); err != nil {
+			return c.Status(err.Code).JSON(fiber.Map{
+				"error": err.Message,
+			})
+		}
+		return c.Next()
+	}
+}
+
+// checkPermission returns a 403 error if the caller's role lacks
+// permission.
+func checkPermission(c *fiber.Ctx, permission string) *fiber.Error {
+	key := callerKey(c)
+	if key == nil || models.RoleAllows(key.Role, permission) {
+		return nil
+	}
+	return fiber.NewError(fiber.StatusForbidden,
+		fmt.Sprintf("Missing permission %s: role %s does not have it", permission, key.Role))
+}
+
+// RouteGroup registers routes on a router behind a permission check. Unlike
+// fiber's groups it adds no middleware to the router itself, so routes
+// sharing a path prefix can need different permissions.
+type RouteGroup struct {
+	router fiber.Router
+	check  fiber.Handler
+}
+
+// Restrict returns a group of the routes of router that need permission.
+func Restrict(router fiber.Router, permission string) *RouteGroup {
+	return &RouteGroup{router: router, check: RequirePermission(permission)}
+}
+
+func (g *RouteGroup) Get(path string, handler fiber.Handler) {
+	g.router.Get(path, g.check, handler)
+}
+
+func (g *RouteGroup) Post(path string, handler fiber.Handler) {
+	g.router.Post(path, g.check, handler)
+}
+
+func (g *RouteGroup) Patch(path string, handler fiber.Handler) {
+	g.router.Patch(path, g.check, handler)
+}
+
+func (g *RouteGroup) Delete(path string, handler fiber.Handler) {
+	g.router.Delete(path, g.check, handler)
+}
+
+// GetCaller returns the caller's API key with its role and permissions, so
+// clients such as the dashboard can hide what they cannot do.
+func GetCaller(c *fiber.Ctx) error {
+	key := callerKey(c)
+	if key == nil {
+		return c.JSON(fiber.Map{
+			"authenticated": false,
+			"role":          models.RoleAdmin,
+			"permissions":   models.RolePermissions(models.RoleAdmin),
+		})
+	}
+	return c.JSON(fiber.Map{
+		"authenticated": true,
+		"name":          key.Name,
+		"role":          key.Role,
+		"permissions":   models.RolePermissions(key.Role),
+	})
+}
+
+// callerKey returns the API key a request was authenticated with, nil when
+// authentication is disabled.
+func callerKey(c *fiber.Ctx) *models.APIKey {
+	key, _ := c.Locals(apiKeyLocal).(*models.APIKey)
+	return key
+}
+
+// callerName describes the caller of a request for the log.
+func callerName(c *fiber.Ctx) string {
+	if key := callerKey(c); key != nil {
+		return fmt.Sprintf("%s (key %s)", c.IP(), key.Name)
+	}
+	return c.IP()
+}
+
+func requestAPIKey(c *fiber.Ctx) string {
+	if scheme, token, ok := strings.Cut(c.Get(fiber.HeaderAuthorization), " "); ok && strings.EqualFold(scheme, "Bearer") {
+		return strings.TrimSpace(token)
+	}
+	return c.Get("X-API-Key")
+}
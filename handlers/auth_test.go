@@ -0,0 +1,250 @@
+package handlers
+
+import (
+	"encoding/json"
+	"io"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"go-proxy-rotator/database"
+	"go-proxy-rotator/models"
+	"go-proxy-rotator/services"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// testRoutes mirrors the management API routes main registers, with the
+// permission each needs.
+var testRoutes = []struct {
+	method     string
+	path       string
+	permission string
+}{
+	{"POST", "/proxies/upload", models.PermissionProxiesWrite},
+	{"GET", "/proxies", models.PermissionProxiesRead},
+	{"GET", "/proxies/active", models.PermissionProxiesRead},
+	{"POST", "/proxies", models.PermissionProxiesWrite},
+	{"PATCH", "/proxies/1", models.PermissionProxiesWrite},
+	{"DELETE", "/proxies/1", models.PermissionProxiesDelete},
+	{"DELETE", "/proxies", models.PermissionProxiesDelete},
+	{"GET", "/proxies/stats", models.PermissionProxiesRead},
+	{"GET", "/proxies/1/breaker", models.PermissionProxiesRead},
+	{"POST", "/proxies/1/breaker/reset", models.PermissionProxiesReset},
+	{"GET", "/breakers", models.PermissionProxiesRead},
+	{"GET", "/proxies/1/domains", models.PermissionProxiesRead},
+	{"DELETE", "/proxies/1/domains/example.com", models.PermissionProxiesReset},
+	{"GET", "/proxies/1/exit-ips", models.PermissionProxiesRead},
+	{"GET", "/proxies/1/checks", models.PermissionProxiesRead},
+	{"GET", "/proxies/1/latency", models.PermissionProxiesRead},
+	{"GET", "/exit-ips/shared", models.PermissionProxiesRead},
+	{"POST", "/proxies/health-check", models.PermissionHealthRun},
+	{"GET", "/health-jobs", models.PermissionProxiesRead},
+	{"GET", "/health-jobs/1", models.PermissionProxiesRead},
+	{"POST", "/health-jobs/1/cancel", models.PermissionHealthRun},
+	{"GET", "/ban-rules", models.PermissionProxiesRead},
+	{"POST", "/ban-rules", models.PermissionBanRulesWrite},
+	{"GET", "/ban-rules/1", models.PermissionProxiesRead},
+	{"PATCH", "/ban-rules/1", models.PermissionBanRulesWrite},
+	{"DELETE", "/ban-rules/1", models.PermissionBanRulesWrite},
+	{"GET", "/health-checker", models.PermissionProxiesRead},
+	{"POST", "/health-checker/pause", models.PermissionHealthRun},
+	{"POST", "/health-checker/resume", models.PermissionHealthRun},
+	{"POST", "/health-checker/trigger", models.PermissionHealthRun},
+	{"GET", "/sessions", models.PermissionProxiesRead},
+	{"GET", "/sessions/abc", models.PermissionProxiesRead},
+	{"DELETE", "/sessions/abc", models.PermissionSessionsRevoke},
+	{"DELETE", "/sessions", models.PermissionSessionsRevoke},
+	{"GET", "/migrations", models.PermissionProxiesRead},
+	{"GET", "/keys", models.PermissionKeysManage},
+	{"POST", "/keys", models.PermissionKeysManage},
+	{"DELETE", "/keys/1", models.PermissionKeysManage},
+}
+
+// lowestRole is the least privileged role with each permission.
+var lowestRole = map[string]string{
+	models.PermissionProxiesRead:       models.RoleViewer,
+	models.PermissionProxiesReset:      models.RoleOperator,
+	models.PermissionHealthRun:         models.RoleOperator,
+	models.PermissionSessionsRevoke:    models.RoleOperator,
+	models.PermissionProxiesWrite:      models.RoleAdmin,
+	models.PermissionProxiesDelete:     models.RoleAdmin,
+	models.PermissionBanRulesWrite:     models.RoleAdmin,
+	models.PermissionKeysManage:        models.RoleAdmin,
+	models.PermissionCredentialsReveal: models.RoleAdmin,
+}
+
+// newTestAPI returns the management API with stub handlers behind the
+// authentication and permission checks main uses, together with a token
+// per role, a revoked token and the bootstrap token.
+func newTestAPI(t *testing.T) (*fiber.App, map[string]string) {
+	t.Helper()
+
+	db, err := database.New(filepath.Join(t.TempDir(), "auth.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	keys := services.NewAPIKeys(db, "bootstrap-secret")
+	tokens := map[string]string{"bootstrap": "bootstrap-secret"}
+	for _, role := range models.Roles {
+		token, _, err := keys.Create(role, role)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tokens[role] = token
+	}
+	token, revoked, err := keys.Create("revoked", models.RoleAdmin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := keys.Revoke(revoked.ID); err != nil {
+		t.Fatal(err)
+	}
+	tokens["revoked"] = token
+
+	app := fiber.New()
+	api := app.Group("/api/v1")
+	api.Use(RequireAPIKey(keys))
+	api.Get("/me", GetCaller)
+	ok := func(c *fiber.Ctx) error { return c.SendStatus(fiber.StatusOK) }
+	for _, route := range testRoutes {
+		group := Restrict(api, route.permission)
+		switch route.method {
+		case "GET":
+			group.Get(route.path, ok)
+		case "POST":
+			group.Post(route.path, ok)
+		case "PATCH":
+			group.Patch(route.path, ok)
+		case "DELETE":
+			group.Delete(route.path, ok)
+		}
+	}
+	return app, tokens
+}
+
+func TestRoutePermissions(t *testing.T) {
+	app, tokens := newTestAPI(t)
+
+	rank := make(map[string]int)
+	for i, role := range models.Roles {
+		rank[role] = i
+	}
+
+	tests := []struct {
+		caller string
+		token  string
+		// role is empty for callers that must not authenticate
+		role string
+	}{
+		{caller: "viewer", token: tokens[models.RoleViewer], role: models.RoleViewer},
+		{caller: "operator", token: tokens[models.RoleOperator], role: models.RoleOperator},
+		{caller: "admin", token: tokens[models.RoleAdmin], role: models.RoleAdmin},
+		{caller: "bootstrap", token: tokens["bootstrap"], role: models.RoleAdmin},
+		{caller: "revoked", token: tokens["revoked"]},
+		{caller: "unknown", token: "gpr_not-a-key"},
+		{caller: "anonymous"},
+	}
+	for _, tt := range tests {
+		for _, route := range testRoutes {
+			t.Run(tt.caller+" "+route.method+" "+route.path, func(t *testing.T) {
+				req := httptest.NewRequest(route.method, "/api/v1"+route.path, nil)
+				if tt.token != "" {
+					req.Header.Set("Authorization", "Bearer "+tt.token)
+				}
+				resp, err := app.Test(req)
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer resp.Body.Close()
+
+				want := fiber.StatusOK
+				switch {
+				case tt.role == "":
+					want = fiber.StatusUnauthorized
+				case rank[tt.role] < rank[lowestRole[route.permission]]:
+					want = fiber.StatusForbidden
+				}
+				if resp.StatusCode != want {
+					body, _ := io.ReadAll(resp.Body)
+					t.Fatalf("status = %d, want %d: %s", resp.StatusCode, want, body)
+				}
+
+				if want == fiber.StatusForbidden {
+					var body struct {
+						Error string `json:"error"`
+					}
+					if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+						t.Fatal(err)
+					}
+					wantErr := "Missing permission " + route.permission + ": role " + tt.role + " does not have it"
+					if body.Error != wantErr {
+						t.Errorf("error = %q, want %q", body.Error, wantErr)
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestGetCaller(t *testing.T) {
+	app, tokens := newTestAPI(t)
+
+	tests := []struct {
+		name   string
+		header string
+		token  string
+		status int
+		caller string
+		role   string
+	}{
+		{name: "viewer", header: "Authorization", token: "Bearer " + tokens[models.RoleViewer],
+			status: fiber.StatusOK, caller: models.RoleViewer, role: models.RoleViewer},
+		{name: "operator with X-API-Key", header: "X-API-Key", token: tokens[models.RoleOperator],
+			status: fiber.StatusOK, caller: models.RoleOperator, role: models.RoleOperator},
+		{name: "bootstrap", header: "Authorization", token: "bearer " + tokens["bootstrap"],
+			status: fiber.StatusOK, caller: services.BootstrapKeyName, role: models.RoleAdmin},
+		{name: "revoked", header: "Authorization", token: "Bearer " + tokens["revoked"], status: fiber.StatusUnauthorized},
+		{name: "missing", status: fiber.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/api/v1/me", nil)
+			if tt.header != "" {
+				req.Header.Set(tt.header, tt.token)
+			}
+			resp, err := app.Test(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != tt.status {
+				t.Fatalf("status = %d, want %d", resp.StatusCode, tt.status)
+			}
+			if tt.status != fiber.StatusOK {
+				if resp.Header.Get(fiber.HeaderWWWAuthenticate) == "" {
+					t.Errorf("401 without a WWW-Authenticate header")
+				}
+				return
+			}
+
+			var body struct {
+				Authenticated bool     `json:"authenticated"`
+				Name          string   `json:"name"`
+				Role          string   `json:"role"`
+				Permissions   []string `json:"permissions"`
+			}
+			if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+				t.Fatal(err)
+			}
+			if !body.Authenticated || body.Name != tt.caller || body.Role != tt.role {
+				t.Errorf("caller = %+v, want %s with role %s", body, tt.caller, tt.role)
+			}
+			if len(body.Permissions) != len(models.RolePermissions(tt.role)) {
+				t.Errorf("permissions = %v, want those of %s", body.Permissions, tt.role)
+			}
+		})
+	}
+}
@@ -0,0 +1,181 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+
+	"go-proxy-rotator/models"
+	"go-proxy-rotator/services"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// BanRuleHandler manages the rules that recognise banned responses.
+type BanRuleHandler struct {
+	banRules *services.BanRules
+}
+
+func NewBanRuleHandler(banRules *services.BanRules) *BanRuleHandler {
+	return &BanRuleHandler{banRules: banRules}
+}
+
+// GetRules returns every ban rule
+func (h *BanRuleHandler) GetRules(c *fiber.Ctx) error {
+	rules := h.banRules.List()
+
+	return c.JSON(fiber.Map{
+		"rules": rules,
+		"count": len(rules),
+	})
+}
+
+// GetRule returns a single ban rule
+func (h *BanRuleHandler) GetRule(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid ban rule ID",
+		})
+	}
+
+	rule, ok := h.banRules.Get(id)
+	if !ok {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": fmt.Sprintf("ban rule with id %d not found", id),
+		})
+	}
+
+	return c.JSON(rule)
+}
+
+// CreateRule adds a ban rule. Rules are enabled unless the body says
+// otherwise.
+func (h *BanRuleHandler) CreateRule(c *fiber.Ctx) error {
+	var body banRuleBody
+	if err := c.BodyParser(&body); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+
+	rule := models.BanRule{Enabled: true}
+	body.apply(&rule)
+	rule, err := h.banRules.Add(rule)
+	if err != nil {
+		return banRuleError(c, "Failed to add ban rule", err)
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"message": "Ban rule added successfully",
+		"rule":    rule,
+	})
+}
+
+// UpdateRule changes a ban rule. Fields left out of the request body keep
+// their current value.
+func (h *BanRuleHandler) UpdateRule(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid ban rule ID",
+		})
+	}
+
+	var body banRuleBody
+	if err := c.BodyParser(&body); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+
+	rule, ok := h.banRules.Get(id)
+	if !ok {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": fmt.Sprintf("ban rule with id %d not found", id),
+		})
+	}
+	body.apply(&rule)
+	rule, err = h.banRules.Update(id, rule)
+	if err != nil {
+		return banRuleError(c, "Failed to update ban rule", err)
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "Ban rule updated successfully",
+		"rule":    rule,
+	})
+}
+
+// DeleteRule deletes a ban rule by ID
+func (h *BanRuleHandler) DeleteRule(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid ban rule ID",
+		})
+	}
+
+	if err := h.banRules.Remove(id); err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "Ban rule deleted successfully",
+	})
+}
+
+// banRuleBody is the request body of CreateRule and UpdateRule; nil fields
+// are left alone.
+type banRuleBody struct {
+	Name          *string `json:"name"`
+	Domain        *string `json:"domain"`
+	StatusCodes   *[]int  `json:"status_codes"`
+	Header        *string `json:"header"`
+	HeaderPattern *string `json:"header_pattern"`
+	BodyPattern   *string `json:"body_pattern"`
+	Scope         *string `json:"scope"`
+	Enabled       *bool   `json:"enabled"`
+}
+
+func (b banRuleBody) apply(rule *models.BanRule) {
+	if b.Name != nil {
+		rule.Name = *b.Name
+	}
+	if b.Domain != nil {
+		rule.Domain = *b.Domain
+	}
+	if b.StatusCodes != nil {
+		rule.StatusCodes = *b.StatusCodes
+	}
+	if b.Header != nil {
+		rule.Header = *b.Header
+	}
+	if b.HeaderPattern != nil {
+		rule.HeaderPattern = *b.HeaderPattern
+	}
+	if b.BodyPattern != nil {
+		rule.BodyPattern = *b.BodyPattern
+	}
+	if b.Scope != nil {
+		rule.Scope = *b.Scope
+	}
+	if b.Enabled != nil {
+		rule.Enabled = *b.Enabled
+	}
+}
+
+// banRuleError reports a failure to store a ban rule, as a client error
+// when the rule itself is invalid.
+func banRuleError(c *fiber.Ctx, message string, err error) error {
+	if errors.Is(err, services.ErrInvalidBanRule) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+		"error": fmt.Sprintf("%s: %v", message, err),
+	})
+}
@@ -0,0 +1,148 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"go-proxy-rotator/models"
+	"go-proxy-rotator/services"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+type CheckHandler struct {
+	proxyService *services.ProxyService
+	history      *services.CheckHistory
+}
+
+func NewCheckHandler(proxyService *services.ProxyService, history *services.CheckHistory) *CheckHandler {
+	return &CheckHandler{proxyService: proxyService, history: history}
+}
+
+// GetProxyChecks returns the health checks of a proxy, newest first
+func (h *CheckHandler) GetProxyChecks(c *fiber.Ctx) error {
+	id, from, to, bad := h.rangeParams(c)
+	if bad != nil {
+		return c.Status(bad.Code).JSON(fiber.Map{
+			"error": bad.Message,
+		})
+	}
+	limit := c.QueryInt("limit", 100)
+	if limit < 1 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid limit",
+		})
+	}
+
+	checks, err := h.history.Checks(id, from, to, limit)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": fmt.Sprintf("Failed to get checks: %v", err),
+		})
+	}
+	if checks == nil {
+		checks = []models.ProxyCheck{}
+	}
+
+	return c.JSON(fiber.Map{
+		"proxy_id": id,
+		"from":     from,
+		"to":       to,
+		"checks":   checks,
+		"count":    len(checks),
+	})
+}
+
+// GetProxyLatency returns the latency percentiles of a proxy over a time
+// range, with a time series split into buckets of the "bucket" query
+// parameter
+func (h *CheckHandler) GetProxyLatency(c *fiber.Ctx) error {
+	id, from, to, bad := h.rangeParams(c)
+	if bad != nil {
+		return c.Status(bad.Code).JSON(fiber.Map{
+			"error": bad.Message,
+		})
+	}
+	bucket := time.Hour
+	if value := c.Query("bucket"); value != "" {
+		d, err := time.ParseDuration(value)
+		if err != nil || d < time.Minute {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": fmt.Sprintf("Invalid bucket: %s (at least 1m)", value),
+			})
+		}
+		bucket = d
+	}
+
+	stats, err := h.history.Latency(id, from, to, bucket)
+	if errors.Is(err, services.ErrTooManyBuckets) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Too many buckets: use a larger bucket or a shorter range",
+		})
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": fmt.Sprintf("Failed to compute latency: %v", err),
+		})
+	}
+
+	return c.JSON(stats)
+}
+
+// rangeParams reads the proxy ID and the from/to query parameters, which
+// default to the last 24 hours; from is clamped to the oldest kept history.
+func (h *CheckHandler) rangeParams(c *fiber.Ctx) (id int, from, to time.Time, err *fiber.Error) {
+	id, convErr := strconv.Atoi(c.Params("id"))
+	if convErr != nil {
+		return 0, from, to, fiber.NewError(fiber.StatusBadRequest, "Invalid proxy ID")
+	}
+	if _, ok := h.proxyService.Pool.Get(id); !ok {
+		return 0, from, to, fiber.NewError(fiber.StatusNotFound, fmt.Sprintf("proxy with id %d not found", id))
+	}
+
+	now := time.Now()
+	to = now
+	if value := c.Query("to"); value != "" {
+		t, parseErr := parseTime(value, now)
+		if parseErr != nil {
+			return 0, from, to, fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("Invalid to: %s", value))
+		}
+		to = t
+	}
+	from = to.Add(-24 * time.Hour)
+	if value := c.Query("from"); value != "" {
+		t, parseErr := parseTime(value, now)
+		if parseErr != nil {
+			return 0, from, to, fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("Invalid from: %s", value))
+		}
+		from = t
+	}
+	if !from.Before(to) {
+		return 0, from, to, fiber.NewError(fiber.StatusBadRequest, "from must be before to")
+	}
+	// Nothing older than the kept history exists, so ranges start no
+	// earlier; responses echo the from actually used
+	if oldest := h.history.Oldest(now); from.Before(oldest) {
+		if !oldest.Before(to) {
+			return 0, from, to, fiber.NewError(fiber.StatusBadRequest,
+				fmt.Sprintf("to is before the oldest kept history, %s", oldest.UTC().Format(time.RFC3339)))
+		}
+		from = oldest
+	}
+	return id, from, to, nil
+}
+
+// parseTime accepts an RFC 3339 timestamp or a duration meaning that long
+// before now ("24h").
+func parseTime(value string, now time.Time) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d < 0 {
+		return time.Time{}, fmt.Errorf("invalid time: %s", value)
+	}
+	return now.Add(-d), nil
+}
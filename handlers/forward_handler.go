@@ -0,0 +1,391 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"go-proxy-rotator/forwarder"
+	"go-proxy-rotator/models"
+	"go-proxy-rotator/services"
+	"go-proxy-rotator/tunnel"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	// attemptsHeader tells the client how many upstream proxies were tried.
+	attemptsHeader = "X-Proxy-Attempts"
+	// usedHeader carries the ID of the proxy that served the request.
+	usedHeader = "X-Proxy-Used"
+	// banRuleHeader names the ban rule the final response matched, when
+	// every attempt was banned.
+	banRuleHeader = "X-Proxy-Ban-Rule"
+)
+
+var errNoProxies = errors.New("no proxies available")
+
+// ForwardOptions configures the data plane.
+type ForwardOptions struct {
+	// IdleTimeout closes CONNECT tunnels that have carried no data in
+	// either direction for that long; zero disables it.
+	IdleTimeout time.Duration
+	// MaxRetries is how many other proxies are tried after the first one
+	// fails.
+	MaxRetries int
+	// RetryStatusCodes are upstream response codes that count as a proxy
+	// failure and are retried through another proxy.
+	RetryStatusCodes []int
+	// RetryBodyLimit is the largest request body, in bytes, that is
+	// buffered and replayed on retry; larger bodies are streamed from the
+	// client through a single attempt.
+	RetryBodyLimit int64
+	// BodyLimit is the largest request body accepted, in bytes; zero means
+	// no limit.
+	BodyLimit int64
+}
+
+// ForwardHandler implements the data plane: it accepts proxy requests from
+// clients and sends them through an upstream proxy picked by ProxyService.
+type ForwardHandler struct {
+	proxyService *services.ProxyService
+	forwarder    *forwarder.Forwarder
+	banRules     *services.BanRules
+	opts         ForwardOptions
+	retryStatus  map[int]bool
+}
+
+// NewForwardHandler creates the data plane handler. Responses are checked
+// against banRules before they are handed to the client.
+func NewForwardHandler(proxyService *services.ProxyService, fwd *forwarder.Forwarder, banRules *services.BanRules, opts ForwardOptions) *ForwardHandler {
+	retryStatus := make(map[int]bool, len(opts.RetryStatusCodes))
+	for _, code := range opts.RetryStatusCodes {
+		retryStatus[code] = true
+	}
+	return &ForwardHandler{
+		proxyService: proxyService,
+		forwarder:    fwd,
+		banRules:     banRules,
+		opts:         opts,
+		retryStatus:  retryStatus,
+	}
+}
+
+// Handle forwards absolute-form requests through a proxy chosen by the
+// selection strategy and streams the origin response back to the client.
+// Requests that fail at the upstream are retried through other proxies.
+func (h *ForwardHandler) Handle(c *fiber.Ctx) error {
+	// The proxy listener serves nothing locally; origin-form requests are
+	// clients that were not configured to use it as a proxy.
+	if !forwarder.IsProxyRequest(c) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "This port only accepts proxy requests; configure it as your HTTP proxy",
+		})
+	}
+	opts, err := selectOptions(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": fmt.Sprintf("Invalid proxy request: %v", err),
+		})
+	}
+	if c.Method() == fiber.MethodConnect {
+		return h.handleConnect(c, opts)
+	}
+
+	req, err := forwarder.NewRequest(c, forwarder.BodyLimits{
+		Replay: h.opts.RetryBodyLimit,
+		Max:    h.opts.BodyLimit,
+	})
+	if errors.Is(err, forwarder.ErrBodyTooLarge) {
+		return c.Status(fiber.StatusRequestEntityTooLarge).JSON(fiber.Map{
+			"error": "Request body too large",
+		})
+	}
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": fmt.Sprintf("Invalid proxy request: %v", err),
+		})
+	}
+	for _, name := range controlHeaders {
+		req.Header.Del(name)
+	}
+	opts.Domain = strings.ToLower(req.URL.Hostname())
+
+	// Only buffered bodies can be sent again
+	maxAttempts := 1
+	if req.GetBody != nil {
+		maxAttempts += h.opts.MaxRetries
+	}
+
+	var lastErr error
+	for attempt := 1; attempt <= maxAttempts; attempt++ {
+		selectedProxy, err := h.proxyService.SelectProxy(opts)
+		if err != nil {
+			if lastErr != nil {
+				// Every remaining proxy has already been tried.
+				break
+			}
+			return selectionError(c, err)
+		}
+		opts.Exclude[selectedProxy.ID] = true
+		c.Set(attemptsHeader, strconv.Itoa(attempt))
+		c.Set(usedHeader, strconv.Itoa(selectedProxy.ID))
+
+		attemptReq, err := replay(req)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": fmt.Sprintf("Failed to prepare request: %v", err),
+			})
+		}
+
+		resp, banned, err := h.roundTrip(selectedProxy, attemptReq, attempt == maxAttempts)
+		if errors.Is(err, forwarder.ErrBodyTooLarge) {
+			return c.Status(fiber.StatusRequestEntityTooLarge).JSON(fiber.Map{
+				"error": "Request body too large",
+			})
+		}
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if banned != nil {
+			c.Set(banRuleHeader, banned.Name)
+		}
+		// A streamed body may be read while the response is, and must not
+		// be once the request is recycled
+		resp.Body = &releaseOnClose{ReadCloser: resp.Body, release: func() { req.Body.Close() }}
+		forwarder.WriteResponse(c, resp)
+		return nil
+	}
+
+	return c.Status(fiber.StatusBadGateway).JSON(fiber.Map{
+		"error": fmt.Sprintf("Upstream proxy failed: %v", lastErr),
+	})
+}
+
+// roundTrip sends one attempt through proxy and records the outcome
+// against its health, overall and for the target domain. A response with
+// one of the retry status codes, or one matching a ban rule, counts as a
+// failure and is returned as an error, unless this is the last attempt, in
+// which case the client gets it as is along with the ban rule it matched.
+// A domain-scoped ban only takes the proxy out of rotation for the target
+// domain.
+func (h *ForwardHandler) roundTrip(proxy *models.Proxy, req *http.Request, last bool) (*http.Response, *models.BanRule, error) {
+	release := h.proxyService.Acquire(proxy.ID)
+	start := time.Now()
+	resp, err := h.forwarder.RoundTrip(proxy, req)
+	latency := time.Since(start)
+	if errors.Is(err, forwarder.ErrBodyTooLarge) {
+		// The client's fault, not the proxy's
+		release()
+		return nil, nil, err
+	}
+	failed := err != nil
+	var banned *models.BanRule
+	if err == nil && h.retryStatus[resp.StatusCode] {
+		err = fmt.Errorf("upstream %s:%d: unexpected status %s", proxy.Host, proxy.Port, resp.Status)
+		failed = true
+	} else if err == nil {
+		if banned = h.banRules.Match(req.URL.Hostname(), resp); banned != nil {
+			err = fmt.Errorf("upstream %s:%d: response matched ban rule %q", proxy.Host, proxy.Port, banned.Name)
+			failed = banned.Scope != models.BanScopeDomain
+		}
+	}
+	h.observe(proxy, latency, !failed)
+	if banned != nil && banned.Scope == models.BanScopeDomain {
+		h.proxyService.BanForDomain(proxy.ID, req.URL.Hostname())
+	} else {
+		h.proxyService.RecordDomainResult(proxy.ID, req.URL.Hostname(), !failed)
+	}
+	if err != nil && (resp == nil || !last) {
+		if resp != nil {
+			resp.Body.Close()
+		}
+		release()
+		return nil, nil, err
+	}
+
+	// The request stays in flight until the body has been streamed.
+	resp.Body = &releaseOnClose{ReadCloser: resp.Body, release: release}
+	return resp, banned, nil
+}
+
+// replay returns a copy of req with a fresh body, so that the same request
+// can be sent through several proxies.
+func replay(req *http.Request) (*http.Request, error) {
+	clone := req.Clone(req.Context())
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		clone.Body = body
+	}
+	return clone, nil
+}
+
+// handleConnect opens a tunnel to the CONNECT target through an upstream
+// proxy, then hijacks the client connection and splices the two together.
+func (h *ForwardHandler) handleConnect(c *fiber.Ctx, opts services.SelectOptions) error {
+	target, err := forwarder.ConnectTarget(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	upstream, tried, err := h.dialUpstream(c.UserContext(), target, opts)
+	if tried.attempts > 0 {
+		c.Set(attemptsHeader, strconv.Itoa(tried.attempts))
+		c.Set(usedHeader, strconv.Itoa(tried.proxyID))
+	}
+	if err != nil {
+		if errors.Is(err, errNoProxies) {
+			return selectionError(c, err)
+		}
+		return c.Status(fiber.StatusBadGateway).JSON(fiber.Map{
+			"error": fmt.Sprintf("Upstream proxy failed: %v", err),
+		})
+	}
+
+	// fasthttp would otherwise write its own response (with Content-Length
+	// and friends) before handing over the connection.
+	idleTimeout := h.opts.IdleTimeout
+	established := fmt.Sprintf("HTTP/1.1 200 Connection established\r\n%s: %d\r\n%s: %d\r\n\r\n",
+		attemptsHeader, tried.attempts, usedHeader, tried.proxyID)
+	c.Context().HijackSetNoResponse(true)
+	c.Context().Hijack(func(conn net.Conn) {
+		if _, err := conn.Write([]byte(established)); err != nil {
+			upstream.Close()
+			return
+		}
+		tunnel.Splice(conn, upstream, idleTimeout)
+	})
+	return nil
+}
+
+// DialUpstream connects to target ("host:port") through a proxy chosen by
+// the default strategy, regardless of whether that upstream speaks HTTP or
+// SOCKS, failing over to other proxies when the tunnel cannot be set up.
+func (h *ForwardHandler) DialUpstream(ctx context.Context, target string) (net.Conn, error) {
+	opts := services.SelectOptions{Exclude: make(map[int]bool)}
+	conn, _, err := h.dialUpstream(ctx, target, opts)
+	return conn, err
+}
+
+// tries describes the upstream proxies a connection attempt went through.
+type tries struct {
+	attempts int
+	proxyID  int // the last proxy tried
+}
+
+func (h *ForwardHandler) dialUpstream(ctx context.Context, target string, opts services.SelectOptions) (net.Conn, tries, error) {
+	if host, _, err := net.SplitHostPort(target); err == nil {
+		opts.Domain = strings.ToLower(host)
+	}
+	var (
+		tried   tries
+		lastErr error
+	)
+	for tried.attempts <= h.opts.MaxRetries && ctx.Err() == nil {
+		selectedProxy, err := h.proxyService.SelectProxy(opts)
+		if err != nil {
+			if lastErr != nil {
+				break
+			}
+			return nil, tried, fmt.Errorf("%w: %w", errNoProxies, err)
+		}
+		opts.Exclude[selectedProxy.ID] = true
+		tried.attempts++
+		tried.proxyID = selectedProxy.ID
+
+		upstream, err := h.dial(ctx, selectedProxy, target, opts.Domain)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		return upstream, tried, nil
+	}
+	if lastErr == nil {
+		lastErr = ctx.Err()
+	}
+	return nil, tried, lastErr
+}
+
+// dial opens a tunnel to target, a host of domain, through proxy and
+// records the outcome against its health, overall and for the domain.
+func (h *ForwardHandler) dial(ctx context.Context, proxy *models.Proxy, target, domain string) (net.Conn, error) {
+	release := h.proxyService.Acquire(proxy.ID)
+	start := time.Now()
+	upstream, err := h.forwarder.Dial(ctx, proxy, target)
+	h.observe(proxy, time.Since(start), err == nil)
+	h.proxyService.RecordDomainResult(proxy.ID, domain, err == nil)
+	if err != nil {
+		release()
+		return nil, err
+	}
+
+	// The tunnel counts as in flight until it is torn down.
+	return &releaseOnCloseConn{Conn: upstream, release: release}, nil
+}
+
+// observe records the outcome and latency of a request or tunnel through
+// proxy: it feeds the proxy's circuit breaker and latency statistics, and
+// its health.
+func (h *ForwardHandler) observe(proxy *models.Proxy, latency time.Duration, success bool) {
+	h.proxyService.ObserveResult(proxy.ID, latency, success)
+	h.proxyService.RecordHealth(proxy.ID, int(latency.Milliseconds()), success)
+}
+
+// selectionError reports a failure to pick an upstream proxy.
+func selectionError(c *fiber.Ctx, err error) error {
+	if errors.Is(err, services.ErrUnknownStrategy) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	if errors.Is(err, services.ErrNoMatchingProxy) {
+		return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{
+			"error": "No active proxy matches the requested constraints",
+		})
+	}
+	return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{
+		"error": "No proxies available",
+	})
+}
+
+// releaseOnClose calls release when the wrapped body is closed.
+type releaseOnClose struct {
+	io.ReadCloser
+	release func()
+}
+
+func (r *releaseOnClose) Close() error {
+	r.release()
+	return r.ReadCloser.Close()
+}
+
+// releaseOnCloseConn calls release when the wrapped connection is closed.
+type releaseOnCloseConn struct {
+	net.Conn
+	release func()
+}
+
+func (c *releaseOnCloseConn) Close() error {
+	c.release()
+	return c.Conn.Close()
+}
+
+// CloseWrite keeps half-close working for the tunnel splicer.
+func (c *releaseOnCloseConn) CloseWrite() error {
+	if cw, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return c.Close()
+}
@@ -0,0 +1,53 @@
+package handlers
+
+import (
+	"go-proxy-rotator/services"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+type HealthHandler struct {
+	scheduler *services.HealthScheduler
+}
+
+func NewHealthHandler(scheduler *services.HealthScheduler) *HealthHandler {
+	return &HealthHandler{scheduler: scheduler}
+}
+
+// GetStatus returns the background health checker state
+func (h *HealthHandler) GetStatus(c *fiber.Ctx) error {
+	return c.JSON(h.scheduler.Status())
+}
+
+// Pause stops scheduled health checks until resumed
+func (h *HealthHandler) Pause(c *fiber.Ctx) error {
+	h.scheduler.Pause()
+
+	return c.JSON(fiber.Map{
+		"message": "Health checker paused",
+		"status":  h.scheduler.Status(),
+	})
+}
+
+// Resume restarts scheduled health checks
+func (h *HealthHandler) Resume(c *fiber.Ctx) error {
+	h.scheduler.Resume()
+
+	return c.JSON(fiber.Map{
+		"message": "Health checker resumed",
+		"status":  h.scheduler.Status(),
+	})
+}
+
+// Trigger starts a check of all proxies in the background
+func (h *HealthHandler) Trigger(c *fiber.Ctx) error {
+	if !h.scheduler.Trigger() {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": "A health check is already queued",
+		})
+	}
+
+	return c.Status(fiber.StatusAccepted).JSON(fiber.Map{
+		"message": "Health check triggered",
+	})
+}
@@ -0,0 +1,91 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+
+	"go-proxy-rotator/services"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+type HealthJobHandler struct {
+	jobs *services.HealthJobs
+}
+
+func NewHealthJobHandler(jobs *services.HealthJobs) *HealthJobHandler {
+	return &HealthJobHandler{jobs: jobs}
+}
+
+// StartJob starts a health check of all active proxies in the background
+func (h *HealthJobHandler) StartJob(c *fiber.Ctx) error {
+	job, err := h.jobs.Start(c.Query("url"))
+	if errors.Is(err, services.ErrHealthJobRunning) {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": fmt.Sprintf("Health check already running: %v", err),
+		})
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": fmt.Sprintf("Health check failed: %v", err),
+		})
+	}
+
+	return c.Status(fiber.StatusAccepted).JSON(fiber.Map{
+		"message": "Health check started",
+		"job":     job,
+	})
+}
+
+// GetJobs returns the most recent health check jobs
+func (h *HealthJobHandler) GetJobs(c *fiber.Ctx) error {
+	limit := c.QueryInt("limit", 20)
+	if limit < 1 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid limit",
+		})
+	}
+
+	jobs, err := h.jobs.List(limit)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": fmt.Sprintf("Failed to get health jobs: %v", err),
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"jobs":  jobs,
+		"count": len(jobs),
+	})
+}
+
+// GetJob returns the progress and per-proxy results of a health check job
+func (h *HealthJobHandler) GetJob(c *fiber.Ctx) error {
+	job, err := h.jobs.Get(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": fmt.Sprintf("Failed to get health job: %v", err),
+		})
+	}
+	if job == nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": fmt.Sprintf("Health job %s not found", c.Params("id")),
+		})
+	}
+
+	return c.JSON(job)
+}
+
+// CancelJob stops a running health check job
+func (h *HealthJobHandler) CancelJob(c *fiber.Ctx) error {
+	id := c.Params("id")
+	if !h.jobs.Cancel(id) {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": fmt.Sprintf("Health job %s is not running", id),
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "Health job cancelled",
+	})
+}
@@ -0,0 +1,35 @@
+package handlers
+
+import (
+	"go-proxy-rotator/models"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// JudgeHandler serves the anonymity judge. Health checks call it through
+// each proxy to see which headers and address reach a target.
+type JudgeHandler struct{}
+
+func NewJudgeHandler() *JudgeHandler {
+	return &JudgeHandler{}
+}
+
+// Judge echoes the remote address and headers of the request
+func (h *JudgeHandler) Judge(c *fiber.Ctx) error {
+	headers := make(map[string]string)
+	c.Request().Header.VisitAll(func(key, value []byte) {
+		name := string(key)
+		if previous, ok := headers[name]; ok {
+			headers[name] = previous + ", " + string(value)
+		} else {
+			headers[name] = string(value)
+		}
+	})
+
+	return c.JSON(models.JudgeReport{
+		// The connection's address, not one taken from proxy headers
+		RemoteAddr: c.Context().RemoteIP().String(),
+		Method:     c.Method(),
+		Headers:    headers,
+	})
+}
@@ -0,0 +1,29 @@
+package handlers
+
+import (
+	"fmt"
+
+	"go-proxy-rotator/database"
+	"go-proxy-rotator/models"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+type MigrationHandler struct {
+	db database.Store
+}
+
+func NewMigrationHandler(db database.Store) *MigrationHandler {
+	return &MigrationHandler{db: db}
+}
+
+// GetMigrations returns the schema version and every migration
+func (h *MigrationHandler) GetMigrations(c *fiber.Ctx) error {
+	migrations, err := h.db.Migrations()
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": fmt.Sprintf("Failed to get migrations: %v", err),
+		})
+	}
+	return c.JSON(models.NewMigrationStatus(migrations))
+}
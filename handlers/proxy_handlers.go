@@ -3,8 +3,10 @@ package handlers
 import (
 	"encoding/base64"
 	"fmt"
+	"log"
 	"strconv"
 	"strings"
+	"time"
 
 	"go-proxy-rotator/models"
 	"go-proxy-rotator/services"
@@ -12,12 +14,42 @@ import (
 	"github.com/gofiber/fiber/v2"
 )
 
+// ProxyOptions configures a ProxyHandler.
+type ProxyOptions struct {
+	// RevealCredentials lets callers with the credentials:reveal permission
+	// ask for passwords in the clear with ?reveal=true; otherwise they are
+	// always masked.
+	RevealCredentials bool
+}
+
 type ProxyHandler struct {
 	proxyService *services.ProxyService
+	opts         ProxyOptions
 }
 
-func NewProxyHandler(proxyService *services.ProxyService) *ProxyHandler {
-	return &ProxyHandler{proxyService: proxyService}
+func NewProxyHandler(proxyService *services.ProxyService, opts ProxyOptions) *ProxyHandler {
+	return &ProxyHandler{proxyService: proxyService, opts: opts}
+}
+
+// present prepares proxies for a response, masking their passwords unless
+// the caller asked to reveal them with ?reveal=true and may
+func (h *ProxyHandler) present(c *fiber.Ctx, proxies []*models.Proxy) ([]*models.Proxy, *fiber.Error) {
+	if c.QueryBool("reveal") {
+		if !h.opts.RevealCredentials {
+			return nil, fiber.NewError(fiber.StatusForbidden, "Revealing credentials is disabled (CREDENTIAL_REVEAL)")
+		}
+		if err := checkPermission(c, models.PermissionCredentialsReveal); err != nil {
+			return nil, err
+		}
+		log.Printf("Revealed credentials of %d proxies to %s", len(proxies), callerName(c))
+		return proxies, nil
+	}
+
+	masked := make([]*models.Proxy, len(proxies))
+	for i, proxy := range proxies {
+		masked[i] = proxy.Masked()
+	}
+	return masked, nil
 }
 
 // UploadProxyFile handles proxy file uploads
@@ -79,25 +111,140 @@ func (h *ProxyHandler) UploadProxyFile(c *fiber.Ctx) error {
 
 // GetAllProxies returns all proxies
 func (h *ProxyHandler) GetAllProxies(c *fiber.Ctx) error {
-	proxies, err := h.proxyService.DB.GetAllProxies()
+	proxies := h.proxyService.GetAllProxies()
+
+	// Optionally narrow the list down to one health state
+	if state := c.Query("state"); state != "" {
+		if !models.IsProxyState(state) {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": fmt.Sprintf("Invalid state: %s", state),
+			})
+		}
+		filtered := make([]*models.Proxy, 0, len(proxies))
+		for _, proxy := range proxies {
+			if proxy.State == state {
+				filtered = append(filtered, proxy)
+			}
+		}
+		proxies = filtered
+	}
+
+	// ... to one exit IP ...
+	if exitIP := c.Query("exit_ip"); exitIP != "" {
+		filtered := make([]*models.Proxy, 0, len(proxies))
+		for _, proxy := range proxies {
+			if proxy.ExitIP == exitIP {
+				filtered = append(filtered, proxy)
+			}
+		}
+		proxies = filtered
+	}
+
+	// ... and to one anonymity level, "unknown" listing unclassified ones
+	if anonymity := c.Query("anonymity"); anonymity != "" {
+		if anonymity == "unknown" {
+			anonymity = ""
+		} else if !models.IsAnonymityLevel(anonymity) {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": fmt.Sprintf("Invalid anonymity level: %s", anonymity),
+			})
+		}
+		filtered := make([]*models.Proxy, 0, len(proxies))
+		for _, proxy := range proxies {
+			if proxy.Anonymity == anonymity {
+				filtered = append(filtered, proxy)
+			}
+		}
+		proxies = filtered
+	}
+
+	proxies, bad := h.present(c, proxies)
+	if bad != nil {
+		return c.Status(bad.Code).JSON(fiber.Map{
+			"error": bad.Message,
+		})
+	}
+	return c.JSON(fiber.Map{
+		"proxies": proxies,
+		"count":   len(proxies),
+	})
+}
+
+// GetProxyExitIPs returns the exit IP history of a proxy, newest first
+func (h *ProxyHandler) GetProxyExitIPs(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid proxy ID",
+		})
+	}
+	limit := c.QueryInt("limit", 50)
+	if limit < 1 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid limit",
+		})
+	}
+
+	history, ok, err := h.proxyService.ExitIPHistory(id, limit)
+	if !ok {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": fmt.Sprintf("proxy with id %d not found", id),
+		})
+	}
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Failed to get proxies",
+			"error": fmt.Sprintf("Failed to get exit IPs: %v", err),
 		})
 	}
+	if history == nil {
+		history = []models.ExitIP{}
+	}
 
 	return c.JSON(fiber.Map{
-		"proxies": proxies,
-		"count":   len(proxies),
+		"proxy_id": id,
+		"exit_ips": history,
+		"count":    len(history),
 	})
 }
 
-// GetActiveProxies returns only active proxies
-func (h *ProxyHandler) GetActiveProxies(c *fiber.Ctx) error {
-	proxies, err := h.proxyService.DB.GetActiveProxies()
+// GetSharedExitIPs returns the exit IPs seen on more than one proxy within
+// the window given by the "window" query parameter; "0" compares the
+// current exit IPs only
+func (h *ProxyHandler) GetSharedExitIPs(c *fiber.Ctx) error {
+	window := 24 * time.Hour
+	if value := c.Query("window"); value != "" {
+		d, err := time.ParseDuration(value)
+		if value == "0" {
+			d, err = 0, nil
+		}
+		if err != nil || d < 0 {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": fmt.Sprintf("Invalid window: %s", value),
+			})
+		}
+		window = d
+	}
+
+	shared, err := h.proxyService.SharedExitIPs(window)
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Failed to get active proxies",
+			"error": fmt.Sprintf("Failed to get shared exit IPs: %v", err),
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"window": window.String(),
+		"shared": shared,
+		"count":  len(shared),
+	})
+}
+
+// GetActiveProxies returns only active proxies
+func (h *ProxyHandler) GetActiveProxies(c *fiber.Ctx) error {
+	proxies, bad := h.present(c, h.proxyService.GetActiveProxies())
+	if bad != nil {
+		return c.Status(bad.Code).JSON(fiber.Map{
+			"error": bad.Message,
 		})
 	}
 
@@ -117,7 +264,7 @@ func (h *ProxyHandler) DeleteProxy(c *fiber.Ctx) error {
 		})
 	}
 
-	err = h.proxyService.DB.DeleteProxy(id)
+	err = h.proxyService.DeleteProxy(id)
 	if err != nil {
 		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
 			"error": err.Error(),
@@ -149,9 +296,19 @@ func (h *ProxyHandler) AddProxy(c *fiber.Ctx) error {
 	if proxy.Protocol == "" {
 		proxy.Protocol = "http"
 	}
+	if !models.IsSupportedProtocol(proxy.Protocol) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": fmt.Sprintf("Unsupported protocol: %s", proxy.Protocol),
+		})
+	}
+	if !isCountryCode(proxy.Country) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Country must be a two-letter ISO 3166-1 code",
+		})
+	}
 	proxy.IsActive = true
 
-	err := h.proxyService.DB.AddProxy(&proxy)
+	err := h.proxyService.AddProxy(&proxy)
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"error": fmt.Sprintf("Failed to add proxy: %v", err),
@@ -160,41 +317,205 @@ func (h *ProxyHandler) AddProxy(c *fiber.Ctx) error {
 
 	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
 		"message": "Proxy added successfully",
-		"proxy":   proxy,
+		"proxy":   proxy.Masked(),
+	})
+}
+
+// UpdateProxy changes the tags and country of a proxy. Fields left out of
+// the request body keep their current value.
+func (h *ProxyHandler) UpdateProxy(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid proxy ID",
+		})
+	}
+
+	var body struct {
+		Tags    *[]string `json:"tags"`
+		Country *string   `json:"country"`
+	}
+	if err := c.BodyParser(&body); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+
+	current, ok := h.proxyService.Pool.Get(id)
+	if !ok {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": fmt.Sprintf("proxy with id %d not found", id),
+		})
+	}
+	tags, country := current.Tags, current.Country
+	if body.Tags != nil {
+		tags = *body.Tags
+	}
+	if body.Country != nil {
+		country = strings.TrimSpace(*body.Country)
+	}
+	if !isCountryCode(country) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Country must be a two-letter ISO 3166-1 code",
+		})
+	}
+
+	proxy, err := h.proxyService.UpdateProxyLabels(id, tags, country)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": fmt.Sprintf("Failed to update proxy: %v", err),
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "Proxy updated successfully",
+		"proxy":   proxy.Masked(),
 	})
 }
 
 // GetProxyStats returns proxy statistics
 func (h *ProxyHandler) GetProxyStats(c *fiber.Ctx) error {
-	stats, err := h.proxyService.DB.GetProxyStats()
+	return c.JSON(h.proxyService.GetProxyStats())
+}
+
+// GetBreakers returns the circuit breaker state of every proxy that has
+// carried traffic
+func (h *ProxyHandler) GetBreakers(c *fiber.Ctx) error {
+	breakers := h.proxyService.BreakerStatuses()
+
+	// Optionally narrow the list down to one breaker state
+	if state := c.Query("state"); state != "" {
+		filtered := make([]models.BreakerStatus, 0, len(breakers))
+		for _, breaker := range breakers {
+			if breaker.State == state {
+				filtered = append(filtered, breaker)
+			}
+		}
+		breakers = filtered
+	}
+
+	return c.JSON(fiber.Map{
+		"breakers": breakers,
+		"count":    len(breakers),
+	})
+}
+
+// GetBreaker returns the circuit breaker state of a proxy
+func (h *ProxyHandler) GetBreaker(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
 	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Failed to get proxy statistics",
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid proxy ID",
+		})
+	}
+
+	breaker, ok := h.proxyService.BreakerStatus(id)
+	if !ok {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": fmt.Sprintf("proxy with id %d not found", id),
+		})
+	}
+
+	return c.JSON(breaker)
+}
+
+// ResetBreaker closes a proxy's circuit breaker, putting it back into
+// rotation
+func (h *ProxyHandler) ResetBreaker(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid proxy ID",
 		})
 	}
 
-	return c.JSON(stats)
+	if !h.proxyService.ResetBreaker(id) {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": fmt.Sprintf("proxy with id %d not found", id),
+		})
+	}
+
+	breaker, _ := h.proxyService.BreakerStatus(id)
+	return c.JSON(fiber.Map{
+		"message": "Circuit breaker reset",
+		"breaker": breaker,
+	})
+}
+
+// GetProxyDomains returns the health of a proxy for every target domain
+// it has served
+func (h *ProxyHandler) GetProxyDomains(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid proxy ID",
+		})
+	}
+
+	domains, ok := h.proxyService.DomainHealth(id)
+	if !ok {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": fmt.Sprintf("proxy with id %d not found", id),
+		})
+	}
+
+	// Optionally narrow the list down to one health state
+	if state := c.Query("state"); state != "" {
+		if !models.IsProxyState(state) {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": fmt.Sprintf("Invalid state: %s", state),
+			})
+		}
+		filtered := make([]models.DomainHealth, 0, len(domains))
+		for _, domain := range domains {
+			if domain.State == state {
+				filtered = append(filtered, domain)
+			}
+		}
+		domains = filtered
+	}
+	if domains == nil {
+		domains = []models.DomainHealth{}
+	}
+
+	return c.JSON(fiber.Map{
+		"proxy_id": id,
+		"domains":  domains,
+		"count":    len(domains),
+	})
 }
 
-// HealthCheckProxies performs health check on all proxies
-func (h *ProxyHandler) HealthCheckProxies(c *fiber.Ctx) error {
-	testURL := c.Query("url", "https://httpbin.org/ip")
+// ResetProxyDomain forgets the health of a proxy for one domain, putting
+// it back into rotation for that domain
+func (h *ProxyHandler) ResetProxyDomain(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid proxy ID",
+		})
+	}
+	domain := c.Params("domain")
 
-	err := h.proxyService.HealthCheckAllProxies(testURL)
+	found, err := h.proxyService.ResetDomainHealth(id, domain)
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": fmt.Sprintf("Health check failed: %v", err),
+			"error": fmt.Sprintf("Failed to reset domain health: %v", err),
+		})
+	}
+	if !found {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": fmt.Sprintf("no health recorded for proxy %d and domain %s", id, domain),
 		})
 	}
 
 	return c.JSON(fiber.Map{
-		"message": "Health check completed",
+		"message": "Domain health reset",
 	})
 }
 
 // ClearAllProxies removes all proxies
 func (h *ProxyHandler) ClearAllProxies(c *fiber.Ctx) error {
-	err := h.proxyService.DB.ClearAllProxies()
+	err := h.proxyService.ClearAllProxies()
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"error": "Failed to clear proxies",
@@ -212,6 +533,22 @@ func basicAuth(username, password string) string {
 	return "Basic " + base64.StdEncoding.EncodeToString([]byte(auth))
 }
 
+// isCountryCode reports whether code is empty or two ASCII letters
+func isCountryCode(code string) bool {
+	if code == "" {
+		return true
+	}
+	if len(code) != 2 {
+		return false
+	}
+	for _, r := range code {
+		if (r < 'a' || r > 'z') && (r < 'A' || r > 'Z') {
+			return false
+		}
+	}
+	return true
+}
+
 // isTextFile checks if the filename has a .txt extension
 func isTextFile(filename string) bool {
 	return strings.HasSuffix(strings.ToLower(filename), ".txt")
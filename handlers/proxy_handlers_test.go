@@ -0,0 +1,75 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+
+	"go-proxy-rotator/models"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestPresentMasksPasswords(t *testing.T) {
+	tests := []struct {
+		name     string
+		reveal   bool
+		caller   *models.APIKey
+		query    string
+		status   int
+		password string
+	}{
+		{name: "masked by default", reveal: true, status: fiber.StatusOK, password: models.MaskedPassword},
+		{name: "reveal disabled", query: "?reveal=true", status: fiber.StatusForbidden},
+		{name: "reveal without authentication", reveal: true, query: "?reveal=true", status: fiber.StatusOK, password: "secret"},
+		{name: "reveal as admin", reveal: true, caller: &models.APIKey{Name: "admin", Role: models.RoleAdmin},
+			query: "?reveal=true", status: fiber.StatusOK, password: "secret"},
+		{name: "reveal as operator", reveal: true, caller: &models.APIKey{Name: "operator", Role: models.RoleOperator},
+			query: "?reveal=true", status: fiber.StatusForbidden},
+		{name: "masked for viewer", reveal: true, caller: &models.APIKey{Name: "viewer", Role: models.RoleViewer},
+			status: fiber.StatusOK, password: models.MaskedPassword},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			proxy := &models.Proxy{ID: 1, Host: "10.0.0.1", Port: 8080, Username: "user", Password: "secret"}
+			h := &ProxyHandler{opts: ProxyOptions{RevealCredentials: tt.reveal}}
+
+			app := fiber.New()
+			app.Get("/proxies", func(c *fiber.Ctx) error {
+				if tt.caller != nil {
+					c.Locals(apiKeyLocal, tt.caller)
+				}
+				proxies, bad := h.present(c, []*models.Proxy{proxy})
+				if bad != nil {
+					return c.Status(bad.Code).JSON(fiber.Map{"error": bad.Message})
+				}
+				return c.JSON(fiber.Map{"proxies": proxies})
+			})
+
+			resp, err := app.Test(httptest.NewRequest("GET", "/proxies"+tt.query, nil))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != tt.status {
+				t.Fatalf("status = %d, want %d", resp.StatusCode, tt.status)
+			}
+			if proxy.Password != "secret" {
+				t.Errorf("present changed the stored password to %q", proxy.Password)
+			}
+			if tt.status != fiber.StatusOK {
+				return
+			}
+
+			var body struct {
+				Proxies []*models.Proxy `json:"proxies"`
+			}
+			if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+				t.Fatal(err)
+			}
+			if len(body.Proxies) != 1 || body.Proxies[0].Password != tt.password {
+				t.Errorf("proxies = %+v, want one with password %q", body.Proxies, tt.password)
+			}
+		})
+	}
+}
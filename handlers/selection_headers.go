@@ -0,0 +1,137 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"go-proxy-rotator/models"
+	"go-proxy-rotator/services"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Control headers let a client steer proxy selection for a single request.
+// They are consumed by the rotator and never forwarded.
+const (
+	strategyHeader   = "X-Proxy-Strategy"
+	sessionHeader    = "X-Proxy-Session"
+	idHeader         = "X-Proxy-Id"
+	protocolHeader   = "X-Proxy-Protocol"
+	tagHeader        = "X-Proxy-Tag"
+	countryHeader    = "X-Proxy-Country"
+	maxLatencyHeader = "X-Proxy-Max-Latency"
+	anonymityHeader  = "X-Proxy-Anonymity"
+)
+
+var controlHeaders = []string{strategyHeader, sessionHeader, idHeader,
+	protocolHeader, tagHeader, countryHeader, maxLatencyHeader, anonymityHeader}
+
+// sessionSuffix separates the session ID from the rest of a
+// Proxy-Authorization username.
+const sessionSuffix = "-session-"
+
+const maxSessionIDLength = 128
+
+// selectOptions reads the control headers of a request into selection
+// options.
+func selectOptions(c *fiber.Ctx) (services.SelectOptions, error) {
+	opts := services.SelectOptions{
+		Strategy: c.Get(strategyHeader),
+		Exclude:  make(map[int]bool),
+		Tag:      strings.ToLower(strings.TrimSpace(c.Get(tagHeader))),
+		Country:  strings.ToUpper(strings.TrimSpace(c.Get(countryHeader))),
+	}
+
+	session, ok := sessionID(c)
+	if !ok {
+		return opts, fmt.Errorf("invalid session ID: must be 1 to %d characters", maxSessionIDLength)
+	}
+	opts.Session = session
+
+	if value := c.Get(idHeader); value != "" {
+		id, err := strconv.Atoi(value)
+		if err != nil || id <= 0 {
+			return opts, fmt.Errorf("invalid %s: %q", idHeader, value)
+		}
+		opts.ProxyID = id
+	}
+
+	if value := c.Get(protocolHeader); value != "" {
+		opts.Protocol = strings.ToLower(value)
+		if !models.IsSupportedProtocol(opts.Protocol) {
+			return opts, fmt.Errorf("invalid %s: unsupported protocol %q", protocolHeader, value)
+		}
+	}
+
+	if value := c.Get(anonymityHeader); value != "" {
+		opts.Anonymity = strings.ToLower(strings.TrimSpace(value))
+		if !models.IsAnonymityLevel(opts.Anonymity) {
+			return opts, fmt.Errorf("invalid %s: unknown anonymity level %q", anonymityHeader, value)
+		}
+	}
+
+	if value := c.Get(maxLatencyHeader); value != "" {
+		latency, err := parseLatency(value)
+		if err != nil {
+			return opts, fmt.Errorf("invalid %s: %q", maxLatencyHeader, value)
+		}
+		opts.MaxLatency = latency
+	}
+
+	return opts, nil
+}
+
+// parseLatency accepts a Go duration ("1.5s", "800ms") or a plain number
+// of milliseconds.
+func parseLatency(value string) (time.Duration, error) {
+	if ms, err := strconv.Atoi(value); err == nil {
+		if ms <= 0 {
+			return 0, fmt.Errorf("latency must be positive")
+		}
+		return time.Duration(ms) * time.Millisecond, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, err
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("latency must be positive")
+	}
+	return d, nil
+}
+
+// sessionID returns the sticky session a request asks for, taken from the
+// X-Proxy-Session header or from a "-session-<id>" suffix on the
+// Proxy-Authorization username (e.g. "user-session-abc"). The ID is empty
+// for requests outside a session; ok is false if the ID is malformed.
+func sessionID(c *fiber.Ctx) (id string, ok bool) {
+	id = c.Get(sessionHeader)
+	if id == "" {
+		username, _, found := proxyCredentials(c.Get(fiber.HeaderProxyAuthorization))
+		if !found {
+			return "", true
+		}
+		i := strings.LastIndex(username, sessionSuffix)
+		if i < 0 {
+			return "", true
+		}
+		id = username[i+len(sessionSuffix):]
+	}
+	return id, id != "" && len(id) <= maxSessionIDLength
+}
+
+// proxyCredentials decodes a Basic Proxy-Authorization header value.
+func proxyCredentials(header string) (username, password string, ok bool) {
+	const prefix = "Basic "
+	if len(header) < len(prefix) || !strings.EqualFold(header[:len(prefix)], prefix) {
+		return "", "", false
+	}
+	decoded, err := base64.StdEncoding.DecodeString(header[len(prefix):])
+	if err != nil {
+		return "", "", false
+	}
+	return strings.Cut(string(decoded), ":")
+}
@@ -0,0 +1,97 @@
+package handlers
+
+import (
+	"fmt"
+	"net/url"
+
+	"go-proxy-rotator/models"
+	"go-proxy-rotator/services"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+type SessionHandler struct {
+	proxyService *services.ProxyService
+}
+
+func NewSessionHandler(proxyService *services.ProxyService) *SessionHandler {
+	return &SessionHandler{proxyService: proxyService}
+}
+
+// sessionView is a session together with the proxy it is pinned to.
+type sessionView struct {
+	*models.Session
+	Proxy *models.Proxy `json:"proxy,omitempty"`
+}
+
+func (h *SessionHandler) view(session *models.Session) sessionView {
+	view := sessionView{Session: session}
+	if proxy, ok := h.proxyService.Pool.Get(session.ProxyID); ok {
+		view.Proxy = proxy.Masked()
+	}
+	return view
+}
+
+// GetSessions returns all live sticky sessions
+func (h *SessionHandler) GetSessions(c *fiber.Ctx) error {
+	sessions := h.proxyService.Sessions.List()
+	views := make([]sessionView, 0, len(sessions))
+	for _, session := range sessions {
+		views = append(views, h.view(session))
+	}
+
+	return c.JSON(fiber.Map{
+		"sessions":    views,
+		"count":       len(views),
+		"ttl_seconds": int(h.proxyService.Sessions.TTL().Seconds()),
+	})
+}
+
+// GetSession returns a single sticky session by ID
+func (h *SessionHandler) GetSession(c *fiber.Ctx) error {
+	id, err := url.PathUnescape(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid session ID",
+		})
+	}
+
+	session, ok := h.proxyService.Sessions.Get(id)
+	if !ok {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": fmt.Sprintf("Session %s not found", id),
+		})
+	}
+
+	return c.JSON(h.view(session))
+}
+
+// DeleteSession revokes a sticky session; its next request is pinned anew
+func (h *SessionHandler) DeleteSession(c *fiber.Ctx) error {
+	id, err := url.PathUnescape(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid session ID",
+		})
+	}
+
+	if !h.proxyService.Sessions.Delete(id) {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": fmt.Sprintf("Session %s not found", id),
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "Session revoked successfully",
+	})
+}
+
+// ClearSessions revokes every sticky session
+func (h *SessionHandler) ClearSessions(c *fiber.Ctx) error {
+	removed := h.proxyService.Sessions.Clear()
+
+	return c.JSON(fiber.Map{
+		"message": "All sessions revoked successfully",
+		"removed": removed,
+	})
+}
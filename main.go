@@ -5,21 +5,24 @@ import (
 	"fmt"
 	"log"
 	"math/rand"
-	"net/url"
+	"os"
+	"os/signal"
 	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
 	"go-proxy-rotator/config"
 	"go-proxy-rotator/database"
+	"go-proxy-rotator/forwarder"
 	"go-proxy-rotator/handlers"
 	"go-proxy-rotator/models"
 	"go-proxy-rotator/services"
+	"go-proxy-rotator/socks"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/cors"
 	"github.com/gofiber/fiber/v2/middleware/logger"
-	"github.com/gofiber/fiber/v2/middleware/proxy"
 	"github.com/gofiber/fiber/v2/middleware/recover"
 )
 
@@ -34,26 +37,160 @@ func main() {
 	// Load configuration
 	cfg := config.Load()
 
-	// Initialize database
-	db, err := database.New(cfg.DatabasePath)
+	// Commands such as "migrate status" run instead of the server
+	if len(os.Args) > 1 {
+		os.Exit(runCommand(cfg, os.Args[1:]))
+	}
+
+	// The breaker compares failures against this share of requests
+	if !(cfg.BreakerErrorRate > 0 && cfg.BreakerErrorRate <= 1) {
+		log.Fatalf("Invalid BREAKER_ERROR_RATE %v: must be greater than 0 and at most 1", cfg.BreakerErrorRate)
+	}
+
+	// Initialize database, bringing its schema up to date
+	db, err := database.Open(cfg.DatabaseURL)
 	if err != nil {
 		log.Fatalf("Failed to initialize database: %v", err)
 	}
 	defer db.Close()
+	applied, err := db.Migrate()
+	if err != nil {
+		log.Fatalf("Failed to migrate database: %v", err)
+	}
+	if applied > 0 {
+		log.Printf("Applied %d database migration(s)", applied)
+	}
+
+	// Proxy passwords are encrypted at rest once credential keys are set
+	keyring, err := database.ParseKeyring(cfg.CredentialKeys)
+	if err != nil {
+		log.Fatalf("Invalid CREDENTIAL_KEYS: %v", err)
+	}
+	db.SetKeyring(keyring)
+	if keyring != nil {
+		if usage, err := db.CredentialKeyUsage(); err != nil {
+			log.Printf("Warning: Failed to inspect stored credentials: %v", err)
+		} else if stale := staleCredentials(usage, keyring); stale > 0 {
+			log.Printf("Warning: %d proxy password(s) are not encrypted with credential key %s; run \"%s credentials reencrypt\"",
+				stale, keyring.Primary(), os.Args[0])
+		}
+	}
+
+	// Load the proxy pool into memory
+	pool, err := services.NewPool(db, services.PoolOptions{
+		FlushInterval:   cfg.PoolFlushInterval,
+		SyncInterval:    cfg.PoolSyncInterval,
+		CooldownBase:    cfg.CooldownBase,
+		CooldownMax:     cfg.CooldownMax,
+		ReactivateAfter: cfg.ReactivateAfter,
+	})
+	if err != nil {
+		log.Fatalf("Failed to load proxy pool: %v", err)
+	}
+
+	// Sticky sessions live in memory only
+	sessions := services.NewSessionStore(cfg.SessionTTL)
+	defer sessions.Close()
+
+	// Health per (proxy, target domain) pair
+	domains, err := services.NewDomainHealth(db, services.DomainHealthOptions{
+		MaxFailures:   cfg.DomainMaxFailures,
+		CooldownBase:  cfg.DomainCooldownBase,
+		CooldownMax:   cfg.DomainCooldownMax,
+		TTL:           cfg.DomainHealthTTL,
+		FlushInterval: cfg.PoolFlushInterval,
+	})
+	if err != nil {
+		log.Fatalf("Failed to load domain health: %v", err)
+	}
 
 	// Initialize services
-	proxyService := services.NewProxyService(db)
+	proxyService, err := services.NewProxyService(db, pool, sessions, domains, services.ServiceOptions{
+		Strategy:               cfg.SelectionStrategy,
+		HealthCheckConcurrency: cfg.HealthCheckConcurrency,
+		HealthCheckTimeout:     cfg.HealthCheckTimeout,
+		JudgeURL:               cfg.JudgeURL,
+		ExitIPHistory:          cfg.ExitIPHistory,
+		Breaker: services.BreakerOptions{
+			Window:            cfg.BreakerWindow,
+			MinRequests:       cfg.BreakerMinRequests,
+			ErrorRate:         cfg.BreakerErrorRate,
+			OpenDuration:      cfg.BreakerOpenDuration,
+			HalfOpenSuccesses: cfg.BreakerHalfOpenSuccesses,
+		},
+	})
+	if err != nil {
+		log.Fatalf("Failed to initialize proxy service: %v", err)
+	}
+	fwd := forwarder.New(cfg.ProxyTimeout)
+	proxyService.OnRemove(fwd.Forget)
+
+	// Ban rules recognise blocked responses on the data plane
+	banRules, err := services.NewBanRules(db, services.BanRulesOptions{
+		BodyLimit:    cfg.BanBodyLimit,
+		BodyWait:     cfg.BanBodyWait,
+		SyncInterval: cfg.PoolSyncInterval,
+	})
+	if err != nil {
+		log.Fatalf("Failed to load ban rules: %v", err)
+	}
+
+	// Every health check is kept, then rolled up by the hour
+	checkHistory := services.NewCheckHistory(db, services.CheckHistoryOptions{
+		Retention:       cfg.CheckHistoryRetention,
+		RollupRetention: cfg.CheckRollupRetention,
+		Interval:        cfg.CheckRollupInterval,
+	})
+
+	// Health checks run as jobs, on demand and in the background
+	healthJobs, err := services.NewHealthJobs(proxyService, cfg.HealthCheckURL, cfg.HealthJobHistory)
+	if err != nil {
+		log.Fatalf("Failed to initialize health jobs: %v", err)
+	}
+	healthScheduler := services.NewHealthScheduler(proxyService, healthJobs, services.HealthSchedulerConfig{
+		URL:              cfg.HealthCheckURL,
+		Interval:         cfg.HealthCheckInterval,
+		InactiveInterval: cfg.HealthCheckInactiveInterval,
+		Jitter:           cfg.HealthCheckJitter,
+	})
+
+	// API keys guard the management API
+	apiKeys := services.NewAPIKeys(db, cfg.AdminAPIKey)
+	if !cfg.APIAuth {
+		log.Printf("Warning: API_AUTH is off, the management API is open to anyone who can reach it")
+	} else if ok, err := apiKeys.Configured(); err != nil {
+		log.Printf("Warning: Failed to look up API keys: %v", err)
+	} else if !ok {
+		log.Fatalf("No API keys exist, so every API request would be rejected; set ADMIN_API_KEY, run \"%s keys create <name>\" or set API_AUTH=false", os.Args[0])
+	}
 
 	// Initialize handlers
-	proxyHandler := handlers.NewProxyHandler(proxyService)
+	proxyHandler := handlers.NewProxyHandler(proxyService, handlers.ProxyOptions{
+		RevealCredentials: cfg.CredentialReveal,
+	})
+	sessionHandler := handlers.NewSessionHandler(proxyService)
+	healthHandler := handlers.NewHealthHandler(healthScheduler)
+	healthJobHandler := handlers.NewHealthJobHandler(healthJobs)
+	banRuleHandler := handlers.NewBanRuleHandler(banRules)
+	judgeHandler := handlers.NewJudgeHandler()
+	checkHandler := handlers.NewCheckHandler(proxyService, checkHistory)
+	forwardHandler := handlers.NewForwardHandler(proxyService, fwd, banRules, handlers.ForwardOptions{
+		IdleTimeout:      cfg.TunnelIdleTimeout,
+		MaxRetries:       cfg.RetryMax,
+		RetryStatusCodes: cfg.RetryStatusCodes,
+		RetryBodyLimit:   cfg.RetryBodyLimit,
+		BodyLimit:        cfg.ProxyBodyLimit,
+	})
+	migrationHandler := handlers.NewMigrationHandler(db)
+	apiKeyHandler := handlers.NewAPIKeyHandler(apiKeys)
 	swaggerHandler := handlers.NewSwaggerHandler()
 
 	// Load initial proxies if database is empty
-	if err := loadInitialProxies(proxyService, db); err != nil {
+	if err := loadInitialProxies(proxyService); err != nil {
 		log.Printf("Warning: Failed to load initial proxies: %v", err)
 	}
 
-	// Create Fiber app
+	// Create Fiber app for the management API and dashboard
 	app := fiber.New(fiber.Config{
 		BodyLimit: int(cfg.MaxFileSize),
 	})
@@ -61,20 +198,84 @@ func main() {
 	// Middleware
 	app.Use(recover.New())
 	app.Use(logger.New())
-	app.Use(cors.New())
+	if cfg.CORSAllowedOrigins != "" {
+		app.Use(cors.New(cors.Config{
+			AllowOrigins: cfg.CORSAllowedOrigins,
+			AllowHeaders: "Authorization, Content-Type, X-API-Key",
+		}))
+	}
 
-	// API routes
+	// API routes, behind API keys
 	api := app.Group("/api/v1")
+	if cfg.APIAuth {
+		api.Use(handlers.RequireAPIKey(apiKeys))
+	}
+
+	// Route groups by the permission their routes need; a key's role
+	// decides which it may use
+	read := handlers.Restrict(api, models.PermissionProxiesRead)
+	reset := handlers.Restrict(api, models.PermissionProxiesReset)
+	write := handlers.Restrict(api, models.PermissionProxiesWrite)
+	remove := handlers.Restrict(api, models.PermissionProxiesDelete)
+	runHealth := handlers.Restrict(api, models.PermissionHealthRun)
+	revokeSessions := handlers.Restrict(api, models.PermissionSessionsRevoke)
+	editBanRules := handlers.Restrict(api, models.PermissionBanRulesWrite)
+	manageKeys := handlers.Restrict(api, models.PermissionKeysManage)
+
+	// The caller's own role and permissions
+	api.Get("/me", handlers.GetCaller)
 
 	// Proxy management routes
-	api.Post("/proxies/upload", proxyHandler.UploadProxyFile)
-	api.Get("/proxies", proxyHandler.GetAllProxies)
-	api.Get("/proxies/active", proxyHandler.GetActiveProxies)
-	api.Post("/proxies", proxyHandler.AddProxy)
-	api.Delete("/proxies/:id", proxyHandler.DeleteProxy)
-	api.Delete("/proxies", proxyHandler.ClearAllProxies)
-	api.Get("/proxies/stats", proxyHandler.GetProxyStats)
-	api.Post("/proxies/health-check", proxyHandler.HealthCheckProxies)
+	write.Post("/proxies/upload", proxyHandler.UploadProxyFile)
+	read.Get("/proxies", proxyHandler.GetAllProxies)
+	read.Get("/proxies/active", proxyHandler.GetActiveProxies)
+	write.Post("/proxies", proxyHandler.AddProxy)
+	write.Patch("/proxies/:id", proxyHandler.UpdateProxy)
+	remove.Delete("/proxies/:id", proxyHandler.DeleteProxy)
+	remove.Delete("/proxies", proxyHandler.ClearAllProxies)
+	read.Get("/proxies/stats", proxyHandler.GetProxyStats)
+	read.Get("/proxies/:id/breaker", proxyHandler.GetBreaker)
+	reset.Post("/proxies/:id/breaker/reset", proxyHandler.ResetBreaker)
+	read.Get("/breakers", proxyHandler.GetBreakers)
+	read.Get("/proxies/:id/domains", proxyHandler.GetProxyDomains)
+	reset.Delete("/proxies/:id/domains/:domain", proxyHandler.ResetProxyDomain)
+	read.Get("/proxies/:id/exit-ips", proxyHandler.GetProxyExitIPs)
+	read.Get("/proxies/:id/checks", checkHandler.GetProxyChecks)
+	read.Get("/proxies/:id/latency", checkHandler.GetProxyLatency)
+	read.Get("/exit-ips/shared", proxyHandler.GetSharedExitIPs)
+	runHealth.Post("/proxies/health-check", healthJobHandler.StartJob)
+
+	// Health check job routes
+	read.Get("/health-jobs", healthJobHandler.GetJobs)
+	read.Get("/health-jobs/:id", healthJobHandler.GetJob)
+	runHealth.Post("/health-jobs/:id/cancel", healthJobHandler.CancelJob)
+
+	// Ban rule routes
+	read.Get("/ban-rules", banRuleHandler.GetRules)
+	editBanRules.Post("/ban-rules", banRuleHandler.CreateRule)
+	read.Get("/ban-rules/:id", banRuleHandler.GetRule)
+	editBanRules.Patch("/ban-rules/:id", banRuleHandler.UpdateRule)
+	editBanRules.Delete("/ban-rules/:id", banRuleHandler.DeleteRule)
+
+	// Background health checker routes
+	read.Get("/health-checker", healthHandler.GetStatus)
+	runHealth.Post("/health-checker/pause", healthHandler.Pause)
+	runHealth.Post("/health-checker/resume", healthHandler.Resume)
+	runHealth.Post("/health-checker/trigger", healthHandler.Trigger)
+
+	// Sticky session routes
+	read.Get("/sessions", sessionHandler.GetSessions)
+	read.Get("/sessions/:id", sessionHandler.GetSession)
+	revokeSessions.Delete("/sessions/:id", sessionHandler.DeleteSession)
+	revokeSessions.Delete("/sessions", sessionHandler.ClearSessions)
+
+	// Database schema
+	read.Get("/migrations", migrationHandler.GetMigrations)
+
+	// API keys
+	manageKeys.Get("/keys", apiKeyHandler.GetKeys)
+	manageKeys.Post("/keys", apiKeyHandler.CreateKey)
+	manageKeys.Delete("/keys/:id", apiKeyHandler.RevokeKey)
 
 	// Setup Swagger documentation
 	swaggerHandler.SetupSwaggerRoutes(app)
@@ -93,60 +294,95 @@ func main() {
 		})
 	})
 
-	// Main proxy middleware (for actual proxy usage)
-	app.Use(func(c *fiber.Ctx) error {
-		// Skip API routes
-		if c.Path() == "/health" || c.Path() == "/api/v1" ||
-			len(c.Path()) > 7 && c.Path()[:7] == "/api/v1" {
-			return c.Next()
-		}
-
-		// Get a random proxy
-		selectedProxy, err := proxyService.GetRandomProxy()
-		if err != nil {
-			return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{
-				"error": "No proxies available",
-			})
+	// Proxy data plane on its own listener, so forwarded paths can never
+	// collide with internal routes and the admin port can be firewalled.
+	// Bodies over the retry limit are streamed rather than read up front
+	proxyApp := fiber.New(fiber.Config{
+		BodyLimit:             int(max(cfg.RetryBodyLimit, 1)),
+		StreamRequestBody:     true,
+		ReadBufferSize:        16 * 1024, // room for long URLs and cookies
+		DisableStartupMessage: true,
+	})
+	proxyApp.Use(recover.New())
+	proxyApp.Use(logger.New())
+	proxyApp.Use(forwardHandler.Handle)
+
+	go func() {
+		log.Printf("Proxy server starting on %s", cfg.ProxyAddr())
+		if err := proxyApp.Listen(cfg.ProxyAddr()); err != nil {
+			log.Fatal(err)
 		}
-
-		// Create proxy URL
-		proxyURL := selectedProxy.GetURL()
-		if selectedProxy.Username != "" && selectedProxy.Password != "" {
-			u, _ := url.Parse(proxyURL)
-			u.User = url.UserPassword(selectedProxy.Username, selectedProxy.Password)
-			proxyURL = u.String()
+	}()
+
+	// Optional SOCKS5 front end, relaying through the same proxy pool
+	if cfg.SocksAddr != "" {
+		socksServer := &socks.Server{
+			Username:         cfg.SocksUsername,
+			Password:         cfg.SocksPassword,
+			Dial:             forwardHandler.DialUpstream,
+			HandshakeTimeout: cfg.ProxyTimeout,
+			IdleTimeout:      cfg.TunnelIdleTimeout,
 		}
+		go func() {
+			log.Printf("SOCKS5 server starting on %s", cfg.SocksAddr)
+			log.Fatal(socksServer.ListenAndServe(cfg.SocksAddr))
+		}()
+	}
 
-		// Forward the request to the proxy
-		if err := proxy.Do(c, proxyURL); err != nil {
-			// Update proxy health on failure
-			go func() {
-				db.UpdateProxyHealth(selectedProxy.ID, 0, false)
-			}()
-			return err
-		}
+	// Optional anonymity judge, called through the proxies by health
+	// checks; on its own listener as every proxy must be able to reach it
+	var judgeApp *fiber.App
+	if cfg.JudgeAddr != "" {
+		judgeApp = fiber.New(fiber.Config{DisableStartupMessage: true})
+		judgeApp.Use(recover.New())
+		judgeApp.Use(logger.New())
+		judgeApp.Get("/judge", judgeHandler.Judge)
 
-		// Update proxy health on success
 		go func() {
-			db.UpdateProxyHealth(selectedProxy.ID, 100, true)
+			log.Printf("Anonymity judge starting on %s", cfg.JudgeAddr)
+			if err := judgeApp.Listen(cfg.JudgeAddr); err != nil {
+				log.Fatal(err)
+			}
 		}()
+	}
 
-		return nil
-	})
+	healthScheduler.Start()
 
 	log.Printf("Go Proxy Rotator %s (build: %s, commit: %s)", Version, BuildTime, GitCommit)
-	log.Printf("Server starting on port %s", cfg.Port)
-	log.Fatal(app.Listen(":" + cfg.Port))
+	// Flush pending health updates before exiting
+	go func() {
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+		<-sig
+		log.Println("Shutting down...")
+		proxyApp.Shutdown()
+		if judgeApp != nil {
+			judgeApp.Shutdown()
+		}
+		app.Shutdown()
+	}()
+
+	log.Printf("Admin server starting on %s", cfg.AdminAddr())
+	if err := app.Listen(cfg.AdminAddr()); err != nil {
+		log.Fatal(err)
+	}
+
+	healthScheduler.Stop()
+	healthJobs.Close()
+	checkHistory.Close()
+	banRules.Close()
+	if err := domains.Close(); err != nil {
+		log.Printf("Failed to flush domain health: %v", err)
+	}
+	if err := pool.Close(); err != nil {
+		log.Printf("Failed to flush proxy pool: %v", err)
+	}
 }
 
 // loadInitialProxies loads initial proxies if database is empty
-func loadInitialProxies(proxyService *services.ProxyService, db *database.DB) error {
+func loadInitialProxies(proxyService *services.ProxyService) error {
 	// Check if we already have proxies
-	stats, err := db.GetProxyStats()
-	if err != nil {
-		return err
-	}
-
+	stats := proxyService.GetProxyStats()
 	if stats.TotalProxies > 0 {
 		log.Printf("Database already contains %d proxies", stats.TotalProxies)
 		return nil
@@ -0,0 +1,21 @@
+package models
+
+import "time"
+
+// APIKey authenticates callers of the management API. The key itself is
+// shown once, when it is created; only its hash is stored.
+type APIKey struct {
+	ID         int        `json:"id" db:"id"`
+	Name       string     `json:"name" db:"name"`
+	Prefix     string     `json:"prefix" db:"prefix"` // first characters of the key, to tell keys apart
+	Hash       string     `json:"-" db:"key_hash"`    // hex SHA-256 of the key
+	Role       string     `json:"role" db:"role"`     // one of Roles
+	CreatedAt  time.Time  `json:"created_at" db:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty" db:"last_used_at"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty" db:"revoked_at"`
+}
+
+// Revoked reports whether the key no longer authenticates.
+func (k *APIKey) Revoked() bool {
+	return k.RevokedAt != nil
+}
@@ -0,0 +1,30 @@
+package models
+
+import "time"
+
+// Ban rule scopes
+const (
+	// BanScopeProxy rules count a match as a failure of the proxy itself.
+	BanScopeProxy = "proxy"
+	// BanScopeDomain rules only take the proxy out of rotation for the
+	// target domain that banned it.
+	BanScopeDomain = "domain"
+)
+
+// BanRule recognises upstream responses that mean the target site blocked
+// the proxy, such as a CAPTCHA page served with status 200. A rule matches
+// when all of its conditions hold; conditions left empty match anything.
+type BanRule struct {
+	ID            int       `json:"id" db:"id"`
+	Name          string    `json:"name" db:"name"`
+	Domain        string    `json:"domain" db:"domain"`                 // target domain and its subdomains; empty matches every target
+	StatusCodes   []int     `json:"status_codes" db:"status_codes"`     // any of these
+	Header        string    `json:"header" db:"header"`                 // response header that must be present
+	HeaderPattern string    `json:"header_pattern" db:"header_pattern"` // regular expression the header value must match
+	BodyPattern   string    `json:"body_pattern" db:"body_pattern"`     // regular expression matched against the start of the body
+	Scope         string    `json:"scope" db:"scope"`                   // proxy or domain
+	Enabled       bool      `json:"enabled" db:"enabled"`
+	Hits          int64     `json:"hits" db:"-"` // matches since the server started
+	CreatedAt     time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at" db:"updated_at"`
+}
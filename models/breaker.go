@@ -0,0 +1,21 @@
+package models
+
+import "time"
+
+// Circuit breaker states
+const (
+	BreakerClosed   = "closed"
+	BreakerOpen     = "open"
+	BreakerHalfOpen = "half-open"
+)
+
+// BreakerStatus describes the circuit breaker of one proxy
+type BreakerStatus struct {
+	ProxyID   int        `json:"proxy_id"`
+	State     string     `json:"state"`    // closed, open, half-open
+	Requests  int        `json:"requests"` // in the sliding window
+	Failures  int        `json:"failures"`
+	ErrorRate float64    `json:"error_rate"`
+	OpenedAt  *time.Time `json:"opened_at,omitempty"`
+	RetryAt   *time.Time `json:"retry_at,omitempty"` // when an open breaker lets a trial request through
+}
@@ -0,0 +1,21 @@
+package models
+
+import "time"
+
+// DomainHealth is the health of one proxy for one target domain. A proxy
+// that keeps failing for a domain, or gets banned by it, is put into
+// cooldown for that domain only. State uses the proxy health states.
+type DomainHealth struct {
+	ProxyID       int        `json:"proxy_id" db:"proxy_id"`
+	Domain        string     `json:"domain" db:"domain"`
+	State         string     `json:"state" db:"-"` // active, cooldown, recovering
+	Requests      int        `json:"requests" db:"requests"`
+	Successes     int        `json:"successes" db:"successes"`
+	Failures      int        `json:"failures" db:"failures"`
+	Bans          int        `json:"bans" db:"bans"` // failures caused by domain-scoped ban rules
+	SuccessRate   float64    `json:"success_rate" db:"-"`
+	FailCount     int        `json:"fail_count" db:"fail_count"` // consecutive failures
+	Cooldowns     int        `json:"cooldowns" db:"cooldowns"`   // consecutive cooldowns, drives the backoff
+	CooldownUntil *time.Time `json:"cooldown_until,omitempty" db:"cooldown_until"`
+	LastUsedAt    time.Time  `json:"last_used_at" db:"last_used_at"`
+}
@@ -0,0 +1,21 @@
+package models
+
+import "time"
+
+// ExitIP is one address a proxy was seen egressing from, between the first
+// and the last health check that observed it. A proxy's history holds one
+// entry per change of address.
+type ExitIP struct {
+	ProxyID     int       `json:"proxy_id" db:"proxy_id"`
+	IP          string    `json:"ip" db:"ip"`
+	FirstSeenAt time.Time `json:"first_seen_at" db:"first_seen_at"`
+	LastSeenAt  time.Time `json:"last_seen_at" db:"last_seen_at"`
+}
+
+// SharedExitIP is an exit IP observed on more than one proxy.
+type SharedExitIP struct {
+	IP          string    `json:"ip"`
+	ProxyIDs    []int     `json:"proxy_ids"`
+	FirstSeenAt time.Time `json:"first_seen_at"`
+	LastSeenAt  time.Time `json:"last_seen_at"`
+}
@@ -0,0 +1,53 @@
+package models
+
+import "time"
+
+// Health job states
+const (
+	HealthJobRunning   = "running"
+	HealthJobCompleted = "completed"
+	HealthJobCancelled = "cancelled"
+	HealthJobFailed    = "failed"
+)
+
+// Health job triggers
+const (
+	HealthJobManual    = "manual"
+	HealthJobScheduled = "scheduled"
+)
+
+// HealthJob is one run of the health checker over a set of proxies
+type HealthJob struct {
+	ID         string              `json:"id"`
+	Status     string              `json:"status"`  // running, completed, cancelled, failed
+	Trigger    string              `json:"trigger"` // manual, scheduled
+	URL        string              `json:"url"`
+	Total      int                 `json:"total"`
+	Checked    int                 `json:"checked"`
+	Passed     int                 `json:"passed"`
+	Failed     int                 `json:"failed"`
+	Error      string              `json:"error,omitempty"`
+	StartedAt  time.Time           `json:"started_at"`
+	FinishedAt *time.Time          `json:"finished_at,omitempty"`
+	Results    []HealthCheckResult `json:"results,omitempty"`
+}
+
+// Done reports whether the job has stopped running
+func (j *HealthJob) Done() bool {
+	return j.Status != HealthJobRunning
+}
+
+// HealthCheckResult is the outcome of checking one proxy
+type HealthCheckResult struct {
+	ProxyID      int       `json:"proxy_id"`
+	Host         string    `json:"host"`
+	Port         int       `json:"port"`
+	Success      bool      `json:"success"`
+	ResponseTime int       `json:"response_time"` // in milliseconds
+	Error        string    `json:"error,omitempty"`
+	ErrorClass   string    `json:"error_class,omitempty"` // timeout, dns, connection, tls, status, other
+	StatusCode   int       `json:"status_code,omitempty"` // of the test URL's response
+	Anonymity    string    `json:"anonymity,omitempty"`   // set when the proxy was classified by the judge
+	ExitIP       string    `json:"exit_ip,omitempty"`     // address the check was seen coming from
+	CheckedAt    time.Time `json:"checked_at"`
+}
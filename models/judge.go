@@ -0,0 +1,9 @@
+package models
+
+// JudgeReport is what an anonymity judge saw of a request: the address it
+// came from and the headers it carried.
+type JudgeReport struct {
+	RemoteAddr string            `json:"remote_addr"`
+	Method     string            `json:"method"`
+	Headers    map[string]string `json:"headers"`
+}
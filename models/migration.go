@@ -0,0 +1,40 @@
+package models
+
+import "time"
+
+// Migration is one versioned change of the database schema.
+type Migration struct {
+	Version   int        `json:"version"`
+	Name      string     `json:"name"`
+	Applied   bool       `json:"applied"`
+	AppliedAt *time.Time `json:"applied_at,omitempty"`
+	// Unknown migrations were applied by a newer version of the rotator.
+	Unknown bool `json:"unknown,omitempty"`
+}
+
+// MigrationStatus summarizes the schema of a database.
+type MigrationStatus struct {
+	// Version is the highest applied migration, 0 for an empty database.
+	Version int `json:"version"`
+	// Latest is the highest migration this version of the rotator knows.
+	Latest     int         `json:"latest"`
+	Pending    int         `json:"pending"`
+	Migrations []Migration `json:"migrations"`
+}
+
+// NewMigrationStatus summarizes migrations, as returned by the database.
+func NewMigrationStatus(migrations []Migration) MigrationStatus {
+	status := MigrationStatus{Migrations: migrations}
+	for _, m := range migrations {
+		if m.Applied && m.Version > status.Version {
+			status.Version = m.Version
+		}
+		if !m.Unknown && m.Version > status.Latest {
+			status.Latest = m.Version
+		}
+		if !m.Applied {
+			status.Pending++
+		}
+	}
+	return status
+}
@@ -2,23 +2,58 @@ package models
 
 import (
 	"fmt"
+	"strings"
 	"time"
 )
 
+// Proxy health states
+const (
+	// ProxyStateActive proxies are eligible for selection.
+	ProxyStateActive = "active"
+	// ProxyStateCooldown proxies failed too often and wait for their next
+	// probe; every failed probe doubles the wait.
+	ProxyStateCooldown = "cooldown"
+	// ProxyStateRecovering proxies passed a probe and are reactivated after
+	// enough consecutive successes.
+	ProxyStateRecovering = "recovering"
+)
+
 // Proxy represents a proxy server with its credentials and health status
 type Proxy struct {
-	ID           int       `json:"id" db:"id"`
-	Host         string    `json:"host" db:"host"`
-	Port         int       `json:"port" db:"port"`
-	Username     string    `json:"username" db:"username"`
-	Password     string    `json:"password" db:"password"`
-	Protocol     string    `json:"protocol" db:"protocol"` // http, https, socks5
-	IsActive     bool      `json:"is_active" db:"is_active"`
-	LastChecked  time.Time `json:"last_checked" db:"last_checked"`
-	ResponseTime int       `json:"response_time" db:"response_time"` // in milliseconds
-	FailCount    int       `json:"fail_count" db:"fail_count"`
-	CreatedAt    time.Time `json:"created_at" db:"created_at"`
-	UpdatedAt    time.Time `json:"updated_at" db:"updated_at"`
+	ID           int        `json:"id" db:"id"`
+	Host         string     `json:"host" db:"host"`
+	Port         int        `json:"port" db:"port"`
+	Username     string     `json:"username" db:"username"`
+	Password     string     `json:"password" db:"password"`
+	Protocol     string     `json:"protocol" db:"protocol"` // http, https, socks4, socks4a, socks5, socks5h
+	IsActive     bool       `json:"is_active" db:"is_active"`
+	LastChecked  time.Time  `json:"last_checked" db:"last_checked"`
+	ResponseTime int        `json:"response_time" db:"response_time"` // in milliseconds
+	FailCount    int        `json:"fail_count" db:"fail_count"`
+	State        string     `json:"state" db:"state"` // active, cooldown, recovering
+	NextProbeAt  *time.Time `json:"next_probe_at,omitempty" db:"next_probe_at"`
+	Cooldowns    int        `json:"cooldowns" db:"cooldowns"`         // consecutive cooldowns, drives the backoff
+	SuccessCount int        `json:"success_count" db:"success_count"` // consecutive successful probes while recovering
+	Tags         []string   `json:"tags" db:"tags"`                   // free-form labels, lower case
+	Country      string     `json:"country" db:"country"`             // ISO 3166-1 alpha-2, upper case
+	Anonymity    string     `json:"anonymity" db:"anonymity"`         // transparent, anonymous, elite; empty until classified
+	ExitIP       string     `json:"exit_ip" db:"exit_ip"`             // address targets last saw, which may differ from Host
+	ExitIPSeenAt *time.Time `json:"exit_ip_seen_at,omitempty" db:"exit_ip_seen_at"`
+	CreatedAt    time.Time  `json:"created_at" db:"created_at"`
+	UpdatedAt    time.Time  `json:"updated_at" db:"updated_at"`
+}
+
+// MaskedPassword replaces passwords in API responses.
+const MaskedPassword = "********"
+
+// Masked returns a copy of the proxy with its password, if any, replaced
+// by MaskedPassword.
+func (p *Proxy) Masked() *Proxy {
+	masked := *p
+	if masked.Password != "" {
+		masked.Password = MaskedPassword
+	}
+	return &masked
 }
 
 // GetURL returns the full proxy URL
@@ -26,6 +61,85 @@ func (p *Proxy) GetURL() string {
 	return fmt.Sprintf("%s://%s:%d", p.Protocol, p.Host, p.Port)
 }
 
+// IsSupportedProtocol reports whether protocol is one the forwarder can
+// dial through.
+func IsSupportedProtocol(protocol string) bool {
+	switch protocol {
+	case "http", "https", "socks4", "socks4a", "socks5", "socks5h":
+		return true
+	}
+	return false
+}
+
+// Anonymity levels, from least to most anonymous
+const (
+	// AnonymityTransparent proxies pass the client's real IP on to the
+	// target.
+	AnonymityTransparent = "transparent"
+	// AnonymityAnonymous proxies hide the client's IP but reveal that a
+	// proxy is in use, e.g. with a Via header.
+	AnonymityAnonymous = "anonymous"
+	// AnonymityElite proxies look like a direct connection.
+	AnonymityElite = "elite"
+)
+
+// anonymityRank orders the anonymity levels; unclassified proxies rank
+// lowest.
+var anonymityRank = map[string]int{
+	AnonymityTransparent: 1,
+	AnonymityAnonymous:   2,
+	AnonymityElite:       3,
+}
+
+// IsAnonymityLevel reports whether level is one of the anonymity levels.
+func IsAnonymityLevel(level string) bool {
+	return anonymityRank[level] > 0
+}
+
+// AnonymityAtLeast reports whether the proxy is classified and at least as
+// anonymous as level.
+func (p *Proxy) AnonymityAtLeast(level string) bool {
+	return anonymityRank[p.Anonymity] > 0 && anonymityRank[p.Anonymity] >= anonymityRank[level]
+}
+
+// IsProxyState reports whether state is one of the proxy health states.
+func IsProxyState(state string) bool {
+	switch state {
+	case ProxyStateActive, ProxyStateCooldown, ProxyStateRecovering:
+		return true
+	}
+	return false
+}
+
+// HasTag reports whether the proxy is labelled with tag
+func (p *Proxy) HasTag(tag string) bool {
+	for _, t := range p.Tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// NormalizeTags lower-cases and trims tags, dropping empty entries and
+// duplicates. Commas are not allowed inside a tag, since tags are stored
+// as a comma-separated list.
+func NormalizeTags(tags []string) []string {
+	var normalized []string
+	seen := make(map[string]bool)
+	for _, tag := range tags {
+		for _, t := range strings.Split(tag, ",") {
+			t = strings.ToLower(strings.TrimSpace(t))
+			if t == "" || seen[t] {
+				continue
+			}
+			seen[t] = true
+			normalized = append(normalized, t)
+		}
+	}
+	return normalized
+}
+
 // IsHealthy returns true if the proxy is considered healthy
 func (p *Proxy) IsHealthy() bool {
 	return p.IsActive && p.FailCount < 5 && p.ResponseTime < 10000
@@ -37,4 +151,8 @@ type ProxyStats struct {
 	ActiveProxies  int `json:"active_proxies"`
 	HealthyProxies int `json:"healthy_proxies"`
 	FailedProxies  int `json:"failed_proxies"`
+	// Of the failed proxies, those waiting out a cooldown and those
+	// passing probes on their way back
+	CooldownProxies   int `json:"cooldown_proxies"`
+	RecoveringProxies int `json:"recovering_proxies"`
 }
@@ -0,0 +1,63 @@
+package models
+
+import "time"
+
+// Health check error classes
+const (
+	CheckErrorTimeout    = "timeout"
+	CheckErrorDNS        = "dns"
+	CheckErrorConnection = "connection" // refused, reset or rejected by the proxy
+	CheckErrorTLS        = "tls"
+	CheckErrorStatus     = "status" // the test URL answered with a non-2xx status
+	CheckErrorOther      = "other"
+)
+
+// ProxyCheck is one health check of a proxy, kept in its check history.
+type ProxyCheck struct {
+	ProxyID    int       `json:"proxy_id" db:"proxy_id"`
+	CheckedAt  time.Time `json:"checked_at" db:"checked_at"`
+	Latency    int       `json:"latency" db:"latency"` // in milliseconds, 0 when the check failed
+	Success    bool      `json:"success" db:"success"`
+	ErrorClass string    `json:"error_class,omitempty" db:"error_class"`
+	StatusCode int       `json:"status_code,omitempty" db:"status_code"`
+	ExitIP     string    `json:"exit_ip,omitempty" db:"exit_ip"`
+}
+
+// CheckRollup summarises the checks of a proxy during one hour. Rollups
+// outlive the raw checks they were computed from.
+type CheckRollup struct {
+	ProxyID   int       `json:"proxy_id" db:"proxy_id"`
+	Hour      time.Time `json:"hour" db:"hour"`
+	Checks    int       `json:"checks" db:"checks"`
+	Successes int       `json:"successes" db:"successes"`
+	P50       int       `json:"p50" db:"latency_p50"`
+	P95       int       `json:"p95" db:"latency_p95"`
+	P99       int       `json:"p99" db:"latency_p99"`
+}
+
+// LatencyStats are the latency percentiles, in milliseconds, of the
+// successful checks of a proxy over a time range, overall and per bucket.
+type LatencyStats struct {
+	ProxyID     int             `json:"proxy_id"`
+	From        time.Time       `json:"from"`
+	To          time.Time       `json:"to"`
+	Bucket      string          `json:"bucket"`
+	Checks      int             `json:"checks"`
+	Successes   int             `json:"successes"`
+	SuccessRate float64         `json:"success_rate"`
+	P50         int             `json:"p50"`
+	P95         int             `json:"p95"`
+	P99         int             `json:"p99"`
+	Approximate bool            `json:"approximate"` // part of the range is only covered by hourly rollups
+	Series      []LatencyBucket `json:"series"`
+}
+
+// LatencyBucket is one point of a latency time series.
+type LatencyBucket struct {
+	Start     time.Time `json:"start"`
+	Checks    int       `json:"checks"`
+	Successes int       `json:"successes"`
+	P50       int       `json:"p50"`
+	P95       int       `json:"p95"`
+	P99       int       `json:"p99"`
+}
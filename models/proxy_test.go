@@ -0,0 +1,29 @@
+package models
+
+import "testing"
+
+func TestProxyMasked(t *testing.T) {
+	tests := []struct {
+		name     string
+		password string
+		want     string
+	}{
+		{name: "password", password: "secret", want: MaskedPassword},
+		{name: "no password", password: "", want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			proxy := &Proxy{ID: 1, Host: "10.0.0.1", Port: 8080, Username: "user", Password: tt.password}
+			masked := proxy.Masked()
+			if masked.Password != tt.want {
+				t.Errorf("Masked().Password = %q, want %q", masked.Password, tt.want)
+			}
+			if masked.Username != "user" || masked.Host != "10.0.0.1" || masked.Port != 8080 {
+				t.Errorf("Masked() changed more than the password: %+v", masked)
+			}
+			if proxy.Password != tt.password {
+				t.Errorf("Masked() changed the original password to %q", proxy.Password)
+			}
+		})
+	}
+}
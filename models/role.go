@@ -0,0 +1,78 @@
+package models
+
+import "sort"
+
+// Roles of API keys, each allowed everything the one before it is.
+const (
+	// RoleViewer can read proxies, statistics and history.
+	RoleViewer = "viewer"
+	// RoleOperator can also run health checks and reset breakers, domain
+	// health and sessions.
+	RoleOperator = "operator"
+	// RoleAdmin can also change and delete proxies and ban rules, manage
+	// API keys and reveal credentials.
+	RoleAdmin = "admin"
+)
+
+// Permissions checked by the management API.
+const (
+	PermissionProxiesRead       = "proxies:read"
+	PermissionProxiesReset      = "proxies:reset"
+	PermissionProxiesWrite      = "proxies:write"
+	PermissionProxiesDelete     = "proxies:delete"
+	PermissionHealthRun         = "health:run"
+	PermissionSessionsRevoke    = "sessions:revoke"
+	PermissionBanRulesWrite     = "ban-rules:write"
+	PermissionKeysManage        = "keys:manage"
+	PermissionCredentialsReveal = "credentials:reveal"
+)
+
+// Roles lists the roles from least to most privileged.
+var Roles = []string{RoleViewer, RoleOperator, RoleAdmin}
+
+// rolePermissions holds what each role adds to the one before it.
+var rolePermissions = map[string][]string{
+	RoleViewer: {PermissionProxiesRead},
+	RoleOperator: {
+		PermissionHealthRun,
+		PermissionProxiesReset,
+		PermissionSessionsRevoke,
+	},
+	RoleAdmin: {
+		PermissionProxiesWrite,
+		PermissionProxiesDelete,
+		PermissionBanRulesWrite,
+		PermissionKeysManage,
+		PermissionCredentialsReveal,
+	},
+}
+
+// ValidRole reports whether role is one of Roles.
+func ValidRole(role string) bool {
+	_, ok := rolePermissions[role]
+	return ok
+}
+
+// RolePermissions returns every permission of role, sorted; none for an
+// unknown role.
+func RolePermissions(role string) []string {
+	permissions := []string{}
+	for _, r := range Roles {
+		permissions = append(permissions, rolePermissions[r]...)
+		if r == role {
+			sort.Strings(permissions)
+			return permissions
+		}
+	}
+	return []string{}
+}
+
+// RoleAllows reports whether role has permission.
+func RoleAllows(role, permission string) bool {
+	for _, p := range RolePermissions(role) {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,60 @@
+package models
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestRolePermissions(t *testing.T) {
+	all := []string{
+		PermissionProxiesRead,
+		PermissionProxiesReset,
+		PermissionProxiesWrite,
+		PermissionProxiesDelete,
+		PermissionHealthRun,
+		PermissionSessionsRevoke,
+		PermissionBanRulesWrite,
+		PermissionKeysManage,
+		PermissionCredentialsReveal,
+	}
+	tests := []struct {
+		role  string
+		valid bool
+		want  []string
+	}{
+		{role: RoleViewer, valid: true, want: []string{PermissionProxiesRead}},
+		{role: RoleOperator, valid: true, want: []string{
+			PermissionProxiesRead,
+			PermissionProxiesReset,
+			PermissionHealthRun,
+			PermissionSessionsRevoke,
+		}},
+		{role: RoleAdmin, valid: true, want: all},
+		{role: "root", want: nil},
+		{role: "", want: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.role, func(t *testing.T) {
+			if got := ValidRole(tt.role); got != tt.valid {
+				t.Errorf("ValidRole(%q) = %v, want %v", tt.role, got, tt.valid)
+			}
+
+			want := append([]string{}, tt.want...)
+			sort.Strings(want)
+			if got := RolePermissions(tt.role); !reflect.DeepEqual(got, want) {
+				t.Errorf("RolePermissions(%q) = %v, want %v", tt.role, got, want)
+			}
+
+			allowed := make(map[string]bool)
+			for _, p := range tt.want {
+				allowed[p] = true
+			}
+			for _, p := range all {
+				if got := RoleAllows(tt.role, p); got != allowed[p] {
+					t.Errorf("RoleAllows(%q, %q) = %v, want %v", tt.role, p, got, allowed[p])
+				}
+			}
+		})
+	}
+}
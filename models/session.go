@@ -0,0 +1,14 @@
+package models
+
+import "time"
+
+// Session pins a client-supplied session ID to one proxy, so that
+// consecutive requests in the session leave through the same exit IP.
+type Session struct {
+	ID        string    `json:"id"`
+	ProxyID   int       `json:"proxy_id"`
+	Requests  int64     `json:"requests"`
+	CreatedAt time.Time `json:"created_at"`
+	LastUsed  time.Time `json:"last_used"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
@@ -0,0 +1,190 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/textproto"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"go-proxy-rotator/models"
+)
+
+// judgeBodyLimit caps how much of a judge response is read.
+const judgeBodyLimit = 64 * 1024
+
+// judgeOwnIPTTL is how long the rotator's own address, as seen by the
+// judge, is reused before it is looked up again.
+const judgeOwnIPTTL = 10 * time.Minute
+
+// revealingHeaders are request headers proxies add that give away that a
+// proxy is in use, and often the address of the client behind it.
+var revealingHeaders = []string{
+	"Via", "X-Forwarded-For", "Forwarded", "Forwarded-For", "X-Forwarded",
+	"X-Real-Ip", "Client-Ip", "X-Client-Ip", "True-Client-Ip",
+	"X-Cluster-Client-Ip", "X-Originating-Ip", "X-Proxy-Id", "Proxy-Connection",
+}
+
+// anonymityJudge classifies proxies by what a judge, an endpoint that
+// echoes the requests it receives, sees when it is called through them.
+type anonymityJudge struct {
+	url    string
+	direct *http.Client
+
+	mu      sync.Mutex
+	ownIP   string
+	ownIPAt time.Time
+}
+
+func newAnonymityJudge(url string, timeout time.Duration) *anonymityJudge {
+	return &anonymityJudge{url: url, direct: &http.Client{Timeout: timeout}}
+}
+
+// classify calls the judge through client and returns the anonymity level
+// of the proxy behind it and the address the judge saw the call come from.
+func (j *anonymityJudge) classify(ctx context.Context, client *http.Client) (level, exitIP string, err error) {
+	ownIP, err := j.realIP(ctx)
+	if err != nil {
+		return "", "", err
+	}
+	report, err := j.fetch(ctx, client)
+	if err != nil {
+		return "", "", err
+	}
+	if ip := hostIP(report.RemoteAddr); ip != nil {
+		exitIP = ip.String()
+	}
+	return classifyAnonymity(report, ownIP), exitIP, nil
+}
+
+// realIP returns the rotator's own address as the judge sees it when it is
+// called without a proxy.
+func (j *anonymityJudge) realIP(ctx context.Context) (string, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.ownIP != "" && time.Since(j.ownIPAt) < judgeOwnIPTTL {
+		return j.ownIP, nil
+	}
+	report, err := j.fetch(ctx, j.direct)
+	if err != nil {
+		return "", fmt.Errorf("failed to look up own address: %w", err)
+	}
+	ip := hostIP(report.RemoteAddr)
+	if ip == nil {
+		return "", errors.New("failed to look up own address: judge reported no remote address")
+	}
+	j.ownIP = ip.String()
+	j.ownIPAt = time.Now()
+	return j.ownIP, nil
+}
+
+func (j *anonymityJudge) fetch(ctx context.Context, client *http.Client) (models.JudgeReport, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, j.url, nil)
+	if err != nil {
+		return models.JudgeReport{}, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return models.JudgeReport{}, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, judgeBodyLimit))
+	if err != nil {
+		return models.JudgeReport{}, fmt.Errorf("failed to read judge response: %w", err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return models.JudgeReport{}, fmt.Errorf("judge answered with status %s", resp.Status)
+	}
+	return parseJudgeReport(body)
+}
+
+var htmlTag = regexp.MustCompile(`<[^>]*>`)
+
+// parseJudgeReport reads the JSON report of the built-in judge, or the
+// CGI-style listing printed by common external judges such as azenv.php
+// ("REMOTE_ADDR = 203.0.113.7", "HTTP_VIA = 1.1 proxy").
+func parseJudgeReport(body []byte) (models.JudgeReport, error) {
+	var report models.JudgeReport
+	if err := json.Unmarshal(body, &report); err == nil && report.RemoteAddr != "" {
+		return report, nil
+	}
+
+	report = models.JudgeReport{Headers: make(map[string]string)}
+	for _, line := range strings.Split(htmlTag.ReplaceAllString(string(body), ""), "\n") {
+		key, value, ok := strings.Cut(line, "=")
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+		if !ok || key == "" || strings.ContainsAny(key, " \t") {
+			continue
+		}
+		switch {
+		case key == "REMOTE_ADDR":
+			report.RemoteAddr = value
+		case key == "REQUEST_METHOD":
+			report.Method = value
+		case strings.HasPrefix(key, "HTTP_"):
+			report.Headers[strings.ReplaceAll(key[len("HTTP_"):], "_", "-")] = value
+		}
+	}
+	if report.RemoteAddr == "" {
+		return models.JudgeReport{}, errors.New("unrecognised judge response")
+	}
+	return report, nil
+}
+
+// classifyAnonymity rates a proxy by the judge's report of a request made
+// through it. A proxy that lets ownIP through anywhere is transparent; one
+// that hides it but adds proxy headers is anonymous; anything else is
+// elite.
+func classifyAnonymity(report models.JudgeReport, ownIP string) string {
+	own := net.ParseIP(ownIP)
+	if ip := hostIP(report.RemoteAddr); ip != nil && ip.Equal(own) {
+		return models.AnonymityTransparent
+	}
+
+	headers := make(map[string]string, len(report.Headers))
+	for name, value := range report.Headers {
+		name = textproto.CanonicalMIMEHeaderKey(name)
+		// Host names the judge, which may be the rotator's own address
+		if name != "Host" && mentionsIP(value, own) {
+			return models.AnonymityTransparent
+		}
+		headers[name] = value
+	}
+	for _, name := range revealingHeaders {
+		if _, ok := headers[name]; ok {
+			return models.AnonymityAnonymous
+		}
+	}
+	return models.AnonymityElite
+}
+
+// mentionsIP reports whether ip appears in a header value such as
+// "203.0.113.7, 10.0.0.1" or "for=\"[2001:db8::1]:4711\"".
+func mentionsIP(value string, ip net.IP) bool {
+	fields := strings.FieldsFunc(value, func(r rune) bool {
+		return r == ',' || r == ';' || r == '=' || r == ' ' || r == '"'
+	})
+	for _, field := range fields {
+		if candidate := hostIP(field); candidate != nil && candidate.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// hostIP parses an address with or without a port.
+func hostIP(addr string) net.IP {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		addr = host
+	}
+	return net.ParseIP(strings.Trim(addr, "[]"))
+}
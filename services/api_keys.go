@@ -0,0 +1,146 @@
+package services
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"go-proxy-rotator/database"
+	"go-proxy-rotator/models"
+)
+
+// apiKeyPrefix starts every generated API key, so leaked keys are easy to
+// recognise.
+const apiKeyPrefix = "gpr_"
+
+// touchInterval is how often the last use of an API key is written back.
+const touchInterval = time.Minute
+
+// BootstrapKeyName names the key given by ADMIN_API_KEY, which lives in
+// the configuration instead of the database, cannot be revoked and has the
+// admin role.
+const BootstrapKeyName = "bootstrap"
+
+// APIKeys authenticates callers of the management API. Keys are stored
+// hashed, so a lookup per request is enough to notice keys revoked by
+// other instances sharing the database.
+type APIKeys struct {
+	db database.Store
+	// bootstrap is the hash of the ADMIN_API_KEY key, empty without one.
+	bootstrap string
+
+	mu      sync.Mutex
+	touched map[int]time.Time
+}
+
+// NewAPIKeys creates the API key service. bootstrap, when set, is a key
+// that always authenticates.
+func NewAPIKeys(db database.Store, bootstrap string) *APIKeys {
+	a := &APIKeys{db: db, touched: make(map[int]time.Time)}
+	if bootstrap != "" {
+		a.bootstrap = hashAPIKey(bootstrap)
+	}
+	return a
+}
+
+// Create generates a key named name with role and returns it, which is the
+// only time it is available, together with its stored record.
+func (a *APIKeys) Create(name, role string) (string, *models.APIKey, error) {
+	if !models.ValidRole(role) {
+		return "", nil, fmt.Errorf("invalid role %q: want one of %s", role, strings.Join(models.Roles, ", "))
+	}
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", nil, fmt.Errorf("failed to generate API key: %w", err)
+	}
+	token := apiKeyPrefix + base64.RawURLEncoding.EncodeToString(secret)
+
+	key := &models.APIKey{
+		Name:   name,
+		Prefix: token[:len(apiKeyPrefix)+6],
+		Hash:   hashAPIKey(token),
+		Role:   role,
+	}
+	if err := a.db.AddAPIKey(key); err != nil {
+		return "", nil, err
+	}
+	return token, key, nil
+}
+
+// List returns every stored key, including revoked ones.
+func (a *APIKeys) List() ([]*models.APIKey, error) {
+	return a.db.ListAPIKeys()
+}
+
+// Revoke stops a stored key from authenticating.
+func (a *APIKeys) Revoke(id int) error {
+	return a.db.RevokeAPIKey(id, time.Now())
+}
+
+// Configured reports whether any key can authenticate: the bootstrap key or
+// a stored one that is not revoked.
+func (a *APIKeys) Configured() (bool, error) {
+	if a.bootstrap != "" {
+		return true, nil
+	}
+	keys, err := a.db.ListAPIKeys()
+	if err != nil {
+		return false, err
+	}
+	for _, key := range keys {
+		if !key.Revoked() {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Authenticate returns the key token belongs to, or nil if it is not a
+// valid key.
+func (a *APIKeys) Authenticate(token string) (*models.APIKey, error) {
+	if token == "" {
+		return nil, nil
+	}
+	hash := hashAPIKey(token)
+	if a.bootstrap != "" && subtle.ConstantTimeCompare([]byte(hash), []byte(a.bootstrap)) == 1 {
+		return &models.APIKey{Name: BootstrapKeyName, Role: models.RoleAdmin}, nil
+	}
+	if !strings.HasPrefix(token, apiKeyPrefix) {
+		return nil, nil
+	}
+
+	key, err := a.db.GetAPIKeyByHash(hash)
+	if err != nil || key == nil || key.Revoked() {
+		return nil, err
+	}
+	a.touch(key)
+	return key, nil
+}
+
+// touch records the use of key, at most once per touchInterval.
+func (a *APIKeys) touch(key *models.APIKey) {
+	now := time.Now()
+	a.mu.Lock()
+	if now.Sub(a.touched[key.ID]) < touchInterval {
+		a.mu.Unlock()
+		return
+	}
+	a.touched[key.ID] = now
+	a.mu.Unlock()
+
+	if err := a.db.TouchAPIKey(key.ID, now); err != nil {
+		log.Printf("Warning: %v", err)
+	}
+}
+
+func hashAPIKey(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
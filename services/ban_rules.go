@@ -0,0 +1,475 @@
+package services
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go-proxy-rotator/database"
+	"go-proxy-rotator/models"
+)
+
+// ErrInvalidBanRule is returned for ban rules that cannot be evaluated.
+var ErrInvalidBanRule = errors.New("invalid ban rule")
+
+// BanRules evaluates forwarded responses against the configured ban rules.
+// Rules are kept in memory, compiled, and written through to the database
+// on every change.
+type BanRules struct {
+	db   database.Store
+	opts BanRulesOptions
+
+	mu    sync.RWMutex
+	rules []*banRule // in ID order; replaced, never modified, on change
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// BanRulesOptions configures BanRules.
+type BanRulesOptions struct {
+	// BodyLimit is how many bytes at the start of a response body are
+	// matched against body patterns, and BodyWait how long to wait for
+	// them; zero waits for all of them.
+	BodyLimit int64
+	BodyWait  time.Duration
+	// SyncInterval is how often rules are reloaded from the database to
+	// pick up changes made by other instances; zero disables syncing.
+	SyncInterval time.Duration
+}
+
+type banRule struct {
+	rule   models.BanRule
+	status map[int]bool
+	header *regexp.Regexp
+	body   *regexp.Regexp
+	hits   *atomic.Int64 // shared with the rule's previous versions
+}
+
+// NewBanRules loads the ban rules from db and starts the background sync.
+func NewBanRules(db database.Store, opts BanRulesOptions) (*BanRules, error) {
+	stored, err := db.GetBanRules()
+	if err != nil {
+		return nil, err
+	}
+
+	b := &BanRules{
+		db:   db,
+		opts: opts,
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	for _, rule := range stored {
+		compiled, err := compileBanRule(*rule, new(atomic.Int64))
+		if err != nil {
+			return nil, fmt.Errorf("failed to load ban rule %d: %w", rule.ID, err)
+		}
+		b.rules = append(b.rules, compiled)
+	}
+	go b.syncLoop()
+	return b, nil
+}
+
+// Close stops the background sync.
+func (b *BanRules) Close() {
+	close(b.stop)
+	<-b.done
+}
+
+// Sync reloads the ban rules from the database, taking over rules other
+// instances added, changed or deleted. Hit counts stay this instance's
+// own.
+func (b *BanRules) Sync() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	stored, err := b.db.GetBanRules()
+	if err != nil {
+		return err
+	}
+
+	current := make(map[int]*banRule, len(b.rules))
+	for _, r := range b.rules {
+		current[r.rule.ID] = r
+	}
+	rules := make([]*banRule, 0, len(stored))
+	for _, rule := range stored {
+		r, ok := current[rule.ID]
+		if ok && r.rule.UpdatedAt.Equal(rule.UpdatedAt) {
+			rules = append(rules, r)
+			continue
+		}
+		hits := new(atomic.Int64)
+		if ok {
+			hits = r.hits
+		}
+		compiled, err := compileBanRule(*rule, hits)
+		if err != nil {
+			return fmt.Errorf("failed to load ban rule %d: %w", rule.ID, err)
+		}
+		rules = append(rules, compiled)
+	}
+	b.rules = rules
+	return nil
+}
+
+func (b *BanRules) syncLoop() {
+	defer close(b.done)
+
+	if b.opts.SyncInterval <= 0 {
+		<-b.stop
+		return
+	}
+	ticker := time.NewTicker(b.opts.SyncInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := b.Sync(); err != nil {
+				log.Printf("Failed to sync ban rules: %v", err)
+			}
+		case <-b.stop:
+			return
+		}
+	}
+}
+
+// List returns every ban rule in the order it was created.
+func (b *BanRules) List() []models.BanRule {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	rules := make([]models.BanRule, len(b.rules))
+	for i, r := range b.rules {
+		rules[i] = r.snapshot()
+	}
+	return rules
+}
+
+// Get returns the ban rule with the given ID.
+func (b *BanRules) Get(id int) (models.BanRule, bool) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	if i := b.index(id); i >= 0 {
+		return b.rules[i].snapshot(), true
+	}
+	return models.BanRule{}, false
+}
+
+// Add validates and stores a new ban rule.
+func (b *BanRules) Add(rule models.BanRule) (models.BanRule, error) {
+	compiled, err := compileBanRule(rule, new(atomic.Int64))
+	if err != nil {
+		return models.BanRule{}, err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if err := b.db.AddBanRule(&compiled.rule); err != nil {
+		return models.BanRule{}, err
+	}
+	rules := make([]*banRule, len(b.rules), len(b.rules)+1)
+	copy(rules, b.rules)
+	b.rules = append(rules, compiled)
+	return compiled.snapshot(), nil
+}
+
+// Update replaces the ban rule with the given ID, keeping its hit count.
+func (b *BanRules) Update(id int, rule models.BanRule) (models.BanRule, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	i := b.index(id)
+	if i < 0 {
+		return models.BanRule{}, fmt.Errorf("ban rule with id %d not found", id)
+	}
+	current := b.rules[i]
+	rule.ID = id
+	rule.CreatedAt = current.rule.CreatedAt
+	rule.UpdatedAt = time.Now()
+	compiled, err := compileBanRule(rule, current.hits)
+	if err != nil {
+		return models.BanRule{}, err
+	}
+
+	if err := b.db.UpdateBanRule(&compiled.rule); err != nil {
+		return models.BanRule{}, err
+	}
+	rules := make([]*banRule, len(b.rules))
+	copy(rules, b.rules)
+	rules[i] = compiled
+	b.rules = rules
+	return compiled.snapshot(), nil
+}
+
+// Remove deletes the ban rule with the given ID.
+func (b *BanRules) Remove(id int) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	i := b.index(id)
+	if i < 0 {
+		return fmt.Errorf("ban rule with id %d not found", id)
+	}
+	if err := b.db.DeleteBanRule(id); err != nil {
+		return err
+	}
+	rules := make([]*banRule, 0, len(b.rules)-1)
+	rules = append(rules, b.rules[:i]...)
+	b.rules = append(rules, b.rules[i+1:]...)
+	return nil
+}
+
+// index returns the position of rule id in b.rules, or -1.
+func (b *BanRules) index(id int) int {
+	for i, r := range b.rules {
+		if r.rule.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// Match returns the first enabled rule that resp, received for a request
+// to host, matches, or nil. The start of the body is only read when a rule
+// needs it; resp.Body is then replaced so that it still yields the whole
+// body.
+func (b *BanRules) Match(host string, resp *http.Response) *models.BanRule {
+	b.mu.RLock()
+	rules := b.rules
+	b.mu.RUnlock()
+
+	host = strings.ToLower(host)
+	var body []byte
+	peeked := false
+	for _, r := range rules {
+		if !r.rule.Enabled || !r.matchesHead(host, resp) {
+			continue
+		}
+		if r.body != nil {
+			if !peeked {
+				body = b.peekBody(resp)
+				peeked = true
+			}
+			if !r.body.Match(body) {
+				continue
+			}
+		}
+		r.hits.Add(1)
+		rule := r.snapshot()
+		return &rule
+	}
+	return nil
+}
+
+// peekBody returns up to bodyLimit bytes of the body, decoded if it is
+// gzip- or deflate-encoded: those that arrive within bodyWait, so that
+// streamed responses are not held back. resp.Body is replaced by one that
+// still yields the whole body.
+func (b *BanRules) peekBody(resp *http.Response) []byte {
+	peek := newBodyPeek(resp.Body, b.opts.BodyLimit)
+	resp.Body = peek
+	raw := peek.wait(b.opts.BodyWait)
+
+	var decoder io.Reader
+	var err error
+	switch strings.ToLower(resp.Header.Get("Content-Encoding")) {
+	case "gzip", "x-gzip":
+		decoder, err = gzip.NewReader(bytes.NewReader(raw))
+	case "deflate":
+		decoder, err = zlib.NewReader(bytes.NewReader(raw))
+	default:
+		return raw
+	}
+	if err != nil {
+		return raw
+	}
+	// The prefix usually ends mid-stream; keep whatever decoded.
+	decoded, _ := io.ReadAll(io.LimitReader(decoder, b.opts.BodyLimit))
+	return decoded
+}
+
+// bodyPeek reads the start of a body in the background, so that it can be
+// inspected without waiting for all of it, while reads of the body get
+// every byte as soon as it arrives.
+type bodyPeek struct {
+	body io.ReadCloser
+	done chan struct{}
+
+	mu       sync.Mutex
+	cond     *sync.Cond
+	buf      []byte
+	off      int   // how much of buf has been read
+	finished bool  // the background read stopped, with err unless at the limit
+	err      error // nil when the limit was reached
+}
+
+func newBodyPeek(body io.ReadCloser, limit int64) *bodyPeek {
+	p := &bodyPeek{body: body, done: make(chan struct{})}
+	p.cond = sync.NewCond(&p.mu)
+	go p.fill(limit)
+	return p
+}
+
+// fill reads up to limit bytes of the body into buf.
+func (p *bodyPeek) fill(limit int64) {
+	defer close(p.done)
+	chunk := make([]byte, 32*1024)
+	for left := limit; ; {
+		var n int
+		var err error
+		if left > 0 {
+			n, err = p.body.Read(chunk[:min(int64(len(chunk)), left)])
+			left -= int64(n)
+		}
+
+		p.mu.Lock()
+		p.buf = append(p.buf, chunk[:n]...)
+		if err != nil || left <= 0 {
+			p.finished = true
+			p.err = err
+		}
+		p.cond.Broadcast()
+		finished := p.finished
+		p.mu.Unlock()
+		if finished {
+			return
+		}
+	}
+}
+
+// wait returns what has been read of the start of the body after d, or
+// once the limit or the end of the body was reached; zero waits for that.
+func (p *bodyPeek) wait(d time.Duration) []byte {
+	if d > 0 {
+		timer := time.NewTimer(d)
+		defer timer.Stop()
+		select {
+		case <-p.done:
+		case <-timer.C:
+		}
+	} else {
+		<-p.done
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]byte(nil), p.buf...)
+}
+
+func (p *bodyPeek) Read(b []byte) (int, error) {
+	p.mu.Lock()
+	for p.off == len(p.buf) && !p.finished {
+		p.cond.Wait()
+	}
+	if p.off < len(p.buf) {
+		n := copy(b, p.buf[p.off:])
+		p.off += n
+		p.mu.Unlock()
+		return n, nil
+	}
+	err := p.err
+	p.mu.Unlock()
+
+	if err != nil {
+		return 0, err
+	}
+	return p.body.Read(b)
+}
+
+func (p *bodyPeek) Close() error {
+	return p.body.Close()
+}
+
+// matchesHead checks every condition of the rule except the body pattern.
+func (r *banRule) matchesHead(host string, resp *http.Response) bool {
+	if r.rule.Domain != "" && host != r.rule.Domain && !strings.HasSuffix(host, "."+r.rule.Domain) {
+		return false
+	}
+	if len(r.status) > 0 && !r.status[resp.StatusCode] {
+		return false
+	}
+	if r.rule.Header != "" {
+		values := resp.Header.Values(r.rule.Header)
+		if len(values) == 0 {
+			return false
+		}
+		if r.header != nil {
+			matched := false
+			for _, value := range values {
+				if r.header.MatchString(value) {
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func (r *banRule) snapshot() models.BanRule {
+	rule := r.rule
+	rule.StatusCodes = append([]int(nil), r.rule.StatusCodes...)
+	rule.Hits = r.hits.Load()
+	return rule
+}
+
+// compileBanRule normalises and validates rule and compiles its patterns.
+func compileBanRule(rule models.BanRule, hits *atomic.Int64) (*banRule, error) {
+	rule.Name = strings.TrimSpace(rule.Name)
+	rule.Domain = strings.TrimPrefix(strings.ToLower(strings.TrimSpace(rule.Domain)), "*.")
+	rule.Header = strings.TrimSpace(rule.Header)
+	if rule.Scope == "" {
+		rule.Scope = models.BanScopeProxy
+	}
+
+	if rule.Name == "" {
+		return nil, fmt.Errorf("%w: name is required", ErrInvalidBanRule)
+	}
+	if rule.Scope != models.BanScopeProxy && rule.Scope != models.BanScopeDomain {
+		return nil, fmt.Errorf("%w: unknown scope %q", ErrInvalidBanRule, rule.Scope)
+	}
+	if rule.HeaderPattern != "" && rule.Header == "" {
+		return nil, fmt.Errorf("%w: header_pattern requires header", ErrInvalidBanRule)
+	}
+	if len(rule.StatusCodes) == 0 && rule.Header == "" && rule.BodyPattern == "" {
+		return nil, fmt.Errorf("%w: at least one of status_codes, header and body_pattern is required", ErrInvalidBanRule)
+	}
+
+	compiled := &banRule{status: make(map[int]bool, len(rule.StatusCodes)), hits: hits}
+	for _, code := range rule.StatusCodes {
+		if code < 100 || code > 599 {
+			return nil, fmt.Errorf("%w: invalid status code %d", ErrInvalidBanRule, code)
+		}
+		compiled.status[code] = true
+	}
+	var err error
+	if rule.HeaderPattern != "" {
+		if compiled.header, err = regexp.Compile(rule.HeaderPattern); err != nil {
+			return nil, fmt.Errorf("%w: header_pattern: %v", ErrInvalidBanRule, err)
+		}
+	}
+	if rule.BodyPattern != "" {
+		if compiled.body, err = regexp.Compile(rule.BodyPattern); err != nil {
+			return nil, fmt.Errorf("%w: body_pattern: %v", ErrInvalidBanRule, err)
+		}
+	}
+	compiled.rule = rule
+	return compiled, nil
+}
@@ -0,0 +1,233 @@
+package services
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"go-proxy-rotator/database"
+	"go-proxy-rotator/models"
+)
+
+// newTestStore opens an empty SQLite store that is removed with the test.
+func newTestStore(t *testing.T) *database.DB {
+	t.Helper()
+	db, err := database.New(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func newTestBanRules(t *testing.T, wait time.Duration, rules ...models.BanRule) *BanRules {
+	t.Helper()
+	b, err := NewBanRules(newTestStore(t), BanRulesOptions{BodyLimit: 64, BodyWait: wait})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(b.Close)
+	for _, rule := range rules {
+		if _, err := b.Add(rule); err != nil {
+			t.Fatalf("Add(%+v): %v", rule, err)
+		}
+	}
+	return b
+}
+
+func gzipped(s string) string {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	w.Write([]byte(s))
+	w.Close()
+	return buf.String()
+}
+
+func TestBanRulesMatch(t *testing.T) {
+	b := newTestBanRules(t, 0,
+		models.BanRule{Name: "disabled", StatusCodes: []int{200}, Enabled: false},
+		models.BanRule{Name: "forbidden", StatusCodes: []int{403}, Enabled: true},
+		models.BanRule{Name: "challenge", Header: "Cf-Mitigated", HeaderPattern: "^challenge$", Enabled: true},
+		models.BanRule{Name: "captcha", StatusCodes: []int{200}, BodyPattern: "(?i)captcha", Domain: "*.example.com", Enabled: true},
+	)
+
+	tests := []struct {
+		name   string
+		host   string
+		status int
+		header http.Header
+		body   string
+		want   string
+	}{
+		{name: "no match", host: "example.com", status: 200, body: "hello"},
+		{name: "status", host: "other.org", status: 403, want: "forbidden"},
+		{name: "header", host: "other.org", status: 200, header: http.Header{"Cf-Mitigated": {"challenge"}}, want: "challenge"},
+		{name: "header pattern mismatch", host: "other.org", status: 200, header: http.Header{"Cf-Mitigated": {"none"}}},
+		{name: "body", host: "example.com", status: 200, body: "please solve the CAPTCHA", want: "captcha"},
+		{name: "subdomain", host: "WWW.Example.com", status: 200, body: "captcha", want: "captcha"},
+		{name: "other domain", host: "example.org", status: 200, body: "captcha"},
+		{name: "past the body limit", host: "example.com", status: 200, body: strings.Repeat(".", 64) + "captcha"},
+		{name: "gzip body", host: "example.com", status: 200, header: http.Header{"Content-Encoding": {"gzip"}},
+			body: gzipped("captcha"), want: "captcha"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := tt.header
+			if header == nil {
+				header = http.Header{}
+			}
+			resp := &http.Response{StatusCode: tt.status, Header: header, Body: io.NopCloser(strings.NewReader(tt.body))}
+
+			got := b.Match(tt.host, resp)
+			if tt.want == "" && got != nil {
+				t.Errorf("Match = %q, want no match", got.Name)
+			}
+			if tt.want != "" && (got == nil || got.Name != tt.want) {
+				t.Errorf("Match = %+v, want %q", got, tt.want)
+			}
+
+			body, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(body) != tt.body {
+				t.Errorf("body after Match = %q, want %q", body, tt.body)
+			}
+		})
+	}
+
+	for _, rule := range b.List() {
+		if rule.Name == "captcha" && rule.Hits != 3 {
+			t.Errorf("captcha hits = %d, want 3", rule.Hits)
+		}
+	}
+}
+
+func TestBanRulesStreamingBody(t *testing.T) {
+	release := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		switch r.URL.Path {
+		case "/stream":
+			// An event, then nothing until the test is done
+			fmt.Fprint(w, "data: first\n\n")
+			w.(http.Flusher).Flush()
+			<-release
+			fmt.Fprint(w, "data: captcha\n\n")
+		case "/slow":
+			// The marker arrives late, but within the wait
+			fmt.Fprint(w, "data: first\n\n")
+			w.(http.Flusher).Flush()
+			time.Sleep(50 * time.Millisecond)
+			fmt.Fprint(w, "data: captcha\n\n")
+		}
+	}))
+	defer upstream.Close()
+	defer close(release)
+
+	tests := []struct {
+		path  string
+		wait  time.Duration
+		match bool
+	}{
+		{path: "/stream", wait: 100 * time.Millisecond},
+		{path: "/slow", wait: 5 * time.Second, match: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			b := newTestBanRules(t, tt.wait, models.BanRule{Name: "captcha", BodyPattern: "captcha", Enabled: true})
+			resp, err := http.Get(upstream.URL + tt.path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+
+			start := time.Now()
+			got := b.Match("127.0.0.1", resp)
+			if elapsed := time.Since(start); elapsed > 2*time.Second {
+				t.Errorf("Match took %v", elapsed)
+			}
+			if (got != nil) != tt.match {
+				t.Errorf("Match = %+v, want a match: %v", got, tt.match)
+			}
+
+			// What arrived is passed on without waiting for the rest
+			first := make([]byte, len("data: first\n\n"))
+			if _, err := io.ReadFull(resp.Body, first); err != nil {
+				t.Fatal(err)
+			}
+			if string(first) != "data: first\n\n" {
+				t.Errorf("first event = %q", first)
+			}
+		})
+	}
+}
+
+func TestBanRulesSync(t *testing.T) {
+	db := newTestStore(t)
+	local, err := NewBanRules(db, BanRulesOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer local.Close()
+	other, err := NewBanRules(db, BanRulesOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+
+	forbidden := func() *http.Response {
+		return &http.Response{StatusCode: 403, Header: http.Header{}, Body: http.NoBody}
+	}
+	match := func() string {
+		if r := local.Match("example.com", forbidden()); r != nil {
+			return r.Name
+		}
+		return ""
+	}
+
+	rule, err := other.Add(models.BanRule{Name: "forbidden", StatusCodes: []int{403}, Enabled: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := match(); got != "" {
+		t.Fatalf("before sync: Match = %q, want no match", got)
+	}
+	if err := local.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	if got := match(); got != "forbidden" {
+		t.Fatalf("after add: Match = %q, want %q", got, "forbidden")
+	}
+
+	rule.Name = "blocked"
+	if _, err := other.Update(rule.ID, rule); err != nil {
+		t.Fatal(err)
+	}
+	if err := local.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	if got := match(); got != "blocked" {
+		t.Fatalf("after update: Match = %q, want %q", got, "blocked")
+	}
+	if got, _ := local.Get(rule.ID); got.Hits != 2 {
+		t.Errorf("hits after update = %d, want 2", got.Hits)
+	}
+
+	if err := other.Remove(rule.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := local.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	if got := match(); got != "" {
+		t.Errorf("after remove: Match = %q, want no match", got)
+	}
+}
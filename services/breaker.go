@@ -0,0 +1,292 @@
+package services
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go-proxy-rotator/models"
+)
+
+// breakerBuckets is how many slices the sliding window is divided into.
+const breakerBuckets = 10
+
+// BreakerOptions configures the per-proxy circuit breakers.
+type BreakerOptions struct {
+	// Window is the sliding window over which the error rate is measured.
+	Window time.Duration
+	// MinRequests is how many requests the window must hold before the
+	// breaker may trip.
+	MinRequests int
+	// ErrorRate is the failure ratio, between 0 and 1, that trips the
+	// breaker.
+	ErrorRate float64
+	// OpenDuration is how long a tripped breaker keeps the proxy out of
+	// rotation before letting a trial request through.
+	OpenDuration time.Duration
+	// HalfOpenSuccesses is how many consecutive trial requests must
+	// succeed to close the breaker again.
+	HalfOpenSuccesses int
+}
+
+// breakerSet holds a circuit breaker per proxy, fed by the outcomes of
+// live requests. A closed breaker lets traffic through and trips open when
+// the error rate over the sliding window reaches the threshold. An open
+// breaker keeps its proxy out of selection for OpenDuration, then turns
+// half-open and lets one trial request through at a time: enough
+// successful trials close it, a failed one opens it again.
+type breakerSet struct {
+	opts BreakerOptions
+
+	mu       sync.Mutex
+	breakers map[int]*breaker
+	tripped  atomic.Int64 // breakers not closed; written under mu
+}
+
+type breaker struct {
+	state     string
+	buckets   [breakerBuckets]breakerBucket
+	openedAt  time.Time
+	trialAt   time.Time // start of the trial request in flight, if any
+	successes int       // consecutive successful trials while half-open
+}
+
+type breakerBucket struct {
+	slot     int64 // which slice of time the counts belong to
+	requests int
+	failures int
+}
+
+func newBreakerSet(opts BreakerOptions) *breakerSet {
+	if opts.HalfOpenSuccesses < 1 {
+		opts.HalfOpenSuccesses = 1
+	}
+	if opts.MinRequests < 1 {
+		opts.MinRequests = 1
+	}
+	return &breakerSet{opts: opts, breakers: make(map[int]*breaker)}
+}
+
+func (s *breakerSet) get(id int) *breaker {
+	b, ok := s.breakers[id]
+	if !ok {
+		b = &breaker{state: models.BreakerClosed}
+		s.breakers[id] = b
+	}
+	return b
+}
+
+// available reports whether a request may be sent through proxy id.
+func (s *breakerSet) available(id int, now time.Time) bool {
+	if s.tripped.Load() == 0 {
+		// Every breaker is closed; skip the lock on the request path.
+		return true
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	b, ok := s.breakers[id]
+	if !ok {
+		return true
+	}
+	s.advance(b, now)
+	switch b.state {
+	case models.BreakerOpen:
+		return false
+	case models.BreakerHalfOpen:
+		// A trial that never reported back does not block forever.
+		return b.trialAt.IsZero() || now.Sub(b.trialAt) > s.opts.OpenDuration
+	}
+	return true
+}
+
+// begin marks a request through proxy id as the trial of a half-open
+// breaker.
+func (s *breakerSet) begin(id int, now time.Time) {
+	if s.tripped.Load() == 0 {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	b, ok := s.breakers[id]
+	if !ok {
+		return
+	}
+	s.advance(b, now)
+	if b.state == models.BreakerHalfOpen {
+		b.trialAt = now
+	}
+}
+
+// record feeds the outcome of a request through proxy id into its breaker.
+func (s *breakerSet) record(id int, success bool, now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	b := s.get(id)
+	s.advance(b, now)
+	switch b.state {
+	case models.BreakerClosed:
+		bucket := b.bucket(now, s.opts.Window)
+		bucket.requests++
+		if !success {
+			bucket.failures++
+		}
+		requests, failures := b.counts(now, s.opts.Window)
+		if requests >= s.opts.MinRequests && float64(failures) >= s.opts.ErrorRate*float64(requests) {
+			s.trip(b, now)
+		}
+	case models.BreakerHalfOpen:
+		b.trialAt = time.Time{}
+		if !success {
+			s.trip(b, now)
+			return
+		}
+		b.successes++
+		if b.successes >= s.opts.HalfOpenSuccesses {
+			s.close(b)
+		}
+	}
+	// Outcomes of requests sent before an open breaker tripped are
+	// ignored.
+}
+
+// advance turns an open breaker half-open once OpenDuration has passed.
+func (s *breakerSet) advance(b *breaker, now time.Time) {
+	if b.state == models.BreakerOpen && now.Sub(b.openedAt) >= s.opts.OpenDuration {
+		b.state = models.BreakerHalfOpen
+		b.trialAt = time.Time{}
+		b.successes = 0
+	}
+}
+
+func (s *breakerSet) trip(b *breaker, now time.Time) {
+	if b.state == models.BreakerClosed {
+		s.tripped.Add(1)
+	}
+	b.state = models.BreakerOpen
+	b.openedAt = now
+	b.trialAt = time.Time{}
+	b.successes = 0
+	b.buckets = [breakerBuckets]breakerBucket{}
+}
+
+func (s *breakerSet) close(b *breaker) {
+	if b.state != models.BreakerClosed {
+		s.tripped.Add(-1)
+	}
+	b.state = models.BreakerClosed
+	b.trialAt = time.Time{}
+	b.successes = 0
+	b.buckets = [breakerBuckets]breakerBucket{}
+}
+
+// reset closes the breaker of proxy id. It returns false if the proxy has
+// no breaker yet.
+func (s *breakerSet) reset(id int) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	b, ok := s.breakers[id]
+	if ok {
+		s.close(b)
+	}
+	return ok
+}
+
+func (s *breakerSet) forget(id int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if b, ok := s.breakers[id]; ok {
+		if b.state != models.BreakerClosed {
+			s.tripped.Add(-1)
+		}
+		delete(s.breakers, id)
+	}
+}
+
+// status returns the state of proxy id's breaker; proxies that have not
+// carried traffic yet report a closed breaker.
+func (s *breakerSet) status(id int, now time.Time) models.BreakerStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	b, ok := s.breakers[id]
+	if !ok {
+		return models.BreakerStatus{ProxyID: id, State: models.BreakerClosed}
+	}
+	return s.statusOf(id, b, now)
+}
+
+// list returns the state of every breaker, open ones first.
+func (s *breakerSet) list(now time.Time) []models.BreakerStatus {
+	s.mu.Lock()
+	statuses := make([]models.BreakerStatus, 0, len(s.breakers))
+	for id, b := range s.breakers {
+		statuses = append(statuses, s.statusOf(id, b, now))
+	}
+	s.mu.Unlock()
+
+	rank := map[string]int{models.BreakerOpen: 0, models.BreakerHalfOpen: 1, models.BreakerClosed: 2}
+	sort.Slice(statuses, func(i, j int) bool {
+		if rank[statuses[i].State] != rank[statuses[j].State] {
+			return rank[statuses[i].State] < rank[statuses[j].State]
+		}
+		return statuses[i].ProxyID < statuses[j].ProxyID
+	})
+	return statuses
+}
+
+func (s *breakerSet) statusOf(id int, b *breaker, now time.Time) models.BreakerStatus {
+	s.advance(b, now)
+	status := models.BreakerStatus{ProxyID: id, State: b.state}
+	status.Requests, status.Failures = b.counts(now, s.opts.Window)
+	if status.Requests > 0 {
+		status.ErrorRate = float64(status.Failures) / float64(status.Requests)
+	}
+	if b.state != models.BreakerClosed {
+		openedAt := b.openedAt
+		status.OpenedAt = &openedAt
+	}
+	if b.state == models.BreakerOpen {
+		retryAt := b.openedAt.Add(s.opts.OpenDuration)
+		status.RetryAt = &retryAt
+	}
+	return status
+}
+
+// bucket returns the bucket for now, clearing it if it held an older
+// slice of time.
+func (b *breaker) bucket(now time.Time, window time.Duration) *breakerBucket {
+	slot := breakerSlot(now, window)
+	bucket := &b.buckets[slot%breakerBuckets]
+	if bucket.slot != slot {
+		*bucket = breakerBucket{slot: slot}
+	}
+	return bucket
+}
+
+// counts sums the buckets that fall inside the window ending at now.
+func (b *breaker) counts(now time.Time, window time.Duration) (requests, failures int) {
+	current := breakerSlot(now, window)
+	for _, bucket := range b.buckets {
+		if current-bucket.slot < breakerBuckets {
+			requests += bucket.requests
+			failures += bucket.failures
+		}
+	}
+	return requests, failures
+}
+
+func breakerSlot(now time.Time, window time.Duration) int64 {
+	width := int64(window) / breakerBuckets
+	if width <= 0 {
+		width = 1
+	}
+	return now.UnixNano() / width
+}
@@ -0,0 +1,139 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"go-proxy-rotator/models"
+)
+
+func TestBreakerStates(t *testing.T) {
+	const (
+		closed   = models.BreakerClosed
+		open     = models.BreakerOpen
+		halfOpen = models.BreakerHalfOpen
+	)
+	type step struct {
+		at time.Duration
+		// do is "ok" or "fail" to record an outcome, "begin" to start a
+		// trial, "reset", or empty to only look
+		do        string
+		state     string
+		available bool
+		requests  int
+	}
+	// The breaker trips on half of at least four requests failing within
+	// ten seconds and closes after two successful trials.
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{name: "below the minimum requests", steps: []step{
+			{do: "fail", state: closed, available: true, requests: 1},
+			{do: "fail", state: closed, available: true, requests: 2},
+			{do: "fail", state: closed, available: true, requests: 3},
+		}},
+		{name: "trips at the error rate", steps: []step{
+			{do: "ok", state: closed, available: true, requests: 1},
+			{do: "ok", state: closed, available: true, requests: 2},
+			{do: "fail", state: closed, available: true, requests: 3},
+			{do: "fail", state: open},
+		}},
+		{name: "below the error rate", steps: []step{
+			{do: "ok", state: closed, available: true, requests: 1},
+			{do: "ok", state: closed, available: true, requests: 2},
+			{do: "ok", state: closed, available: true, requests: 3},
+			{do: "fail", state: closed, available: true, requests: 4},
+			{do: "ok", state: closed, available: true, requests: 5},
+			{do: "fail", state: closed, available: true, requests: 6},
+		}},
+		{name: "old outcomes slide out of the window", steps: []step{
+			{do: "fail", state: closed, available: true, requests: 1},
+			{at: 3 * time.Second, do: "fail", state: closed, available: true, requests: 2},
+			{at: 6 * time.Second, do: "fail", state: closed, available: true, requests: 3},
+			{at: 11 * time.Second, do: "ok", state: closed, available: true, requests: 3},
+			{at: 13 * time.Second, do: "ok", state: closed, available: true, requests: 3},
+			// Three failures out of six overall, but one out of four in
+			// the window
+			{at: 14 * time.Second, do: "ok", state: closed, available: true, requests: 4},
+		}},
+		{name: "open until the open duration passes", steps: []step{
+			{do: "fail"}, {do: "fail"}, {do: "fail"}, {do: "fail", state: open},
+			{at: 29 * time.Second, state: open},
+			{at: 30 * time.Second, state: halfOpen, available: true},
+		}},
+		{name: "outcomes while open are ignored", steps: []step{
+			{do: "fail"}, {do: "fail"}, {do: "fail"}, {do: "fail", state: open},
+			{at: 5 * time.Second, do: "ok", state: open},
+			{at: 30 * time.Second, state: halfOpen, available: true},
+		}},
+		{name: "one trial at a time", steps: []step{
+			{do: "fail"}, {do: "fail"}, {do: "fail"}, {do: "fail", state: open},
+			{at: 30 * time.Second, do: "begin", state: halfOpen},
+			{at: 59 * time.Second, state: halfOpen},
+			// A trial that never reports back stops blocking
+			{at: 61 * time.Second, state: halfOpen, available: true},
+		}},
+		{name: "successful trials close it", steps: []step{
+			{do: "fail"}, {do: "fail"}, {do: "fail"}, {do: "fail", state: open},
+			{at: 30 * time.Second, do: "begin", state: halfOpen},
+			{at: 31 * time.Second, do: "ok", state: halfOpen, available: true},
+			{at: 32 * time.Second, do: "begin", state: halfOpen},
+			{at: 33 * time.Second, do: "ok", state: closed, available: true},
+			// with a clean window
+			{at: 34 * time.Second, do: "fail", state: closed, available: true, requests: 1},
+		}},
+		{name: "a failed trial opens it again", steps: []step{
+			{do: "fail"}, {do: "fail"}, {do: "fail"}, {do: "fail", state: open},
+			{at: 30 * time.Second, do: "begin", state: halfOpen},
+			{at: 31 * time.Second, do: "ok", state: halfOpen, available: true},
+			{at: 32 * time.Second, do: "fail", state: open},
+			{at: 61 * time.Second, state: open},
+			{at: 62 * time.Second, state: halfOpen, available: true},
+		}},
+		{name: "reset closes it", steps: []step{
+			{do: "fail"}, {do: "fail"}, {do: "fail"}, {do: "fail", state: open},
+			{at: time.Second, do: "reset", state: closed, available: true},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newBreakerSet(BreakerOptions{
+				Window:            10 * time.Second,
+				MinRequests:       4,
+				ErrorRate:         0.5,
+				OpenDuration:      30 * time.Second,
+				HalfOpenSuccesses: 2,
+			})
+			start := time.Unix(1_000_000, 0)
+			for i, step := range tt.steps {
+				now := start.Add(step.at)
+				switch step.do {
+				case "ok", "fail":
+					s.record(1, step.do == "ok", now)
+				case "begin":
+					s.begin(1, now)
+				case "reset":
+					s.reset(1)
+				}
+				if step.state == "" {
+					continue
+				}
+
+				status := s.status(1, now)
+				if status.State != step.state {
+					t.Fatalf("step %d (%s at %v): state = %s, want %s", i, step.do, step.at, status.State, step.state)
+				}
+				if available := s.available(1, now); available != step.available {
+					t.Errorf("step %d (%s at %v): available = %v, want %v", i, step.do, step.at, available, step.available)
+				}
+				if step.state == closed && status.Requests != step.requests {
+					t.Errorf("step %d (%s at %v): requests in window = %d, want %d", i, step.do, step.at, status.Requests, step.requests)
+				}
+				if tripped := s.tripped.Load() != 0; tripped != (step.state != closed) {
+					t.Errorf("step %d: tripped count = %d with the breaker %s", i, s.tripped.Load(), step.state)
+				}
+			}
+		})
+	}
+}
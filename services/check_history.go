@@ -0,0 +1,331 @@
+package services
+
+import (
+	"errors"
+	"log"
+	"sort"
+	"time"
+
+	"go-proxy-rotator/database"
+	"go-proxy-rotator/models"
+)
+
+// maxLatencyBuckets caps the length of a latency time series.
+const maxLatencyBuckets = 1000
+
+// rollupDelay is how long after an hour ends it is rolled up, leaving time
+// for health check runs that were still going to write their checks.
+const rollupDelay = 15 * time.Minute
+
+// ErrTooManyBuckets is returned for latency series that would have more
+// than maxLatencyBuckets points.
+var ErrTooManyBuckets = errors.New("too many buckets")
+
+// CheckHistory maintains the history of health checks: checks are rolled
+// up into hourly summaries, raw checks are kept for Retention and rollups
+// for RollupRetention.
+type CheckHistory struct {
+	db   database.Store
+	opts CheckHistoryOptions
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// CheckHistoryOptions configures a CheckHistory.
+type CheckHistoryOptions struct {
+	// Retention is how long raw checks are kept.
+	Retention time.Duration
+	// RollupRetention is how long hourly rollups are kept.
+	RollupRetention time.Duration
+	// Interval is how often checks are rolled up and old data pruned; zero
+	// disables both.
+	Interval time.Duration
+}
+
+// NewCheckHistory starts the background rollups.
+func NewCheckHistory(db database.Store, opts CheckHistoryOptions) *CheckHistory {
+	if opts.RollupRetention < opts.Retention {
+		opts.RollupRetention = opts.Retention
+	}
+	h := &CheckHistory{
+		db:   db,
+		opts: opts,
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	go h.maintainLoop()
+	return h
+}
+
+// Close stops the background rollups.
+func (h *CheckHistory) Close() {
+	close(h.stop)
+	<-h.done
+}
+
+// Oldest returns the start of the history kept at now: older rollups are
+// pruned. It is the zero time when nothing is pruned.
+func (h *CheckHistory) Oldest(now time.Time) time.Time {
+	if h.opts.Interval <= 0 {
+		return time.Time{}
+	}
+	return now.Add(-h.opts.RollupRetention)
+}
+
+// Checks returns up to limit checks of a proxy made in [from, to), newest
+// first.
+func (h *CheckHistory) Checks(proxyID int, from, to time.Time, limit int) ([]models.ProxyCheck, error) {
+	return h.db.GetProxyChecks(proxyID, from, to, limit)
+}
+
+// Latency computes the latency percentiles of a proxy over [from, to),
+// overall and per bucket. Hours whose raw checks were pruned are covered
+// by their rollups, which makes the result approximate.
+func (h *CheckHistory) Latency(proxyID int, from, to time.Time, bucket time.Duration) (*models.LatencyStats, error) {
+	// Divide before rounding up: the span of a range far in the past is
+	// close to the largest duration
+	span := to.Sub(from)
+	if span/bucket >= maxLatencyBuckets {
+		return nil, ErrTooManyBuckets
+	}
+	n := int(span / bucket)
+	if span%bucket != 0 {
+		n++
+	}
+
+	// Raw checks older than Retention are pruned once rolled up, so the
+	// raw query never reaches further back and its rollups cover the rest.
+	// Without maintenance nothing is rolled up or pruned.
+	var checks []models.ProxyCheck
+	rawFrom := from
+	if h.opts.Interval > 0 {
+		if cutoff := time.Now().Add(-h.opts.Retention).Truncate(time.Hour); rawFrom.Before(cutoff) {
+			rawFrom = cutoff
+		}
+	}
+	if rawFrom.Before(to) {
+		var err error
+		checks, err = h.db.GetProxyChecks(proxyID, rawFrom, to, 0)
+		if err != nil {
+			return nil, err
+		}
+	}
+	// Rollups of the hour from falls in may still cover part of the range
+	rollups, err := h.db.GetCheckRollups(proxyID, from.Truncate(time.Hour), to)
+	if err != nil {
+		return nil, err
+	}
+
+	total := &latencyAccumulator{}
+	buckets := make([]*latencyAccumulator, n)
+	for i := range buckets {
+		buckets[i] = &latencyAccumulator{}
+	}
+	index := func(at time.Time) int {
+		i := int(at.Sub(from) / bucket)
+		if i < 0 {
+			i = 0
+		}
+		if i >= n {
+			i = n - 1
+		}
+		return i
+	}
+
+	// Raw checks are pruned by the hour, so an hour is covered either by
+	// its checks or by its rollup alone
+	rawHours := make(map[int64]bool)
+	for _, c := range checks {
+		rawHours[c.CheckedAt.Truncate(time.Hour).Unix()] = true
+		total.addCheck(c)
+		buckets[index(c.CheckedAt)].addCheck(c)
+	}
+	for _, r := range rollups {
+		if rawHours[r.Hour.Unix()] {
+			continue
+		}
+		total.addRollup(r)
+		buckets[index(r.Hour)].addRollup(r)
+	}
+
+	stats := &models.LatencyStats{
+		ProxyID:     proxyID,
+		From:        from,
+		To:          to,
+		Bucket:      bucket.String(),
+		Checks:      total.checks,
+		Successes:   total.successes,
+		Approximate: len(total.rollups) > 0,
+		Series:      make([]models.LatencyBucket, n),
+	}
+	if total.checks > 0 {
+		stats.SuccessRate = float64(total.successes) / float64(total.checks)
+	}
+	stats.P50, stats.P95, stats.P99 = total.percentiles()
+	for i, acc := range buckets {
+		point := models.LatencyBucket{
+			Start:     from.Add(time.Duration(i) * bucket),
+			Checks:    acc.checks,
+			Successes: acc.successes,
+		}
+		point.P50, point.P95, point.P99 = acc.percentiles()
+		stats.Series[i] = point
+	}
+	return stats, nil
+}
+
+// latencyAccumulator gathers the checks and rollups that fall into one
+// bucket.
+type latencyAccumulator struct {
+	checks, successes int
+	latencies         []int
+	rollups           []models.CheckRollup
+}
+
+func (a *latencyAccumulator) addCheck(c models.ProxyCheck) {
+	a.checks++
+	if c.Success {
+		a.successes++
+		a.latencies = append(a.latencies, c.Latency)
+	}
+}
+
+func (a *latencyAccumulator) addRollup(r models.CheckRollup) {
+	a.checks += r.Checks
+	a.successes += r.Successes
+	a.rollups = append(a.rollups, r)
+}
+
+// percentiles returns the exact percentiles of the raw latencies when
+// there are no rollups. Otherwise percentiles cannot be merged exactly;
+// the result averages those of the raw latencies and of each rollup,
+// weighted by their successful checks.
+func (a *latencyAccumulator) percentiles() (p50, p95, p99 int) {
+	sort.Ints(a.latencies)
+	p50, p95, p99 = percentile(a.latencies, 50), percentile(a.latencies, 95), percentile(a.latencies, 99)
+	if len(a.rollups) == 0 {
+		return p50, p95, p99
+	}
+
+	weight := len(a.latencies)
+	sum50, sum95, sum99 := p50*weight, p95*weight, p99*weight
+	for _, r := range a.rollups {
+		weight += r.Successes
+		sum50 += r.P50 * r.Successes
+		sum95 += r.P95 * r.Successes
+		sum99 += r.P99 * r.Successes
+	}
+	if weight == 0 {
+		return 0, 0, 0
+	}
+	return sum50 / weight, sum95 / weight, sum99 / weight
+}
+
+// percentile returns the nearest-rank percentile p of sorted values, or 0
+// if there are none.
+func percentile(sorted []int, p int) int {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := (p*len(sorted) + 99) / 100
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+// Maintain rolls up every complete hour that has not been rolled up yet,
+// then prunes raw checks and rollups past their retention.
+func (h *CheckHistory) Maintain(now time.Time) error {
+	rolledUntil, err := h.rollUp(now)
+	if err != nil {
+		return err
+	}
+
+	// Whole hours only, and never any that still lack a rollup
+	before := now.Add(-h.opts.Retention).Truncate(time.Hour)
+	if before.After(rolledUntil) {
+		before = rolledUntil
+	}
+	if err := h.db.PruneProxyChecks(before); err != nil {
+		return err
+	}
+	return h.db.PruneCheckRollups(now.Add(-h.opts.RollupRetention))
+}
+
+// rollUp summarises the hours that ended at least rollupDelay ago and
+// returns the end of the last hour that is rolled up.
+func (h *CheckHistory) rollUp(now time.Time) (time.Time, error) {
+	end := now.Add(-rollupDelay).Truncate(time.Hour)
+
+	latest, rolled, err := h.db.LatestCheckRollup()
+	if err != nil {
+		return time.Time{}, err
+	}
+	earliest, found, err := h.db.EarliestProxyCheck()
+	if err != nil {
+		return time.Time{}, err
+	}
+	if !found {
+		return end, nil
+	}
+
+	// Skip hours without checks, e.g. while the server was down
+	start := earliest.Truncate(time.Hour)
+	if rolled && !latest.Add(time.Hour).Before(start) {
+		start = latest.Add(time.Hour)
+	}
+
+	for hour := start; hour.Before(end); hour = hour.Add(time.Hour) {
+		checks, err := h.db.GetChecksBetween(hour, hour.Add(time.Hour))
+		if err != nil {
+			return hour, err
+		}
+		if len(checks) == 0 {
+			continue
+		}
+
+		byProxy := make(map[int]*latencyAccumulator)
+		for _, c := range checks {
+			acc, ok := byProxy[c.ProxyID]
+			if !ok {
+				acc = &latencyAccumulator{}
+				byProxy[c.ProxyID] = acc
+			}
+			acc.addCheck(c)
+		}
+		rollups := make([]models.CheckRollup, 0, len(byProxy))
+		for proxyID, acc := range byProxy {
+			r := models.CheckRollup{ProxyID: proxyID, Hour: hour, Checks: acc.checks, Successes: acc.successes}
+			r.P50, r.P95, r.P99 = acc.percentiles()
+			rollups = append(rollups, r)
+		}
+		if err := h.db.SaveCheckRollups(rollups); err != nil {
+			return hour, err
+		}
+	}
+	return end, nil
+}
+
+func (h *CheckHistory) maintainLoop() {
+	defer close(h.done)
+
+	if h.opts.Interval <= 0 {
+		<-h.stop
+		return
+	}
+	ticker := time.NewTicker(h.opts.Interval)
+	defer ticker.Stop()
+
+	for {
+		if err := h.Maintain(time.Now()); err != nil {
+			log.Printf("Failed to maintain check history: %v", err)
+		}
+		select {
+		case <-ticker.C:
+		case <-h.stop:
+			return
+		}
+	}
+}
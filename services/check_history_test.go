@@ -0,0 +1,219 @@
+package services
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"go-proxy-rotator/models"
+)
+
+func TestCheckHistoryMaintain(t *testing.T) {
+	base := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	checks := []models.ProxyCheck{
+		{ProxyID: 1, CheckedAt: base.Add(10 * time.Minute), Latency: 100, Success: true},
+		{ProxyID: 1, CheckedAt: base.Add(20 * time.Minute), Latency: 300, Success: true},
+		{ProxyID: 1, CheckedAt: base.Add(30 * time.Minute)},
+		{ProxyID: 2, CheckedAt: base.Add(40 * time.Minute), Latency: 50, Success: true},
+		{ProxyID: 1, CheckedAt: base.Add(70 * time.Minute), Latency: 200, Success: true},
+	}
+	hour0 := models.CheckRollup{ProxyID: 1, Hour: base, Checks: 3, Successes: 2, P50: 100, P95: 300, P99: 300}
+	hour1 := models.CheckRollup{ProxyID: 1, Hour: base.Add(time.Hour), Checks: 1, Successes: 1, P50: 200, P95: 200, P99: 200}
+
+	// Raw checks are kept for two hours and rollups for five.
+	tests := []struct {
+		name string
+		// at are the times Maintain runs, after base
+		at []time.Duration
+		// rollups are proxy 1's rollups at the end
+		rollups []models.CheckRollup
+		// raw is how many raw checks are left
+		raw int
+	}{
+		{name: "hour not over", at: []time.Duration{50 * time.Minute}, raw: 5},
+		{name: "within the rollup delay", at: []time.Duration{74 * time.Minute}, raw: 5},
+		{name: "rolled up after the delay", at: []time.Duration{75 * time.Minute},
+			rollups: []models.CheckRollup{hour0}, raw: 5},
+		{name: "rolled up once", at: []time.Duration{75 * time.Minute, 80 * time.Minute, 135 * time.Minute},
+			rollups: []models.CheckRollup{hour0, hour1}, raw: 5},
+		{name: "raw checks pruned by the hour", at: []time.Duration{210 * time.Minute},
+			rollups: []models.CheckRollup{hour0, hour1}, raw: 1},
+		{name: "rollups pruned", at: []time.Duration{330 * time.Minute},
+			rollups: []models.CheckRollup{hour1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newTestStore(t)
+			if err := db.RecordProxyChecks(checks); err != nil {
+				t.Fatal(err)
+			}
+			h := NewCheckHistory(db, CheckHistoryOptions{Retention: 2 * time.Hour, RollupRetention: 5 * time.Hour})
+			defer h.Close()
+
+			for _, at := range tt.at {
+				if err := h.Maintain(base.Add(at)); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			rollups, err := db.GetCheckRollups(1, base, base.Add(24*time.Hour))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(rollups) != len(tt.rollups) {
+				t.Fatalf("rollups = %+v, want %+v", rollups, tt.rollups)
+			}
+			for i, got := range rollups {
+				want := tt.rollups[i]
+				if !got.Hour.Equal(want.Hour) {
+					t.Errorf("rollup %d covers %v, want %v", i, got.Hour, want.Hour)
+				}
+				got.Hour = want.Hour
+				if got != want {
+					t.Errorf("rollup %d = %+v, want %+v", i, got, want)
+				}
+			}
+			raw, err := db.GetChecksBetween(base, base.Add(24*time.Hour))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(raw) != tt.raw {
+				t.Errorf("%d raw checks left, want %d", len(raw), tt.raw)
+			}
+		})
+	}
+}
+
+func TestCheckHistoryLatency(t *testing.T) {
+	// Checks are recorded in the current hour so that the retention cutoff
+	// stays behind them even if the hour ends during the test
+	now := time.Now().UTC().Truncate(time.Hour)
+	from, to := now.Add(-4*time.Hour), now.Add(time.Hour)
+
+	tests := []struct {
+		name    string
+		opts    CheckHistoryOptions
+		checks  []models.ProxyCheck
+		rollups []models.CheckRollup
+		bucket  time.Duration // an hour when zero
+
+		checksTotal int
+		successes   int
+		p50, p95    int
+		approximate bool
+		series      []int // checks per bucket
+		err         error
+	}{
+		{
+			name: "raw checks are exact",
+			opts: CheckHistoryOptions{Retention: time.Hour},
+			checks: []models.ProxyCheck{
+				{ProxyID: 1, CheckedAt: from.Add(10 * time.Minute), Latency: 100, Success: true},
+				{ProxyID: 1, CheckedAt: from.Add(20 * time.Minute), Latency: 200, Success: true},
+				{ProxyID: 1, CheckedAt: from.Add(30 * time.Minute)},
+				{ProxyID: 1, CheckedAt: now.Add(5 * time.Minute), Latency: 400, Success: true},
+				{ProxyID: 2, CheckedAt: now.Add(5 * time.Minute), Latency: 900, Success: true},
+			},
+			checksTotal: 4, successes: 3, p50: 200, p95: 400,
+			series: []int{3, 0, 0, 0, 1},
+		},
+		{
+			name: "pruned hours come from rollups",
+			opts: CheckHistoryOptions{Retention: time.Hour, Interval: time.Hour},
+			checks: []models.ProxyCheck{
+				// past the retention, so only its rollup counts
+				{ProxyID: 1, CheckedAt: now.Add(-170 * time.Minute), Latency: 100, Success: true},
+				{ProxyID: 1, CheckedAt: now.Add(5 * time.Minute), Latency: 400, Success: true},
+				{ProxyID: 1, CheckedAt: now.Add(6 * time.Minute)},
+			},
+			rollups: []models.CheckRollup{
+				{ProxyID: 1, Hour: now.Add(-3 * time.Hour), Checks: 10, Successes: 8, P50: 200, P95: 300, P99: 500},
+				// the hour's raw checks take precedence
+				{ProxyID: 1, Hour: now, Checks: 99, Successes: 99, P50: 1, P95: 1, P99: 1},
+			},
+			checksTotal: 12, successes: 9, p50: (400 + 8*200) / 9, p95: (400 + 8*300) / 9,
+			approximate: true,
+			series:      []int{0, 10, 0, 0, 2},
+		},
+		{
+			name: "without maintenance raw checks cover every hour",
+			opts: CheckHistoryOptions{Retention: time.Hour},
+			checks: []models.ProxyCheck{
+				{ProxyID: 1, CheckedAt: now.Add(-170 * time.Minute), Latency: 100, Success: true},
+			},
+			rollups: []models.CheckRollup{
+				{ProxyID: 1, Hour: now.Add(-3 * time.Hour), Checks: 10, Successes: 8, P50: 200, P95: 300, P99: 500},
+			},
+			checksTotal: 1, successes: 1, p50: 100, p95: 100,
+			series: []int{0, 1, 0, 0, 0},
+		},
+		{
+			name:   "too many buckets",
+			bucket: time.Second,
+			err:    ErrTooManyBuckets,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newTestStore(t)
+			if err := db.RecordProxyChecks(tt.checks); err != nil {
+				t.Fatal(err)
+			}
+			if err := db.SaveCheckRollups(tt.rollups); err != nil {
+				t.Fatal(err)
+			}
+			// Built by hand so that no background maintenance prunes the
+			// checks under test
+			h := &CheckHistory{db: db, opts: tt.opts}
+
+			bucket := tt.bucket
+			if bucket == 0 {
+				bucket = time.Hour
+			}
+			stats, err := h.Latency(1, from, to, bucket)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("Latency error = %v, want %v", err, tt.err)
+			}
+			if err != nil {
+				return
+			}
+
+			if stats.Checks != tt.checksTotal || stats.Successes != tt.successes {
+				t.Errorf("checks = %d with %d successes, want %d with %d", stats.Checks, stats.Successes, tt.checksTotal, tt.successes)
+			}
+			if stats.P50 != tt.p50 || stats.P95 != tt.p95 {
+				t.Errorf("p50, p95 = %d, %d; want %d, %d", stats.P50, stats.P95, tt.p50, tt.p95)
+			}
+			if stats.Approximate != tt.approximate {
+				t.Errorf("approximate = %v, want %v", stats.Approximate, tt.approximate)
+			}
+			if len(stats.Series) != len(tt.series) {
+				t.Fatalf("%d buckets, want %d", len(stats.Series), len(tt.series))
+			}
+			for i, point := range stats.Series {
+				if start := from.Add(time.Duration(i) * bucket); !point.Start.Equal(start) {
+					t.Errorf("bucket %d starts at %v, want %v", i, point.Start, start)
+				}
+				if point.Checks != tt.series[i] {
+					t.Errorf("bucket %d has %d checks, want %d", i, point.Checks, tt.series[i])
+				}
+			}
+		})
+	}
+}
+
+func TestCheckHistoryOldest(t *testing.T) {
+	now := time.Now()
+	maintained := NewCheckHistory(newTestStore(t), CheckHistoryOptions{Retention: 2 * time.Hour, Interval: time.Hour})
+	defer maintained.Close()
+	// Rollups are kept at least as long as raw checks
+	if got, want := maintained.Oldest(now), now.Add(-2*time.Hour); !got.Equal(want) {
+		t.Errorf("Oldest = %v, want %v", got, want)
+	}
+
+	unmaintained := NewCheckHistory(newTestStore(t), CheckHistoryOptions{Retention: 2 * time.Hour})
+	defer unmaintained.Close()
+	if got := unmaintained.Oldest(now); !got.IsZero() {
+		t.Errorf("Oldest without maintenance = %v, want the zero time", got)
+	}
+}
@@ -0,0 +1,360 @@
+package services
+
+import (
+	"log"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go-proxy-rotator/database"
+	"go-proxy-rotator/models"
+)
+
+// DomainHealth tracks the health of every proxy per target domain, so that
+// a proxy one site keeps failing or banning is only taken out of rotation
+// for that site. Like the Pool it is loaded at startup, updated in memory
+// and flushed back in batches.
+type DomainHealth struct {
+	db   database.Store
+	opts DomainHealthOptions
+
+	mu      sync.Mutex
+	entries map[domainKey]*models.DomainHealth
+	dirty   map[domainKey]struct{}
+	// cooling holds the end of every cooldown that may still be running;
+	// its size lets selection skip the lock when there is none.
+	cooling map[domainKey]time.Time
+	nCool   atomic.Int64
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// DomainHealthOptions configures a DomainHealth.
+type DomainHealthOptions struct {
+	// MaxFailures is how many consecutive failures for a domain put the
+	// proxy into cooldown for it.
+	MaxFailures int
+	// CooldownBase is how long the first cooldown lasts; every cooldown
+	// that follows without a success in between doubles it, up to
+	// CooldownMax.
+	CooldownBase time.Duration
+	CooldownMax  time.Duration
+	// TTL is how long the health of a pair is kept after its last request.
+	TTL time.Duration
+	// FlushInterval is how often pending updates are written; zero
+	// disables periodic writes, leaving them to Flush and Close.
+	FlushInterval time.Duration
+}
+
+type domainKey struct {
+	proxyID int
+	domain  string
+}
+
+// NewDomainHealth loads the per-domain health from db and starts the
+// background flusher.
+func NewDomainHealth(db database.Store, opts DomainHealthOptions) (*DomainHealth, error) {
+	entries, err := db.GetDomainHealth()
+	if err != nil {
+		return nil, err
+	}
+	if opts.MaxFailures < 1 {
+		opts.MaxFailures = 1
+	}
+	if opts.CooldownMax < opts.CooldownBase {
+		opts.CooldownMax = opts.CooldownBase
+	}
+
+	d := &DomainHealth{
+		db:      db,
+		opts:    opts,
+		entries: make(map[domainKey]*models.DomainHealth, len(entries)),
+		dirty:   make(map[domainKey]struct{}),
+		cooling: make(map[domainKey]time.Time),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	now := time.Now()
+	for _, entry := range entries {
+		key := domainKey{entry.ProxyID, entry.Domain}
+		d.entries[key] = entry
+		if entry.CooldownUntil != nil && now.Before(*entry.CooldownUntil) {
+			d.cooling[key] = *entry.CooldownUntil
+		}
+	}
+	d.nCool.Store(int64(len(d.cooling)))
+
+	go d.flushLoop()
+	return d, nil
+}
+
+// Close stops the flusher after writing any pending updates.
+func (d *DomainHealth) Close() error {
+	close(d.stop)
+	<-d.done
+	return d.Flush()
+}
+
+// Available reports whether proxy id may be used for domain, that is
+// whether it is not cooling down for it.
+func (d *DomainHealth) Available(id int, domain string, now time.Time) bool {
+	if domain == "" || d.nCool.Load() == 0 {
+		return true
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	key := domainKey{id, domain}
+	until, ok := d.cooling[key]
+	if ok && !now.Before(until) {
+		delete(d.cooling, key)
+		d.nCool.Store(int64(len(d.cooling)))
+		return true
+	}
+	return !ok
+}
+
+// Record applies the outcome of a request through proxy id to domain.
+// MaxFailures consecutive failures put the proxy into cooldown for the
+// domain; once the cooldown is over a single failure is enough to put it
+// back, for twice as long, until a request succeeds.
+func (d *DomainHealth) Record(id int, domain string, success bool, now time.Time) {
+	if domain == "" {
+		return
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	entry := d.entry(id, domain, now)
+	if success {
+		entry.Successes++
+		entry.FailCount = 0
+		entry.Cooldowns = 0
+		entry.CooldownUntil = nil
+		delete(d.cooling, domainKey{id, domain})
+		d.nCool.Store(int64(len(d.cooling)))
+		return
+	}
+
+	entry.Failures++
+	entry.FailCount++
+	if coolingDown(entry, now) {
+		// Requests sent before the cooldown started
+		return
+	}
+	if entry.FailCount >= d.opts.MaxFailures || entry.Cooldowns > 0 {
+		d.coolDown(entry, now)
+	}
+}
+
+// Ban records that domain banned proxy id, putting it into cooldown for
+// the domain right away.
+func (d *DomainHealth) Ban(id int, domain string, now time.Time) {
+	if domain == "" {
+		return
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	entry := d.entry(id, domain, now)
+	entry.Failures++
+	entry.Bans++
+	entry.FailCount++
+	if !coolingDown(entry, now) {
+		d.coolDown(entry, now)
+	}
+}
+
+// entry returns the entry for a new request through proxy id to domain,
+// creating it if needed, and marks it dirty.
+func (d *DomainHealth) entry(id int, domain string, now time.Time) *models.DomainHealth {
+	key := domainKey{id, domain}
+	entry, ok := d.entries[key]
+	if !ok {
+		entry = &models.DomainHealth{ProxyID: id, Domain: domain}
+		d.entries[key] = entry
+	}
+	entry.Requests++
+	entry.LastUsedAt = now
+	d.dirty[key] = struct{}{}
+	return entry
+}
+
+// coolDown takes the proxy out of rotation for the entry's domain, for
+// twice as long as the previous time.
+func (d *DomainHealth) coolDown(entry *models.DomainHealth, now time.Time) {
+	wait := d.opts.CooldownBase
+	for i := 0; i < entry.Cooldowns && wait < d.opts.CooldownMax; i++ {
+		wait *= 2
+	}
+	if wait > d.opts.CooldownMax {
+		wait = d.opts.CooldownMax
+	}
+	until := now.Add(wait)
+
+	entry.Cooldowns++
+	entry.CooldownUntil = &until
+	d.cooling[domainKey{entry.ProxyID, entry.Domain}] = until
+	d.nCool.Store(int64(len(d.cooling)))
+}
+
+func coolingDown(entry *models.DomainHealth, now time.Time) bool {
+	return entry.CooldownUntil != nil && now.Before(*entry.CooldownUntil)
+}
+
+// ForProxy returns the health of proxy id for every domain it has served,
+// those in cooldown first, then the busiest.
+func (d *DomainHealth) ForProxy(id int, now time.Time) []models.DomainHealth {
+	d.mu.Lock()
+	var entries []models.DomainHealth
+	for key, entry := range d.entries {
+		if key.proxyID == id {
+			entries = append(entries, snapshotDomainHealth(entry, now))
+		}
+	}
+	d.mu.Unlock()
+
+	rank := map[string]int{models.ProxyStateCooldown: 0, models.ProxyStateRecovering: 1, models.ProxyStateActive: 2}
+	sort.Slice(entries, func(i, j int) bool {
+		if rank[entries[i].State] != rank[entries[j].State] {
+			return rank[entries[i].State] < rank[entries[j].State]
+		}
+		if entries[i].Requests != entries[j].Requests {
+			return entries[i].Requests > entries[j].Requests
+		}
+		return entries[i].Domain < entries[j].Domain
+	})
+	return entries
+}
+
+// snapshotDomainHealth copies entry and fills in its derived fields.
+func snapshotDomainHealth(entry *models.DomainHealth, now time.Time) models.DomainHealth {
+	health := *entry
+	switch {
+	case coolingDown(entry, now):
+		health.State = models.ProxyStateCooldown
+	case entry.Cooldowns > 0:
+		health.State = models.ProxyStateRecovering
+	default:
+		health.State = models.ProxyStateActive
+	}
+	if health.Requests > 0 {
+		health.SuccessRate = float64(health.Successes) / float64(health.Requests)
+	}
+	return health
+}
+
+// Reset drops the health of proxy id for domain, putting it back into
+// rotation for it. It returns false if there was none.
+func (d *DomainHealth) Reset(id int, domain string) (bool, error) {
+	d.mu.Lock()
+	key := domainKey{id, domain}
+	_, ok := d.entries[key]
+	delete(d.entries, key)
+	delete(d.dirty, key)
+	delete(d.cooling, key)
+	d.nCool.Store(int64(len(d.cooling)))
+	d.mu.Unlock()
+
+	if !ok {
+		return false, nil
+	}
+	return true, d.db.DeleteDomainHealth(id, domain)
+}
+
+// Forget drops the in-memory health of a removed proxy; its rows are
+// deleted along with the proxy.
+func (d *DomainHealth) Forget(id int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for key := range d.entries {
+		if key.proxyID == id {
+			delete(d.entries, key)
+			delete(d.dirty, key)
+			delete(d.cooling, key)
+		}
+	}
+	d.nCool.Store(int64(len(d.cooling)))
+}
+
+// Flush writes all pending updates to the database and forgets pairs
+// unused for longer than the TTL.
+func (d *DomainHealth) Flush() error {
+	now := time.Now()
+	d.mu.Lock()
+	pending := make([]*models.DomainHealth, 0, len(d.dirty))
+	for key := range d.dirty {
+		if entry, ok := d.entries[key]; ok {
+			copied := *entry
+			pending = append(pending, &copied)
+		}
+	}
+	d.dirty = make(map[domainKey]struct{})
+	if d.opts.TTL > 0 {
+		for key, entry := range d.entries {
+			if now.Sub(entry.LastUsedAt) > d.opts.TTL && !coolingDown(entry, now) {
+				delete(d.entries, key)
+				delete(d.cooling, key)
+			}
+		}
+		d.nCool.Store(int64(len(d.cooling)))
+	}
+	d.mu.Unlock()
+
+	for start := 0; start < len(pending); start += flushBatchSize {
+		end := start + flushBatchSize
+		if end > len(pending) {
+			end = len(pending)
+		}
+		if err := d.db.SaveDomainHealth(pending[start:end]); err != nil {
+			d.requeue(pending[start:])
+			return err
+		}
+	}
+	if d.opts.TTL > 0 {
+		return d.db.PruneDomainHealth(now.Add(-d.opts.TTL))
+	}
+	return nil
+}
+
+// requeue marks entries whose updates failed to persist as dirty again,
+// unless they were dropped in the meantime.
+func (d *DomainHealth) requeue(entries []*models.DomainHealth) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for _, entry := range entries {
+		key := domainKey{entry.ProxyID, entry.Domain}
+		if _, ok := d.entries[key]; ok {
+			d.dirty[key] = struct{}{}
+		}
+	}
+}
+
+func (d *DomainHealth) flushLoop() {
+	defer close(d.done)
+
+	var flushC <-chan time.Time
+	if d.opts.FlushInterval > 0 {
+		ticker := time.NewTicker(d.opts.FlushInterval)
+		defer ticker.Stop()
+		flushC = ticker.C
+	}
+
+	for {
+		select {
+		case <-flushC:
+			if err := d.Flush(); err != nil {
+				log.Printf("Failed to flush domain health updates: %v", err)
+			}
+		case <-d.stop:
+			return
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"go-proxy-rotator/models"
+)
+
+func TestDomainHealthCooldowns(t *testing.T) {
+	const (
+		active     = models.ProxyStateActive
+		cooldown   = models.ProxyStateCooldown
+		recovering = models.ProxyStateRecovering
+	)
+	type step struct {
+		at time.Duration
+		// do is "ok", "fail" or "ban" to record an outcome, "reset", or
+		// empty to only look
+		do string
+		// domain defaults to example.com
+		domain    string
+		available bool
+		// state is the proxy's state for the domain; empty when it has no
+		// record
+		state string
+	}
+	// Two failures in a row start a one minute cooldown, doubling up to
+	// three minutes.
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{name: "cooldown after repeated failures", steps: []step{
+			{do: "fail", available: true, state: active},
+			{do: "fail", state: cooldown},
+			{at: 59 * time.Second, state: cooldown},
+			{at: time.Minute, available: true, state: recovering},
+		}},
+		{name: "a success in between", steps: []step{
+			{do: "fail", available: true, state: active},
+			{do: "ok", available: true, state: active},
+			{do: "fail", available: true, state: active},
+		}},
+		{name: "a ban cools down at once", steps: []step{
+			{do: "ban", state: cooldown},
+			{at: time.Minute, available: true, state: recovering},
+		}},
+		{name: "other domains are unaffected", steps: []step{
+			{do: "fail", available: true, state: active},
+			{do: "fail", state: cooldown},
+			{domain: "example.org", available: true},
+			{do: "fail", domain: "example.org", available: true, state: active},
+		}},
+		{name: "failures during a cooldown do not extend it", steps: []step{
+			{do: "fail", available: true, state: active},
+			{do: "fail", state: cooldown},
+			{at: 30 * time.Second, do: "fail", state: cooldown},
+			{at: time.Minute, available: true, state: recovering},
+		}},
+		{name: "one failure after a cooldown doubles it", steps: []step{
+			{do: "fail", available: true, state: active},
+			{do: "fail", state: cooldown},
+			{at: time.Minute, do: "fail", state: cooldown},
+			{at: 179 * time.Second, state: cooldown},
+			{at: 180 * time.Second, available: true, state: recovering},
+			// capped at three minutes
+			{at: 180 * time.Second, do: "fail", state: cooldown},
+			{at: 359 * time.Second, state: cooldown},
+			{at: 360 * time.Second, available: true, state: recovering},
+		}},
+		{name: "a success after a cooldown clears it", steps: []step{
+			{do: "fail", available: true, state: active},
+			{do: "fail", state: cooldown},
+			{at: time.Minute, do: "ok", available: true, state: active},
+			{at: time.Minute, do: "fail", available: true, state: active},
+		}},
+		{name: "reset", steps: []step{
+			{do: "fail", available: true, state: active},
+			{do: "fail", state: cooldown},
+			{do: "reset", available: true},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, err := NewDomainHealth(newTestStore(t), DomainHealthOptions{
+				MaxFailures:  2,
+				CooldownBase: time.Minute,
+				CooldownMax:  3 * time.Minute,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer d.Close()
+
+			start := time.Now()
+			for i, step := range tt.steps {
+				now := start.Add(step.at)
+				domain := step.domain
+				if domain == "" {
+					domain = "example.com"
+				}
+				switch step.do {
+				case "ok", "fail":
+					d.Record(1, domain, step.do == "ok", now)
+				case "ban":
+					d.Ban(1, domain, now)
+				case "reset":
+					if _, err := d.Reset(1, domain); err != nil {
+						t.Fatal(err)
+					}
+				}
+
+				if available := d.Available(1, domain, now); available != step.available {
+					t.Errorf("step %d (%s %s at %v): available = %v, want %v", i, step.do, domain, step.at, available, step.available)
+				}
+				state := ""
+				for _, health := range d.ForProxy(1, now) {
+					if health.Domain == domain {
+						state = health.State
+					}
+				}
+				if state != step.state {
+					t.Errorf("step %d (%s %s at %v): state = %q, want %q", i, step.do, domain, step.at, state, step.state)
+				}
+			}
+		})
+	}
+}
+
+func TestDomainHealthPersists(t *testing.T) {
+	db := newTestStore(t)
+	opts := DomainHealthOptions{MaxFailures: 1, CooldownBase: time.Hour}
+	d, err := NewDomainHealth(db, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.Record(1, "example.com", false, time.Now())
+	d.Record(1, "example.org", true, time.Now())
+	if err := d.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	reloaded, err := NewDomainHealth(db, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reloaded.Close()
+	if reloaded.Available(1, "example.com", time.Now()) {
+		t.Error("cooldown for example.com was not restored")
+	}
+	if !reloaded.Available(1, "example.org", time.Now()) {
+		t.Error("example.org is not available after reload")
+	}
+	if got := len(reloaded.ForProxy(1, time.Now())); got != 2 {
+		t.Errorf("reloaded %d domains, want 2", got)
+	}
+}
@@ -0,0 +1,121 @@
+package services
+
+import (
+	"encoding/json"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	"go-proxy-rotator/models"
+)
+
+// exitIPFields are the JSON fields common "what is my IP" services report
+// the caller's address in.
+var exitIPFields = []string{"origin", "ip", "ip_addr", "query", "address", "client_ip"}
+
+// exitIPFromBody extracts the caller's address from the response of a
+// service such as httpbin.org/ip ({"origin": "203.0.113.7"}) or
+// api.ipify.org (a bare address). It returns "" for any other response.
+func exitIPFromBody(body []byte) string {
+	if ip := net.ParseIP(strings.TrimSpace(string(body))); ip != nil {
+		return ip.String()
+	}
+
+	var fields map[string]interface{}
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return ""
+	}
+	for _, name := range exitIPFields {
+		value, ok := fields[name].(string)
+		if !ok {
+			continue
+		}
+		// httpbin lists forwarded addresses first and the caller last
+		parts := strings.Split(value, ",")
+		if ip := net.ParseIP(strings.TrimSpace(parts[len(parts)-1])); ip != nil {
+			return ip.String()
+		}
+	}
+	return ""
+}
+
+// ExitIPHistory returns up to limit exit IPs of a proxy, newest first. It
+// returns false if there is no such proxy.
+func (s *ProxyService) ExitIPHistory(proxyID, limit int) ([]models.ExitIP, bool, error) {
+	proxy, ok := s.Pool.Get(proxyID)
+	if !ok {
+		return nil, false, nil
+	}
+	history, err := s.DB.GetExitIPHistory(proxyID, limit)
+	if err != nil {
+		return nil, true, err
+	}
+	// The newest entry may not have been written yet after the latest check
+	if len(history) > 0 && proxy.ExitIPSeenAt != nil && history[0].IP == proxy.ExitIP &&
+		proxy.ExitIPSeenAt.After(history[0].LastSeenAt) {
+		history[0].LastSeenAt = *proxy.ExitIPSeenAt
+	}
+	return history, true, nil
+}
+
+// SharedExitIPs returns the exit IPs seen on more than one proxy, most
+// shared first. With a zero window only the proxies' current exit IPs are
+// compared, which finds proxies that egress through the same address right
+// now; otherwise every address seen within the window counts, which finds
+// rotating gateways whose pools overlap.
+func (s *ProxyService) SharedExitIPs(window time.Duration) ([]models.SharedExitIP, error) {
+	var seen []models.ExitIP
+	if window == 0 {
+		for _, proxy := range s.Pool.All() {
+			if proxy.ExitIP != "" && proxy.ExitIPSeenAt != nil {
+				seen = append(seen, models.ExitIP{ProxyID: proxy.ID, IP: proxy.ExitIP,
+					FirstSeenAt: *proxy.ExitIPSeenAt, LastSeenAt: *proxy.ExitIPSeenAt})
+			}
+		}
+	} else {
+		var err error
+		if seen, err = s.DB.GetExitIPsSince(time.Now().Add(-window)); err != nil {
+			return nil, err
+		}
+	}
+
+	byIP := make(map[string]*models.SharedExitIP)
+	proxies := make(map[string]map[int]bool)
+	for _, e := range seen {
+		if _, ok := s.Pool.Get(e.ProxyID); !ok {
+			continue
+		}
+		shared, ok := byIP[e.IP]
+		if !ok {
+			shared = &models.SharedExitIP{IP: e.IP, FirstSeenAt: e.FirstSeenAt, LastSeenAt: e.LastSeenAt}
+			byIP[e.IP] = shared
+			proxies[e.IP] = make(map[int]bool)
+		}
+		if !proxies[e.IP][e.ProxyID] {
+			proxies[e.IP][e.ProxyID] = true
+			shared.ProxyIDs = append(shared.ProxyIDs, e.ProxyID)
+		}
+		if e.FirstSeenAt.Before(shared.FirstSeenAt) {
+			shared.FirstSeenAt = e.FirstSeenAt
+		}
+		if e.LastSeenAt.After(shared.LastSeenAt) {
+			shared.LastSeenAt = e.LastSeenAt
+		}
+	}
+
+	result := make([]models.SharedExitIP, 0)
+	for _, shared := range byIP {
+		if len(shared.ProxyIDs) > 1 {
+			sort.Ints(shared.ProxyIDs)
+			result = append(result, *shared)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if len(result[i].ProxyIDs) != len(result[j].ProxyIDs) {
+			return len(result[i].ProxyIDs) > len(result[j].ProxyIDs)
+		}
+		return result[i].IP < result[j].IP
+	})
+	return result, nil
+}
@@ -0,0 +1,247 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"go-proxy-rotator/models"
+)
+
+// ErrHealthJobRunning is returned when a manual health check is requested
+// while another one is still running.
+var ErrHealthJobRunning = errors.New("a health check job is already running")
+
+// HealthJobs runs health checks as jobs whose progress can be followed
+// while they run and whose outcome is kept in the database afterwards.
+type HealthJobs struct {
+	service *ProxyService
+	url     string
+	history int
+
+	mu      sync.Mutex
+	running map[string]*runningJob
+	wg      sync.WaitGroup
+	closed  bool
+}
+
+type runningJob struct {
+	job    *models.HealthJob // guarded by HealthJobs.mu
+	cancel context.CancelFunc
+}
+
+// NewHealthJobs creates the job runner. url is checked when a job does not
+// name one; history is how many finished jobs are kept, zero keeping all.
+// Jobs left running by a previous process are marked as failed.
+func NewHealthJobs(service *ProxyService, url string, history int) (*HealthJobs, error) {
+	if err := service.DB.InterruptHealthJobs(); err != nil {
+		return nil, err
+	}
+	return &HealthJobs{
+		service: service,
+		url:     url,
+		history: history,
+		running: make(map[string]*runningJob),
+	}, nil
+}
+
+// URL returns the URL checked when a job does not name one.
+func (j *HealthJobs) URL() string {
+	return j.url
+}
+
+// Start checks the active proxies against testURL (the default URL if
+// empty) in the background and returns the new job. Only one manual job
+// runs at a time.
+func (j *HealthJobs) Start(testURL string) (*models.HealthJob, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	proxies := j.service.Pool.Active()
+	r, err := j.begin(models.HealthJobManual, testURL, len(proxies), cancel)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	go j.execute(ctx, r, proxies)
+	return j.snapshot(r), nil
+}
+
+// Run checks proxies against testURL as a job and waits for it to finish.
+func (j *HealthJobs) Run(ctx context.Context, trigger, testURL string, proxies []*models.Proxy) (*models.HealthJob, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	r, err := j.begin(trigger, testURL, len(proxies), cancel)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	j.execute(ctx, r, proxies)
+	return j.snapshot(r), nil
+}
+
+// Get returns a job with its per-proxy results, or nil if there is no job
+// with that ID.
+func (j *HealthJobs) Get(id string) (*models.HealthJob, error) {
+	j.mu.Lock()
+	r, ok := j.running[id]
+	j.mu.Unlock()
+	if ok {
+		return j.snapshot(r), nil
+	}
+	return j.service.DB.GetHealthJob(id)
+}
+
+// List returns the most recent jobs, newest first, without their per-proxy
+// results.
+func (j *HealthJobs) List(limit int) ([]*models.HealthJob, error) {
+	jobs, err := j.service.DB.ListHealthJobs(limit)
+	if err != nil {
+		return nil, err
+	}
+
+	// The stored rows of running jobs only get their counts at the end.
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	for i, job := range jobs {
+		if r, ok := j.running[job.ID]; ok {
+			live := *r.job
+			live.Results = nil
+			jobs[i] = &live
+		}
+	}
+	return jobs, nil
+}
+
+// Cancel stops a running job. It returns false if the job is not running.
+func (j *HealthJobs) Cancel(id string) bool {
+	j.mu.Lock()
+	r, ok := j.running[id]
+	j.mu.Unlock()
+	if ok {
+		r.cancel()
+	}
+	return ok
+}
+
+// Close cancels the running jobs and waits for them to be saved.
+func (j *HealthJobs) Close() {
+	j.mu.Lock()
+	j.closed = true
+	for _, r := range j.running {
+		r.cancel()
+	}
+	j.mu.Unlock()
+	j.wg.Wait()
+}
+
+// begin records a new running job.
+func (j *HealthJobs) begin(trigger, testURL string, total int, cancel context.CancelFunc) (*runningJob, error) {
+	if testURL == "" {
+		testURL = j.url
+	}
+	id, err := newJobID()
+	if err != nil {
+		return nil, err
+	}
+	r := &runningJob{
+		job: &models.HealthJob{
+			ID:        id,
+			Status:    models.HealthJobRunning,
+			Trigger:   trigger,
+			URL:       testURL,
+			Total:     total,
+			StartedAt: time.Now(),
+		},
+		cancel: cancel,
+	}
+
+	j.mu.Lock()
+	if j.closed {
+		j.mu.Unlock()
+		return nil, errors.New("health checks are shutting down")
+	}
+	if trigger == models.HealthJobManual {
+		for _, other := range j.running {
+			if other.job.Trigger == models.HealthJobManual {
+				j.mu.Unlock()
+				return nil, fmt.Errorf("%w: %s", ErrHealthJobRunning, other.job.ID)
+			}
+		}
+	}
+	j.running[id] = r
+	j.wg.Add(1)
+	j.mu.Unlock()
+
+	if err := j.service.DB.CreateHealthJob(r.job); err != nil {
+		j.mu.Lock()
+		delete(j.running, id)
+		j.mu.Unlock()
+		j.wg.Done()
+		return nil, err
+	}
+	return r, nil
+}
+
+// execute checks proxies, then saves the outcome of the job.
+func (j *HealthJobs) execute(ctx context.Context, r *runningJob, proxies []*models.Proxy) {
+	defer j.wg.Done()
+	defer r.cancel()
+
+	j.service.CheckProxies(ctx, proxies, r.job.URL, func(result models.HealthCheckResult) {
+		j.mu.Lock()
+		defer j.mu.Unlock()
+		r.job.Results = append(r.job.Results, result)
+		r.job.Checked++
+		if result.Success {
+			r.job.Passed++
+		} else {
+			r.job.Failed++
+		}
+	})
+
+	j.mu.Lock()
+	finished := time.Now()
+	r.job.FinishedAt = &finished
+	r.job.Status = models.HealthJobCompleted
+	if ctx.Err() != nil && r.job.Checked < r.job.Total {
+		r.job.Status = models.HealthJobCancelled
+	}
+	job := *r.job
+	j.mu.Unlock()
+
+	if err := j.service.DB.FinishHealthJob(&job); err != nil {
+		log.Printf("Failed to save health job %s: %v", job.ID, err)
+	}
+	if j.history > 0 {
+		if err := j.service.DB.PruneHealthJobs(j.history); err != nil {
+			log.Printf("Failed to prune health jobs: %v", err)
+		}
+	}
+
+	j.mu.Lock()
+	delete(j.running, job.ID)
+	j.mu.Unlock()
+}
+
+// snapshot returns a copy of the job that is safe to use after the lock is
+// released.
+func (j *HealthJobs) snapshot(r *runningJob) *models.HealthJob {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	job := *r.job
+	job.Results = append([]models.HealthCheckResult(nil), r.job.Results...)
+	return &job
+}
+
+func newJobID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate job id: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
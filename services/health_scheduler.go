@@ -0,0 +1,295 @@
+package services
+
+import (
+	"context"
+	"log"
+	"math/rand"
+	"sync"
+	"time"
+
+	"go-proxy-rotator/models"
+)
+
+// HealthSchedulerConfig configures the background health checker.
+type HealthSchedulerConfig struct {
+	// URL is requested through each proxy.
+	URL string
+	// Interval between checks of the active proxies; zero disables
+	// scheduled checks, leaving only manual triggers.
+	Interval time.Duration
+	// InactiveInterval is how often the scheduler probes the proxies whose
+	// cooldown has run out; zero disables probing.
+	InactiveInterval time.Duration
+	// Jitter randomly shifts every run by up to this much in either
+	// direction, so checks don't hit upstreams in lockstep.
+	Jitter time.Duration
+}
+
+// HealthSchedulerStatus is a snapshot of the scheduler state.
+type HealthSchedulerStatus struct {
+	URL              string     `json:"url"`
+	Paused           bool       `json:"paused"`
+	Running          bool       `json:"running"`
+	Interval         string     `json:"interval"`
+	InactiveInterval string     `json:"inactive_interval"`
+	Jitter           string     `json:"jitter"`
+	LastRun          *time.Time `json:"last_run,omitempty"`
+	LastInactiveRun  *time.Time `json:"last_inactive_run,omitempty"`
+	NextRun          *time.Time `json:"next_run,omitempty"`
+	NextInactiveRun  *time.Time `json:"next_inactive_run,omitempty"`
+	LastDurationMs   int64      `json:"last_duration_ms"`
+	LastCheckedCount int        `json:"last_checked"`
+	LastPassedCount  int        `json:"last_passed"`
+}
+
+// HealthScheduler periodically health-checks the pool in the background.
+// Active proxies are checked every Interval; proxies in cooldown are probed
+// once their cooldown has run out, polled every InactiveInterval, so they
+// get a chance to recover.
+type HealthScheduler struct {
+	service *ProxyService
+	jobs    *HealthJobs
+	cfg     HealthSchedulerConfig
+
+	mu              sync.Mutex
+	paused          bool
+	running         bool
+	lastRun         time.Time
+	lastInactiveRun time.Time
+	nextRun         time.Time
+	nextInactiveRun time.Time
+	lastDuration    time.Duration
+	lastChecked     int
+	lastPassed      int
+
+	trigger chan struct{}
+	cancel  context.CancelFunc
+	done    chan struct{}
+}
+
+// NewHealthScheduler creates a scheduler whose runs are recorded as jobs;
+// call Start to begin checking.
+func NewHealthScheduler(service *ProxyService, jobs *HealthJobs, cfg HealthSchedulerConfig) *HealthScheduler {
+	return &HealthScheduler{
+		service: service,
+		jobs:    jobs,
+		cfg:     cfg,
+		trigger: make(chan struct{}, 1),
+		done:    make(chan struct{}),
+	}
+}
+
+// URL returns the URL requested through each proxy.
+func (s *HealthScheduler) URL() string {
+	return s.cfg.URL
+}
+
+// Start runs the scheduler until Stop is called.
+func (s *HealthScheduler) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	s.cancel = cancel
+	go s.loop(ctx)
+}
+
+// Stop cancels any check in progress and waits for the scheduler to exit.
+func (s *HealthScheduler) Stop() {
+	s.cancel()
+	<-s.done
+}
+
+// Pause skips scheduled runs until Resume. Triggered runs still happen.
+func (s *HealthScheduler) Pause() {
+	s.mu.Lock()
+	s.paused = true
+	s.mu.Unlock()
+}
+
+// Resume re-enables scheduled runs.
+func (s *HealthScheduler) Resume() {
+	s.mu.Lock()
+	s.paused = false
+	s.mu.Unlock()
+}
+
+// Trigger requests an immediate check of all proxies, active and inactive.
+// It returns false if a triggered run is already pending.
+func (s *HealthScheduler) Trigger() bool {
+	select {
+	case s.trigger <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+// Status returns the current scheduler state.
+func (s *HealthScheduler) Status() HealthSchedulerStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return HealthSchedulerStatus{
+		URL:              s.cfg.URL,
+		Paused:           s.paused,
+		Running:          s.running,
+		Interval:         s.cfg.Interval.String(),
+		InactiveInterval: s.cfg.InactiveInterval.String(),
+		Jitter:           s.cfg.Jitter.String(),
+		LastRun:          optionalTime(s.lastRun),
+		LastInactiveRun:  optionalTime(s.lastInactiveRun),
+		NextRun:          optionalTime(s.nextRun),
+		NextInactiveRun:  optionalTime(s.nextInactiveRun),
+		LastDurationMs:   s.lastDuration.Milliseconds(),
+		LastCheckedCount: s.lastChecked,
+		LastPassedCount:  s.lastPassed,
+	}
+}
+
+func (s *HealthScheduler) loop(ctx context.Context) {
+	defer close(s.done)
+
+	s.mu.Lock()
+	s.nextRun = s.schedule(s.cfg.Interval)
+	s.nextInactiveRun = s.schedule(s.cfg.InactiveInterval)
+	s.mu.Unlock()
+
+	timer := time.NewTimer(s.untilNext())
+	defer timer.Stop()
+
+	for {
+		var triggered bool
+		select {
+		case <-timer.C:
+		case <-s.trigger:
+			triggered = true
+		case <-ctx.Done():
+			return
+		}
+
+		now := time.Now()
+		s.mu.Lock()
+		activeDue := triggered || due(s.nextRun, now)
+		inactiveDue := triggered || due(s.nextInactiveRun, now)
+		paused := s.paused && !triggered
+		s.mu.Unlock()
+
+		s.run(ctx, activeDue && !paused, inactiveDue && !paused, triggered)
+
+		// Schedule from the end of the run, so slow runs never pile up.
+		s.mu.Lock()
+		if activeDue {
+			s.nextRun = s.schedule(s.cfg.Interval)
+		}
+		if inactiveDue {
+			s.nextInactiveRun = s.schedule(s.cfg.InactiveInterval)
+		}
+		s.mu.Unlock()
+
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		timer.Reset(s.untilNext())
+	}
+}
+
+// run checks the requested proxy sets and records the outcome. Inactive
+// proxies are only probed once their cooldown is over, unless the run was
+// triggered.
+func (s *HealthScheduler) run(ctx context.Context, active, inactive, triggered bool) {
+	if !active && !inactive {
+		return
+	}
+
+	var proxies []*models.Proxy
+	if active {
+		proxies = append(proxies, s.service.Pool.Active()...)
+	}
+	if inactive && triggered {
+		proxies = append(proxies, s.service.Pool.Inactive()...)
+	} else if inactive {
+		proxies = append(proxies, s.service.Pool.DueForProbe(time.Now())...)
+	}
+
+	s.mu.Lock()
+	s.running = true
+	s.mu.Unlock()
+
+	start := time.Now()
+	var checked, passed int
+	if len(proxies) > 0 {
+		job, err := s.jobs.Run(ctx, models.HealthJobScheduled, s.cfg.URL, proxies)
+		if err != nil {
+			log.Printf("Health check failed to start: %v", err)
+		} else {
+			checked, passed = job.Checked, job.Passed
+		}
+	}
+	duration := time.Since(start)
+
+	s.mu.Lock()
+	s.running = false
+	if active {
+		s.lastRun = start
+	}
+	if inactive {
+		s.lastInactiveRun = start
+	}
+	s.lastDuration = duration
+	s.lastChecked = checked
+	s.lastPassed = passed
+	s.mu.Unlock()
+
+	if checked > 0 {
+		log.Printf("Health check: %d/%d proxies passed in %v", passed, checked, duration.Round(time.Millisecond))
+	}
+}
+
+// schedule returns the next run time for interval, or the zero time when
+// the interval is disabled.
+func (s *HealthScheduler) schedule(interval time.Duration) time.Time {
+	if interval <= 0 {
+		return time.Time{}
+	}
+	delay := interval
+	if s.cfg.Jitter > 0 {
+		delay += time.Duration(rand.Int63n(int64(2*s.cfg.Jitter)+1)) - s.cfg.Jitter
+	}
+	if delay < time.Second {
+		delay = time.Second
+	}
+	return time.Now().Add(delay)
+}
+
+// untilNext returns how long to sleep until the earliest scheduled run.
+func (s *HealthScheduler) untilNext() time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var next time.Time
+	for _, t := range []time.Time{s.nextRun, s.nextInactiveRun} {
+		if !t.IsZero() && (next.IsZero() || t.Before(next)) {
+			next = t
+		}
+	}
+	if next.IsZero() {
+		// Nothing scheduled; wake up only for triggers.
+		return 24 * time.Hour
+	}
+	if d := time.Until(next); d > 0 {
+		return d
+	}
+	return 0
+}
+
+func due(t, now time.Time) bool {
+	return !t.IsZero() && !now.Before(t)
+}
+
+func optionalTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
@@ -0,0 +1,567 @@
+package services
+
+import (
+	"fmt"
+	"log"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"go-proxy-rotator/database"
+	"go-proxy-rotator/models"
+)
+
+// maxFailCount is the number of consecutive failures after which a proxy
+// is put into cooldown.
+const maxFailCount = 5
+
+// flushBatchSize caps how many proxies are written per transaction.
+const flushBatchSize = 500
+
+// Pool is the in-memory, concurrency-safe view of the proxies table used on
+// the request path. It is loaded from the database at startup; additions
+// and deletions are written through synchronously, while health updates
+// are applied in memory and flushed back in batches. When several
+// instances share a database, each pool periodically syncs the proxies
+// the others added, relabelled or deleted.
+//
+// Proxies handed out by the pool are immutable snapshots: updates replace
+// the stored value instead of modifying it, so callers may keep and read
+// them without locking.
+type Pool struct {
+	db   database.Store
+	opts PoolOptions
+
+	mu      sync.RWMutex
+	proxies map[int]*models.Proxy
+	active  []*models.Proxy // cached; nil when it needs rebuilding
+	dirty   map[int]struct{}
+
+	// onRemove is called with the proxies a sync found deleted.
+	onRemove func(id int)
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// PoolOptions configures a Pool.
+type PoolOptions struct {
+	// FlushInterval is how often pending health updates are written; zero
+	// disables periodic writes, leaving them to Flush and Close.
+	FlushInterval time.Duration
+	// SyncInterval is how often proxies are reloaded from the database to
+	// pick up changes made by other instances; zero disables syncing.
+	SyncInterval time.Duration
+	// CooldownBase is how long a proxy waits for its first probe after it
+	// is put into cooldown; every failed probe doubles the wait, up to
+	// CooldownMax.
+	CooldownBase time.Duration
+	CooldownMax  time.Duration
+	// ReactivateAfter is how many consecutive successful probes bring a
+	// proxy back from cooldown.
+	ReactivateAfter int
+}
+
+// NewPool loads all proxies from db and starts the background flusher.
+func NewPool(db database.Store, opts PoolOptions) (*Pool, error) {
+	proxies, err := db.GetAllProxies()
+	if err != nil {
+		return nil, err
+	}
+	if opts.ReactivateAfter < 1 {
+		opts.ReactivateAfter = 1
+	}
+	if opts.CooldownMax < opts.CooldownBase {
+		opts.CooldownMax = opts.CooldownBase
+	}
+
+	p := &Pool{
+		db:      db,
+		opts:    opts,
+		proxies: make(map[int]*models.Proxy, len(proxies)),
+		dirty:   make(map[int]struct{}),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	now := time.Now()
+	for _, proxy := range proxies {
+		if normalizeState(proxy, now) {
+			p.dirty[proxy.ID] = struct{}{}
+		}
+		p.proxies[proxy.ID] = proxy
+	}
+
+	go p.flushLoop()
+	return p, nil
+}
+
+// Close stops the flusher after writing any pending updates.
+func (p *Pool) Close() error {
+	close(p.stop)
+	<-p.done
+	return p.Flush()
+}
+
+// Get returns the proxy with the given ID.
+func (p *Pool) Get(id int) (*models.Proxy, bool) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	proxy, ok := p.proxies[id]
+	return proxy, ok
+}
+
+// Active returns the active proxies (is_active and fewer than maxFailCount
+// failures), fastest first. The returned slice must not be modified.
+func (p *Pool) Active() []*models.Proxy {
+	p.mu.RLock()
+	active := p.active
+	p.mu.RUnlock()
+	if active != nil {
+		return active
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.active == nil {
+		active := make([]*models.Proxy, 0, len(p.proxies))
+		for _, proxy := range p.proxies {
+			if proxy.IsActive && proxy.FailCount < maxFailCount {
+				active = append(active, proxy)
+			}
+		}
+		sort.Slice(active, func(i, j int) bool {
+			if active[i].ResponseTime != active[j].ResponseTime {
+				return active[i].ResponseTime < active[j].ResponseTime
+			}
+			return active[i].FailCount < active[j].FailCount
+		})
+		p.active = active
+	}
+	return p.active
+}
+
+// Inactive returns the proxies that are not eligible for selection, so
+// they can be re-checked and recover.
+func (p *Pool) Inactive() []*models.Proxy {
+	return p.inactive(func(*models.Proxy) bool { return true })
+}
+
+// DueForProbe returns the inactive proxies whose cooldown has run out by
+// now.
+func (p *Pool) DueForProbe(now time.Time) []*models.Proxy {
+	return p.inactive(func(proxy *models.Proxy) bool {
+		return proxy.NextProbeAt == nil || !now.Before(*proxy.NextProbeAt)
+	})
+}
+
+func (p *Pool) inactive(keep func(*models.Proxy) bool) []*models.Proxy {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	var inactive []*models.Proxy
+	for _, proxy := range p.proxies {
+		if (!proxy.IsActive || proxy.FailCount >= maxFailCount) && keep(proxy) {
+			inactive = append(inactive, proxy)
+		}
+	}
+	sort.Slice(inactive, func(i, j int) bool { return inactive[i].ID < inactive[j].ID })
+	return inactive
+}
+
+// All returns every proxy, newest first.
+func (p *Pool) All() []*models.Proxy {
+	p.mu.RLock()
+	all := make([]*models.Proxy, 0, len(p.proxies))
+	for _, proxy := range p.proxies {
+		all = append(all, proxy)
+	}
+	p.mu.RUnlock()
+
+	sort.Slice(all, func(i, j int) bool {
+		if !all[i].CreatedAt.Equal(all[j].CreatedAt) {
+			return all[i].CreatedAt.After(all[j].CreatedAt)
+		}
+		return all[i].ID > all[j].ID
+	})
+	return all
+}
+
+// Stats computes proxy statistics from the in-memory state.
+func (p *Pool) Stats() *models.ProxyStats {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	stats := &models.ProxyStats{TotalProxies: len(p.proxies)}
+	for _, proxy := range p.proxies {
+		if proxy.IsActive {
+			stats.ActiveProxies++
+		}
+		switch proxy.State {
+		case models.ProxyStateCooldown:
+			stats.CooldownProxies++
+		case models.ProxyStateRecovering:
+			stats.RecoveringProxies++
+		}
+		if proxy.IsHealthy() {
+			stats.HealthyProxies++
+		}
+		if !proxy.IsActive || proxy.FailCount >= maxFailCount {
+			stats.FailedProxies++
+		}
+	}
+	return stats
+}
+
+// Add inserts proxy into the database and the pool.
+func (p *Pool) Add(proxy *models.Proxy) error {
+	normalizeState(proxy, time.Now())
+	if err := p.db.AddProxy(proxy); err != nil {
+		return err
+	}
+
+	stored := *proxy
+	p.mu.Lock()
+	p.proxies[stored.ID] = &stored
+	p.active = nil
+	p.mu.Unlock()
+	return nil
+}
+
+// SetLabels replaces a proxy's tags and country, in the database and the
+// pool, and returns the updated proxy.
+func (p *Pool) SetLabels(id int, tags []string, country string) (*models.Proxy, error) {
+	current, ok := p.Get(id)
+	if !ok {
+		return nil, fmt.Errorf("proxy with id %d not found", id)
+	}
+
+	updated := *current
+	updated.Tags = tags
+	updated.Country = country
+	updated.UpdatedAt = time.Now()
+	if err := p.db.UpdateProxyLabels(&updated); err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	// Health may have changed while the database was written; keep it.
+	if latest, ok := p.proxies[id]; ok {
+		merged := *latest
+		merged.Tags = updated.Tags
+		merged.Country = updated.Country
+		merged.UpdatedAt = updated.UpdatedAt
+		p.proxies[id] = &merged
+		p.active = nil
+		return &merged, nil
+	}
+	return &updated, nil
+}
+
+// SetAnonymity records the anonymity level a health check classified a
+// proxy as. The change is persisted by the next flush.
+func (p *Pool) SetAnonymity(id int, level string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	current, ok := p.proxies[id]
+	if !ok || current.Anonymity == level {
+		return
+	}
+	updated := *current
+	updated.Anonymity = level
+	updated.UpdatedAt = time.Now()
+	p.proxies[id] = &updated
+	p.dirty[id] = struct{}{}
+	p.active = nil
+}
+
+// SetExitIP records the address a health check saw a proxy egress from.
+// The change is persisted by the next flush.
+func (p *Pool) SetExitIP(id int, ip string, seenAt time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	current, ok := p.proxies[id]
+	if !ok {
+		return
+	}
+	updated := *current
+	updated.ExitIP = ip
+	updated.ExitIPSeenAt = &seenAt
+	p.proxies[id] = &updated
+	p.dirty[id] = struct{}{}
+	p.active = nil
+}
+
+// Remove deletes a proxy from the database and the pool.
+func (p *Pool) Remove(id int) error {
+	if err := p.db.DeleteProxy(id); err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	delete(p.proxies, id)
+	delete(p.dirty, id)
+	p.active = nil
+	p.mu.Unlock()
+	return nil
+}
+
+// Clear deletes every proxy and returns the IDs that were removed.
+func (p *Pool) Clear() ([]int, error) {
+	if err := p.db.ClearAllProxies(); err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	ids := make([]int, 0, len(p.proxies))
+	for id := range p.proxies {
+		ids = append(ids, id)
+	}
+	p.proxies = make(map[int]*models.Proxy)
+	p.dirty = make(map[int]struct{})
+	p.active = nil
+	return ids, nil
+}
+
+// RecordHealth applies a health check or request outcome to a proxy.
+// maxFailCount consecutive failures put an active proxy into cooldown. A
+// proxy in cooldown is probed once its cooldown runs out: a failed probe
+// doubles the cooldown, while ReactivateAfter consecutive successful probes
+// make it active again. The change is persisted by the next flush.
+func (p *Pool) RecordHealth(id int, responseTime int, success bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	current, ok := p.proxies[id]
+	if !ok {
+		return
+	}
+
+	updated := *current
+	now := time.Now()
+	updated.LastChecked = now
+	updated.UpdatedAt = now
+	if success {
+		updated.ResponseTime = responseTime
+	}
+
+	switch {
+	case updated.State == models.ProxyStateActive && success:
+		updated.FailCount = 0
+	case updated.State == models.ProxyStateActive:
+		updated.FailCount++
+		if updated.FailCount >= maxFailCount {
+			p.coolDown(&updated, now)
+		}
+	case success:
+		updated.SuccessCount++
+		if updated.SuccessCount >= p.opts.ReactivateAfter {
+			updated.State = models.ProxyStateActive
+			updated.IsActive = true
+			updated.FailCount = 0
+			updated.Cooldowns = 0
+			updated.SuccessCount = 0
+			updated.NextProbeAt = nil
+		} else {
+			// Probe again as soon as possible
+			updated.State = models.ProxyStateRecovering
+			updated.NextProbeAt = &now
+		}
+	default:
+		updated.FailCount++
+		p.coolDown(&updated, now)
+	}
+
+	p.proxies[id] = &updated
+	p.dirty[id] = struct{}{}
+	p.active = nil
+}
+
+// coolDown takes proxy out of rotation until its next probe, waiting twice
+// as long as the previous time.
+func (p *Pool) coolDown(proxy *models.Proxy, now time.Time) {
+	wait := p.opts.CooldownBase
+	for i := 0; i < proxy.Cooldowns && wait < p.opts.CooldownMax; i++ {
+		wait *= 2
+	}
+	if wait > p.opts.CooldownMax {
+		wait = p.opts.CooldownMax
+	}
+	next := now.Add(wait)
+
+	proxy.State = models.ProxyStateCooldown
+	proxy.IsActive = false
+	proxy.Cooldowns++
+	proxy.SuccessCount = 0
+	proxy.NextProbeAt = &next
+}
+
+// normalizeState fills in the state of proxies stored before states
+// existed, or added without one, and reports whether it changed anything.
+// Inactive proxies are due for a probe right away.
+func normalizeState(proxy *models.Proxy, now time.Time) bool {
+	inactive := !proxy.IsActive || proxy.FailCount >= maxFailCount
+	switch {
+	case proxy.State == "" && !inactive:
+		proxy.State = models.ProxyStateActive
+	case (proxy.State == "" || proxy.State == models.ProxyStateActive) && inactive:
+		proxy.State = models.ProxyStateCooldown
+		proxy.IsActive = false
+		proxy.NextProbeAt = &now
+	default:
+		return false
+	}
+	return true
+}
+
+// Flush writes all pending health updates to the database.
+func (p *Pool) Flush() error {
+	p.mu.Lock()
+	pending := make([]*models.Proxy, 0, len(p.dirty))
+	for id := range p.dirty {
+		if proxy, ok := p.proxies[id]; ok {
+			pending = append(pending, proxy)
+		}
+	}
+	p.dirty = make(map[int]struct{})
+	p.mu.Unlock()
+
+	for start := 0; start < len(pending); start += flushBatchSize {
+		end := start + flushBatchSize
+		if end > len(pending) {
+			end = len(pending)
+		}
+		if err := p.db.SaveProxyHealth(pending[start:end]); err != nil {
+			p.requeue(pending[start:])
+			return err
+		}
+	}
+	return nil
+}
+
+// requeue marks proxies whose updates failed to persist as dirty again,
+// unless they were removed in the meantime.
+func (p *Pool) requeue(proxies []*models.Proxy) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, proxy := range proxies {
+		if _, ok := p.proxies[proxy.ID]; ok {
+			p.dirty[proxy.ID] = struct{}{}
+		}
+	}
+}
+
+// Sync reloads the proxies from the database, adding those other
+// instances added, dropping those they deleted and taking over their label
+// changes. Health stays this instance's own. It returns the IDs of the
+// proxies dropped.
+func (p *Pool) Sync() ([]int, error) {
+	started := time.Now()
+	stored, err := p.db.GetAllProxies()
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	seen := make(map[int]bool, len(stored))
+	for _, proxy := range stored {
+		seen[proxy.ID] = true
+		current, ok := p.proxies[proxy.ID]
+		if !ok {
+			if normalizeState(proxy, started) {
+				p.dirty[proxy.ID] = struct{}{}
+			}
+			p.proxies[proxy.ID] = proxy
+			p.active = nil
+			continue
+		}
+		if current.Country != proxy.Country || !slices.Equal(current.Tags, proxy.Tags) {
+			updated := *current
+			updated.Tags = proxy.Tags
+			updated.Country = proxy.Country
+			p.proxies[proxy.ID] = &updated
+			p.active = nil
+		}
+	}
+
+	var removed []int
+	for id, proxy := range p.proxies {
+		// Proxies added here while the database was read are kept
+		if !seen[id] && proxy.CreatedAt.Before(started) {
+			delete(p.proxies, id)
+			delete(p.dirty, id)
+			removed = append(removed, id)
+		}
+	}
+	if len(removed) > 0 {
+		p.active = nil
+	}
+	return removed, nil
+}
+
+// setRemoveHook registers fn to be called with the proxies a background
+// sync dropped.
+func (p *Pool) setRemoveHook(fn func(id int)) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.onRemove = fn
+}
+
+func (p *Pool) sync() {
+	removed, err := p.Sync()
+	if err != nil {
+		log.Printf("Failed to sync proxies: %v", err)
+		return
+	}
+
+	p.mu.RLock()
+	onRemove := p.onRemove
+	p.mu.RUnlock()
+	if onRemove != nil {
+		for _, id := range removed {
+			onRemove(id)
+		}
+	}
+}
+
+func (p *Pool) flushLoop() {
+	defer close(p.done)
+
+	var flushC <-chan time.Time
+	if p.opts.FlushInterval > 0 {
+		ticker := time.NewTicker(p.opts.FlushInterval)
+		defer ticker.Stop()
+		flushC = ticker.C
+	}
+
+	var syncC <-chan time.Time
+	if p.opts.SyncInterval > 0 {
+		syncTicker := time.NewTicker(p.opts.SyncInterval)
+		defer syncTicker.Stop()
+		syncC = syncTicker.C
+	}
+
+	for {
+		select {
+		case <-flushC:
+			if err := p.Flush(); err != nil {
+				log.Printf("Failed to flush proxy health updates: %v", err)
+			}
+		case <-syncC:
+			p.sync()
+		case <-p.stop:
+			return
+		}
+	}
+}
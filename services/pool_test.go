@@ -0,0 +1,183 @@
+package services
+
+import (
+	"errors"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"go-proxy-rotator/database"
+	"go-proxy-rotator/models"
+)
+
+// flushStore records the proxies each SaveProxyHealth call writes, and
+// fails while fail is set.
+type flushStore struct {
+	database.Store
+
+	mu    sync.Mutex
+	saved [][]int
+	fail  error
+}
+
+func (s *flushStore) SaveProxyHealth(proxies []*models.Proxy) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.fail != nil {
+		return s.fail
+	}
+	ids := make([]int, len(proxies))
+	for i, proxy := range proxies {
+		ids[i] = proxy.ID
+	}
+	slices.Sort(ids)
+	s.saved = append(s.saved, ids)
+	return s.Store.SaveProxyHealth(proxies)
+}
+
+// flushes returns and forgets the writes recorded so far.
+func (s *flushStore) flushes() [][]int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	saved := s.saved
+	s.saved = nil
+	return saved
+}
+
+func TestPoolFlush(t *testing.T) {
+	errWrite := errors.New("write failed")
+	tests := []struct {
+		name string
+		// update changes the pool after proxies 1 to 3 are added
+		update func(t *testing.T, p *Pool, store *flushStore)
+		// flushes are the proxies each flush writes, in order; a nil entry
+		// is a flush that writes nothing
+		flushes [][]int
+		// failed makes the first flush fail
+		failed bool
+		// failCount is proxy 2's stored fail count at the end
+		failCount int
+	}{
+		{
+			name:    "nothing changed",
+			flushes: [][]int{nil},
+		},
+		{
+			name: "only updated proxies are written",
+			update: func(t *testing.T, p *Pool, _ *flushStore) {
+				p.RecordHealth(2, 0, false)
+				p.RecordHealth(3, 120, true)
+			},
+			flushes:   [][]int{{2, 3}, nil},
+			failCount: 1,
+		},
+		{
+			name: "repeated updates are written once",
+			update: func(t *testing.T, p *Pool, _ *flushStore) {
+				p.RecordHealth(2, 0, false)
+				p.RecordHealth(2, 0, false)
+				p.SetAnonymity(2, models.AnonymityElite)
+			},
+			flushes:   [][]int{{2}, nil},
+			failCount: 2,
+		},
+		{
+			name: "removed proxies are dropped",
+			update: func(t *testing.T, p *Pool, _ *flushStore) {
+				p.RecordHealth(1, 0, false)
+				p.RecordHealth(2, 0, false)
+				if err := p.Remove(1); err != nil {
+					t.Fatal(err)
+				}
+			},
+			flushes:   [][]int{{2}, nil},
+			failCount: 1,
+		},
+		{
+			name: "failed writes are retried",
+			update: func(t *testing.T, p *Pool, store *flushStore) {
+				p.RecordHealth(2, 0, false)
+				store.fail = errWrite
+			},
+			failed:    true,
+			flushes:   [][]int{{2}, nil},
+			failCount: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := &flushStore{Store: newTestStore(t)}
+			pool, err := NewPool(store, PoolOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer pool.Close()
+			for i := 1; i <= 3; i++ {
+				proxy := &models.Proxy{Host: "10.0.0.1", Port: 8000 + i, Protocol: "http", IsActive: true}
+				if err := pool.Add(proxy); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if tt.update != nil {
+				tt.update(t, pool, store)
+			}
+
+			if tt.failed {
+				if err := pool.Flush(); !errors.Is(err, errWrite) {
+					t.Fatalf("Flush = %v, want %v", err, errWrite)
+				}
+				store.mu.Lock()
+				store.fail = nil
+				store.mu.Unlock()
+			}
+			var got [][]int
+			for range tt.flushes {
+				if err := pool.Flush(); err != nil {
+					t.Fatal(err)
+				}
+				saved := store.flushes()
+				if len(saved) == 0 {
+					got = append(got, nil)
+					continue
+				}
+				got = append(got, slices.Concat(saved...))
+			}
+			if !slices.EqualFunc(got, tt.flushes, slices.Equal[[]int]) {
+				t.Errorf("flushes wrote %v, want %v", got, tt.flushes)
+			}
+
+			stored, err := store.GetAllProxies()
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, proxy := range stored {
+				if proxy.ID == 2 && proxy.FailCount != tt.failCount {
+					t.Errorf("stored fail count of proxy 2 = %d, want %d", proxy.FailCount, tt.failCount)
+				}
+			}
+		})
+	}
+}
+
+func TestPoolFlushInterval(t *testing.T) {
+	store := &flushStore{Store: newTestStore(t)}
+	pool, err := NewPool(store, PoolOptions{FlushInterval: 20 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Close()
+	proxy := &models.Proxy{Host: "10.0.0.1", Port: 8001, Protocol: "http", IsActive: true}
+	if err := pool.Add(proxy); err != nil {
+		t.Fatal(err)
+	}
+
+	pool.RecordHealth(proxy.ID, 0, false)
+	deadline := time.Now().Add(2 * time.Second)
+	for len(store.flushes()) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("the update was not flushed in the background")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
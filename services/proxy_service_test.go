@@ -0,0 +1,165 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"go-proxy-rotator/models"
+)
+
+// newTestService returns a service over an empty SQLite store holding
+// count active proxies, with IDs 1 to count.
+func newTestService(t *testing.T, strategy string, count int) *ProxyService {
+	t.Helper()
+	db := newTestStore(t)
+	pool, err := NewPool(db, PoolOptions{CooldownBase: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sessions := NewSessionStore(time.Hour)
+	domains, err := NewDomainHealth(db, DomainHealthOptions{MaxFailures: 1, CooldownBase: time.Hour, TTL: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		domains.Close()
+		sessions.Close()
+		pool.Close()
+	})
+
+	s, err := NewProxyService(db, pool, sessions, domains, ServiceOptions{
+		Strategy: strategy,
+		Breaker: BreakerOptions{
+			Window:       time.Minute,
+			MinRequests:  2,
+			ErrorRate:    0.5,
+			OpenDuration: time.Hour,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= count; i++ {
+		proxy := &models.Proxy{Host: "10.0.0.1", Port: 8000 + i, Protocol: "http", IsActive: true}
+		if err := s.AddProxy(proxy); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return s
+}
+
+func TestSelectProxySessions(t *testing.T) {
+	const session = "checkout"
+	tests := []struct {
+		name string
+		// setup runs once the session is pinned to proxy pinned
+		setup func(s *ProxyService, pinned int)
+		// opts returns the options of the next request
+		opts func(pinned int) SelectOptions
+		// served is whether the next request goes through pinned, repinned
+		// whether the session moves off it
+		served   bool
+		repinned bool
+		// requests the session counts after both, fewer when it was dropped
+		requests int64
+	}{
+		{
+			name:     "reused",
+			served:   true,
+			requests: 2,
+		},
+		{
+			name: "excluded after a failed attempt",
+			opts: func(pinned int) SelectOptions {
+				return SelectOptions{Exclude: map[int]bool{pinned: true}}
+			},
+			requests: 2,
+		},
+		{
+			name: "outside the constraints",
+			opts: func(pinned int) SelectOptions {
+				return SelectOptions{ProxyID: pinned%3 + 1}
+			},
+			requests: 2,
+		},
+		{
+			name: "cooling down for the domain",
+			setup: func(s *ProxyService, pinned int) {
+				s.BanForDomain(pinned, "example.com")
+			},
+			opts: func(int) SelectOptions {
+				return SelectOptions{Domain: "example.com"}
+			},
+			requests: 2,
+		},
+		{
+			name: "circuit open",
+			setup: func(s *ProxyService, pinned int) {
+				s.ObserveResult(pinned, time.Millisecond, false)
+				s.ObserveResult(pinned, time.Millisecond, false)
+			},
+			requests: 2,
+		},
+		{
+			name: "unhealthy",
+			setup: func(s *ProxyService, pinned int) {
+				for i := 0; i < maxFailCount; i++ {
+					s.RecordHealth(pinned, 0, false)
+				}
+			},
+			repinned: true,
+			requests: 2,
+		},
+		{
+			// Deleting a proxy drops its sessions
+			name: "deleted",
+			setup: func(s *ProxyService, pinned int) {
+				if err := s.DeleteProxy(pinned); err != nil {
+					panic(err)
+				}
+			},
+			repinned: true,
+			requests: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestService(t, StrategyRoundRobin, 3)
+			first, err := s.SelectProxy(SelectOptions{Session: session, Exclude: map[int]bool{}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			pinned := first.ID
+			if tt.setup != nil {
+				tt.setup(s, pinned)
+			}
+
+			opts := SelectOptions{}
+			if tt.opts != nil {
+				opts = tt.opts(pinned)
+			}
+			opts.Session = session
+			got, err := s.SelectProxy(opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if served := got.ID == pinned; served != tt.served {
+				t.Errorf("request went through proxy %d, pinned %d; want through the pinned one: %v", got.ID, pinned, tt.served)
+			}
+
+			current, ok := s.Sessions.Get(session)
+			if !ok {
+				t.Fatal("session is gone")
+			}
+			if repinned := current.ProxyID != pinned; repinned != tt.repinned {
+				t.Errorf("session pinned to %d, was %d; want re-pinned: %v", current.ProxyID, pinned, tt.repinned)
+			}
+			if tt.repinned && current.ProxyID != got.ID {
+				t.Errorf("session re-pinned to %d, but the request went through %d", current.ProxyID, got.ID)
+			}
+			if current.Requests != tt.requests {
+				t.Errorf("session requests = %d, want %d", current.Requests, tt.requests)
+			}
+		})
+	}
+}
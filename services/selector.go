@@ -0,0 +1,196 @@
+package services
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"sync/atomic"
+
+	"go-proxy-rotator/models"
+)
+
+// Selection strategy names accepted in configuration and per request.
+const (
+	StrategyRandom     = "random"
+	StrategyRoundRobin = "round-robin"
+	StrategyWeighted   = "weighted"
+	StrategyLeastConn  = "least-conn"
+	StrategyP2C        = "p2c"
+	StrategyEWMA       = "ewma"
+)
+
+// defaultLatencyMs is assumed for proxies that have never been measured.
+const defaultLatencyMs = 1000
+
+// Selector picks one proxy out of a non-empty list of candidates.
+type Selector interface {
+	Select(candidates []*models.Proxy) *models.Proxy
+}
+
+// Strategies lists the built-in selection strategies.
+func Strategies() []string {
+	return []string{StrategyRandom, StrategyRoundRobin, StrategyWeighted,
+		StrategyLeastConn, StrategyP2C, StrategyEWMA}
+}
+
+// newSelector builds the named strategy on top of the shared usage stats.
+func newSelector(name string, usage *usageTracker) (Selector, error) {
+	switch name {
+	case StrategyRandom:
+		return randomSelector{}, nil
+	case StrategyRoundRobin:
+		return &roundRobinSelector{}, nil
+	case StrategyWeighted:
+		return weightedSelector{usage: usage}, nil
+	case StrategyLeastConn:
+		return leastConnSelector{usage: usage}, nil
+	case StrategyP2C:
+		return p2cSelector{usage: usage}, nil
+	case StrategyEWMA:
+		return ewmaSelector{usage: usage}, nil
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnknownStrategy, name)
+	}
+}
+
+// latencyOf estimates a proxy's latency in milliseconds, preferring live
+// traffic measurements over the last health check result.
+func latencyOf(p *models.Proxy, usage *usageTracker) float64 {
+	if _, ewma := usage.snapshot(p.ID); ewma > 0 {
+		return ewma
+	}
+	if p.ResponseTime > 0 {
+		return float64(p.ResponseTime)
+	}
+	return defaultLatencyMs
+}
+
+// randomSelector picks uniformly at random.
+type randomSelector struct{}
+
+func (randomSelector) Select(candidates []*models.Proxy) *models.Proxy {
+	return candidates[rand.Intn(len(candidates))]
+}
+
+// roundRobinSelector cycles through the candidates in ID order.
+type roundRobinSelector struct {
+	next atomic.Uint64
+}
+
+func (s *roundRobinSelector) Select(candidates []*models.Proxy) *models.Proxy {
+	sorted := make([]*models.Proxy, len(candidates))
+	copy(sorted, candidates)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].ID < sorted[j].ID })
+
+	n := s.next.Add(1) - 1
+	return sorted[n%uint64(len(sorted))]
+}
+
+// weightedSelector picks at random with probability proportional to the
+// inverse of each proxy's latency.
+type weightedSelector struct {
+	usage *usageTracker
+}
+
+func (s weightedSelector) Select(candidates []*models.Proxy) *models.Proxy {
+	weights := make([]float64, len(candidates))
+	total := 0.0
+	for i, p := range candidates {
+		latency := latencyOf(p, s.usage)
+		if latency < 1 {
+			latency = 1
+		}
+		weights[i] = 1 / latency
+		total += weights[i]
+	}
+
+	r := rand.Float64() * total
+	for i, w := range weights {
+		if r < w {
+			return candidates[i]
+		}
+		r -= w
+	}
+	return candidates[len(candidates)-1]
+}
+
+// leastConnSelector picks the proxy with the fewest requests in flight,
+// breaking ties at random.
+type leastConnSelector struct {
+	usage *usageTracker
+}
+
+func (s leastConnSelector) Select(candidates []*models.Proxy) *models.Proxy {
+	var best []*models.Proxy
+	bestCount := -1
+	for _, p := range candidates {
+		inFlight, _ := s.usage.snapshot(p.ID)
+		switch {
+		case bestCount < 0 || inFlight < bestCount:
+			best = append(best[:0], p)
+			bestCount = inFlight
+		case inFlight == bestCount:
+			best = append(best, p)
+		}
+	}
+	return best[rand.Intn(len(best))]
+}
+
+// p2cSelector samples two candidates at random and keeps the one with
+// fewer requests in flight, falling back to lower latency on a tie.
+type p2cSelector struct {
+	usage *usageTracker
+}
+
+func (s p2cSelector) Select(candidates []*models.Proxy) *models.Proxy {
+	if len(candidates) == 1 {
+		return candidates[0]
+	}
+	i := rand.Intn(len(candidates))
+	j := rand.Intn(len(candidates) - 1)
+	if j >= i {
+		j++
+	}
+	a, b := candidates[i], candidates[j]
+
+	aInFlight, _ := s.usage.snapshot(a.ID)
+	bInFlight, _ := s.usage.snapshot(b.ID)
+	switch {
+	case aInFlight < bInFlight:
+		return a
+	case bInFlight < aInFlight:
+		return b
+	case latencyOf(b, s.usage) < latencyOf(a, s.usage):
+		return b
+	default:
+		return a
+	}
+}
+
+// ewmaSelector picks the proxy with the lowest moving-average latency,
+// scaled by its in-flight load. Proxies without live samples score zero so
+// that every proxy gets measured before the ranking settles.
+type ewmaSelector struct {
+	usage *usageTracker
+}
+
+func (s ewmaSelector) Select(candidates []*models.Proxy) *models.Proxy {
+	var best []*models.Proxy
+	bestScore := -1.0
+	for _, p := range candidates {
+		inFlight, ewma := s.usage.snapshot(p.ID)
+		if ewma == 0 && inFlight > 0 {
+			// Being measured right now; don't pile more requests on it.
+			ewma = defaultLatencyMs
+		}
+		score := ewma * float64(inFlight+1)
+		switch {
+		case bestScore < 0 || score < bestScore:
+			best = append(best[:0], p)
+			bestScore = score
+		case score == bestScore:
+			best = append(best, p)
+		}
+	}
+	return best[rand.Intn(len(best))]
+}
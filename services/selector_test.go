@@ -0,0 +1,121 @@
+package services
+
+import (
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"go-proxy-rotator/models"
+)
+
+func TestSelectors(t *testing.T) {
+	tests := []struct {
+		name     string
+		strategy string
+		// latencies are the last health check results of proxies 1..n, in
+		// milliseconds; zero for never checked
+		latencies []int
+		inFlight  map[int]int
+		observed  map[int]time.Duration
+		order     []int // candidate order, by ID; 1..n when empty
+
+		picked   []int           // every proxy picked, and only those
+		sequence []int           // the exact picks, in order
+		minShare map[int]float64 // lower bounds on the share of picks
+	}{
+		{name: "random picks every proxy", strategy: StrategyRandom, latencies: []int{100, 100, 100},
+			picked: []int{1, 2, 3}, minShare: map[int]float64{1: 0.2, 2: 0.2, 3: 0.2}},
+		{name: "round-robin cycles in ID order", strategy: StrategyRoundRobin, latencies: []int{0, 0, 0},
+			order: []int{3, 1, 2}, sequence: []int{1, 2, 3, 1, 2, 3, 1}},
+		{name: "weighted favours fast proxies", strategy: StrategyWeighted, latencies: []int{100, 1000},
+			picked: []int{1, 2}, minShare: map[int]float64{1: 0.85, 2: 0.04}},
+		{name: "weighted prefers live latency", strategy: StrategyWeighted, latencies: []int{100, 1000},
+			observed: map[int]time.Duration{1: time.Second, 2: 100 * time.Millisecond},
+			picked:   []int{1, 2}, minShare: map[int]float64{2: 0.85}},
+		{name: "least-conn skips busy proxies", strategy: StrategyLeastConn, latencies: []int{100, 100, 100},
+			inFlight: map[int]int{1: 2, 2: 1}, picked: []int{3}},
+		{name: "least-conn spreads ties", strategy: StrategyLeastConn, latencies: []int{100, 100, 100},
+			inFlight: map[int]int{1: 1}, picked: []int{2, 3}},
+		{name: "p2c never picks the busiest", strategy: StrategyP2C, latencies: []int{100, 100, 100},
+			inFlight: map[int]int{1: 5}, picked: []int{2, 3}},
+		{name: "p2c breaks ties by latency", strategy: StrategyP2C, latencies: []int{500, 100},
+			picked: []int{2}},
+		{name: "p2c with one candidate", strategy: StrategyP2C, latencies: []int{100},
+			picked: []int{1}},
+		{name: "ewma tries unmeasured proxies first", strategy: StrategyEWMA, latencies: []int{100, 100, 100},
+			observed: map[int]time.Duration{1: 300 * time.Millisecond, 2: 100 * time.Millisecond}, picked: []int{3}},
+		{name: "ewma picks the lowest latency", strategy: StrategyEWMA, latencies: []int{100, 100},
+			observed: map[int]time.Duration{1: 300 * time.Millisecond, 2: 100 * time.Millisecond}, picked: []int{2}},
+		{name: "ewma scales latency by load", strategy: StrategyEWMA, latencies: []int{100, 100},
+			observed: map[int]time.Duration{1: 300 * time.Millisecond, 2: 100 * time.Millisecond},
+			inFlight: map[int]int{2: 3}, picked: []int{1}},
+		{name: "ewma spares proxies being measured", strategy: StrategyEWMA, latencies: []int{100, 100},
+			observed: map[int]time.Duration{1: 300 * time.Millisecond}, inFlight: map[int]int{2: 1}, picked: []int{1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			usage := newUsageTracker()
+			for id, n := range tt.inFlight {
+				for range n {
+					usage.acquire(id)
+				}
+			}
+			for id, latency := range tt.observed {
+				usage.observe(id, latency)
+			}
+			selector, err := newSelector(tt.strategy, usage)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			order := tt.order
+			if order == nil {
+				for i := range tt.latencies {
+					order = append(order, i+1)
+				}
+			}
+			candidates := make([]*models.Proxy, len(order))
+			for i, id := range order {
+				candidates[i] = &models.Proxy{ID: id, ResponseTime: tt.latencies[id-1]}
+			}
+
+			rounds := 2000
+			if tt.sequence != nil {
+				rounds = len(tt.sequence)
+			}
+			var sequence []int
+			counts := make(map[int]int)
+			for range rounds {
+				id := selector.Select(candidates).ID
+				sequence = append(sequence, id)
+				counts[id]++
+			}
+
+			if tt.sequence != nil && !slices.Equal(sequence, tt.sequence) {
+				t.Errorf("picks = %v, want %v", sequence, tt.sequence)
+			}
+			if tt.picked != nil {
+				var picked []int
+				for id := range counts {
+					picked = append(picked, id)
+				}
+				slices.Sort(picked)
+				if !slices.Equal(picked, tt.picked) {
+					t.Errorf("picked %v (counts %v), want %v", picked, counts, tt.picked)
+				}
+			}
+			for id, want := range tt.minShare {
+				if share := float64(counts[id]) / float64(rounds); share < want {
+					t.Errorf("proxy %d got %.2f of the picks, want at least %.2f", id, share, want)
+				}
+			}
+		})
+	}
+}
+
+func TestNewSelectorUnknownStrategy(t *testing.T) {
+	if _, err := newSelector("fastest", newUsageTracker()); !errors.Is(err, ErrUnknownStrategy) {
+		t.Errorf("newSelector(fastest) error = %v, want ErrUnknownStrategy", err)
+	}
+}
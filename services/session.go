@@ -0,0 +1,201 @@
+package services
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"go-proxy-rotator/models"
+)
+
+// SessionStore keeps sticky session pins in memory. A session expires
+// once it has not been used for the TTL; every request through it extends
+// the deadline.
+type SessionStore struct {
+	ttl time.Duration
+
+	mu       sync.Mutex
+	sessions map[string]*models.Session
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// NewSessionStore creates a store whose sessions live for ttl after their
+// last use, and starts a background sweep of expired sessions.
+func NewSessionStore(ttl time.Duration) *SessionStore {
+	s := &SessionStore{
+		ttl:      ttl,
+		sessions: make(map[string]*models.Session),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go s.sweepLoop()
+	return s
+}
+
+// Close stops the background sweep.
+func (s *SessionStore) Close() {
+	close(s.stop)
+	<-s.done
+}
+
+// TTL returns how long an idle session is kept.
+func (s *SessionStore) TTL() time.Duration {
+	return s.ttl
+}
+
+// lookup returns the proxy pinned to session id, if the session exists and
+// has not expired.
+func (s *SessionStore) lookup(id string) (int, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, ok := s.sessions[id]
+	if !ok {
+		return 0, false
+	}
+	if time.Now().After(session.ExpiresAt) {
+		delete(s.sessions, id)
+		return 0, false
+	}
+	return session.ProxyID, true
+}
+
+// pin points session id at proxyID, unless it has been re-pinned to a
+// proxy other than stale since lookup, and returns the proxy the session
+// ends up pinned to. stale is zero when there was no pin before.
+func (s *SessionStore) pin(id string, proxyID, stale int) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	session, ok := s.sessions[id]
+	if ok && now.Before(session.ExpiresAt) && session.ProxyID != stale {
+		return session.ProxyID
+	}
+	if !ok || now.After(session.ExpiresAt) {
+		session = &models.Session{ID: id, CreatedAt: now}
+	} else {
+		// Re-pinned after the proxy went bad; start a fresh copy so
+		// readers holding the old value don't see it change.
+		copied := *session
+		session = &copied
+	}
+	session.ProxyID = proxyID
+	session.LastUsed = now
+	session.ExpiresAt = now.Add(s.ttl)
+	s.sessions[id] = session
+	return proxyID
+}
+
+// touch records a request through session id and extends its deadline.
+func (s *SessionStore) touch(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	current, ok := s.sessions[id]
+	if !ok {
+		return
+	}
+	updated := *current
+	now := time.Now()
+	updated.Requests++
+	updated.LastUsed = now
+	updated.ExpiresAt = now.Add(s.ttl)
+	s.sessions[id] = &updated
+}
+
+// Get returns the session with the given ID.
+func (s *SessionStore) Get(id string) (*models.Session, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, ok := s.sessions[id]
+	if !ok || time.Now().After(session.ExpiresAt) {
+		return nil, false
+	}
+	return session, true
+}
+
+// List returns the live sessions, most recently used first.
+func (s *SessionStore) List() []*models.Session {
+	s.mu.Lock()
+	now := time.Now()
+	sessions := make([]*models.Session, 0, len(s.sessions))
+	for _, session := range s.sessions {
+		if now.Before(session.ExpiresAt) {
+			sessions = append(sessions, session)
+		}
+	}
+	s.mu.Unlock()
+
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].LastUsed.After(sessions[j].LastUsed)
+	})
+	return sessions
+}
+
+// Delete revokes a session. The next request with that ID is pinned anew.
+func (s *SessionStore) Delete(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, ok := s.sessions[id]
+	delete(s.sessions, id)
+	return ok && time.Now().Before(session.ExpiresAt)
+}
+
+// Clear revokes every session and returns how many were removed.
+func (s *SessionStore) Clear() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n := len(s.sessions)
+	s.sessions = make(map[string]*models.Session)
+	return n
+}
+
+// dropProxy revokes the sessions pinned to a deleted proxy.
+func (s *SessionStore) dropProxy(proxyID int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for id, session := range s.sessions {
+		if session.ProxyID == proxyID {
+			delete(s.sessions, id)
+		}
+	}
+}
+
+func (s *SessionStore) sweep() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for id, session := range s.sessions {
+		if now.After(session.ExpiresAt) {
+			delete(s.sessions, id)
+		}
+	}
+}
+
+func (s *SessionStore) sweepLoop() {
+	defer close(s.done)
+
+	interval := s.ttl
+	if interval > time.Minute || interval <= 0 {
+		interval = time.Minute
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.sweep()
+		case <-s.stop:
+			return
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package services
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSessionStore(t *testing.T) {
+	tests := []struct {
+		name string
+		ttl  time.Duration
+		run  func(t *testing.T, s *SessionStore)
+		// want maps the sessions that are live at the end to their proxy
+		want map[string]int
+		// requests is how many requests session "a" counts at the end
+		requests int64
+	}{
+		{
+			name: "pinned",
+			run: func(t *testing.T, s *SessionStore) {
+				if got := s.pin("a", 1, 0); got != 1 {
+					t.Errorf("pin = %d, want 1", got)
+				}
+			},
+			want: map[string]int{"a": 1},
+		},
+		{
+			name: "concurrent first pins agree",
+			run: func(t *testing.T, s *SessionStore) {
+				s.pin("a", 1, 0)
+				if got := s.pin("a", 2, 0); got != 1 {
+					t.Errorf("second pin = %d, want the first one's 1", got)
+				}
+			},
+			want: map[string]int{"a": 1},
+		},
+		{
+			name: "re-pinned off a stale proxy",
+			run: func(t *testing.T, s *SessionStore) {
+				s.pin("a", 1, 0)
+				s.touch("a")
+				if got := s.pin("a", 2, 1); got != 2 {
+					t.Errorf("re-pin = %d, want 2", got)
+				}
+			},
+			want:     map[string]int{"a": 2},
+			requests: 1,
+		},
+		{
+			name: "concurrent re-pins agree",
+			run: func(t *testing.T, s *SessionStore) {
+				s.pin("a", 1, 0)
+				s.pin("a", 2, 1)
+				if got := s.pin("a", 3, 1); got != 2 {
+					t.Errorf("second re-pin = %d, want the first one's 2", got)
+				}
+			},
+			want: map[string]int{"a": 2},
+		},
+		{
+			name: "requests are counted",
+			run: func(t *testing.T, s *SessionStore) {
+				s.pin("a", 1, 0)
+				s.touch("a")
+				s.touch("a")
+				s.touch("missing")
+			},
+			want:     map[string]int{"a": 1},
+			requests: 2,
+		},
+		{
+			name: "expired",
+			ttl:  20 * time.Millisecond,
+			run: func(t *testing.T, s *SessionStore) {
+				s.pin("a", 1, 0)
+				s.pin("b", 2, 0)
+				time.Sleep(40 * time.Millisecond)
+				// An expired session is pinned anew, whatever it pointed at
+				if got := s.pin("b", 3, 0); got != 3 {
+					t.Errorf("pin after expiry = %d, want 3", got)
+				}
+			},
+			want: map[string]int{"b": 3},
+		},
+		{
+			name: "use extends the deadline",
+			ttl:  60 * time.Millisecond,
+			run: func(t *testing.T, s *SessionStore) {
+				s.pin("a", 1, 0)
+				for range 3 {
+					time.Sleep(30 * time.Millisecond)
+					s.touch("a")
+				}
+			},
+			want:     map[string]int{"a": 1},
+			requests: 3,
+		},
+		{
+			name: "dropped with their proxy",
+			run: func(t *testing.T, s *SessionStore) {
+				s.pin("a", 1, 0)
+				s.pin("b", 2, 0)
+				s.pin("c", 1, 0)
+				s.dropProxy(1)
+			},
+			want: map[string]int{"b": 2},
+		},
+		{
+			name: "deleted",
+			run: func(t *testing.T, s *SessionStore) {
+				s.pin("a", 1, 0)
+				s.pin("b", 2, 0)
+				if !s.Delete("a") {
+					t.Error("Delete(a) = false, want true")
+				}
+				if s.Delete("a") {
+					t.Error("second Delete(a) = true, want false")
+				}
+			},
+			want: map[string]int{"b": 2},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ttl := tt.ttl
+			if ttl == 0 {
+				ttl = time.Hour
+			}
+			s := NewSessionStore(ttl)
+			defer s.Close()
+
+			tt.run(t, s)
+
+			for _, id := range []string{"a", "b", "c"} {
+				want, live := tt.want[id]
+				proxyID, ok := s.lookup(id)
+				if ok != live || proxyID != want {
+					t.Errorf("lookup(%s) = %d, %v; want %d, %v", id, proxyID, ok, want, live)
+				}
+			}
+			if list := s.List(); len(list) != len(tt.want) {
+				t.Errorf("List returned %d sessions, want %d", len(list), len(tt.want))
+			}
+			if session, ok := s.Get("a"); ok && session.Requests != tt.requests {
+				t.Errorf("session a counts %d requests, want %d", session.Requests, tt.requests)
+			}
+		})
+	}
+}
@@ -0,0 +1,85 @@
+package services
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// ewmaAlpha weights the newest latency sample in the moving average.
+	ewmaAlpha = 0.3
+	// failurePenalty is fed into the latency average when a request
+	// through a proxy fails, so failing proxies sink in latency rankings.
+	failurePenalty = 10 * time.Second
+)
+
+// usageTracker keeps live, in-memory statistics per proxy that selection
+// strategies use: requests currently in flight and an exponentially
+// weighted moving average of observed latency.
+type usageTracker struct {
+	mu    sync.Mutex
+	stats map[int]*proxyUsage
+}
+
+type proxyUsage struct {
+	inFlight int
+	ewma     float64 // milliseconds; zero until the first sample
+}
+
+func newUsageTracker() *usageTracker {
+	return &usageTracker{stats: make(map[int]*proxyUsage)}
+}
+
+func (t *usageTracker) get(id int) *proxyUsage {
+	u, ok := t.stats[id]
+	if !ok {
+		u = &proxyUsage{}
+		t.stats[id] = u
+	}
+	return u
+}
+
+func (t *usageTracker) acquire(id int) {
+	t.mu.Lock()
+	t.get(id).inFlight++
+	t.mu.Unlock()
+}
+
+func (t *usageTracker) release(id int) {
+	t.mu.Lock()
+	if u := t.get(id); u.inFlight > 0 {
+		u.inFlight--
+	}
+	t.mu.Unlock()
+}
+
+func (t *usageTracker) observe(id int, latency time.Duration) {
+	ms := float64(latency.Milliseconds())
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	u := t.get(id)
+	if u.ewma == 0 {
+		u.ewma = ms
+	} else {
+		u.ewma = ewmaAlpha*ms + (1-ewmaAlpha)*u.ewma
+	}
+}
+
+// snapshot returns the in-flight count and latency average for id.
+func (t *usageTracker) snapshot(id int) (inFlight int, ewma float64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if u, ok := t.stats[id]; ok {
+		return u.inFlight, u.ewma
+	}
+	return 0, 0
+}
+
+func (t *usageTracker) forget(id int) {
+	t.mu.Lock()
+	delete(t.stats, id)
+	t.mu.Unlock()
+}
@@ -0,0 +1,257 @@
+package socks
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"time"
+)
+
+// Dialer opens connections through a SOCKS proxy. With "socks4a" and
+// "socks5h" host names are sent to the proxy unresolved, so DNS lookups
+// happen on the proxy side; "socks4" and "socks5" resolve them locally and
+// send the address.
+type Dialer struct {
+	// Protocol is one of "socks4", "socks4a", "socks5" or "socks5h".
+	Protocol string
+	// ProxyAddr is the "host:port" of the SOCKS proxy.
+	ProxyAddr string
+	// Username and Password authenticate against SOCKS5 proxies (RFC 1929).
+	// For SOCKS4 the username is sent as the user ID.
+	Username string
+	Password string
+	// Timeout bounds connecting to the proxy and the SOCKS handshake.
+	Timeout time.Duration
+}
+
+// NewDialer returns a dialer for the given SOCKS protocol variant.
+func NewDialer(protocol, proxyAddr, username, password string, timeout time.Duration) (*Dialer, error) {
+	if !IsSOCKS(protocol) {
+		return nil, fmt.Errorf("socks: unsupported protocol %q", protocol)
+	}
+	return &Dialer{
+		Protocol:  protocol,
+		ProxyAddr: proxyAddr,
+		Username:  username,
+		Password:  password,
+		Timeout:   timeout,
+	}, nil
+}
+
+// DialContext connects to addr through the proxy. Only TCP is supported.
+func (d *Dialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	switch network {
+	case "tcp", "tcp4", "tcp6":
+	default:
+		return nil, fmt.Errorf("socks: network %q not supported", network)
+	}
+
+	host, port, err := splitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	if d.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, d.Timeout)
+		defer cancel()
+	}
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", d.ProxyAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	// Interrupt the handshake when the context is done.
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	stop := context.AfterFunc(ctx, func() {
+		conn.SetDeadline(time.Unix(1, 0))
+	})
+	defer stop()
+
+	switch d.Protocol {
+	case "socks4", "socks4a":
+		err = d.connect4(ctx, conn, host, port)
+	default:
+		err = d.connect5(ctx, conn, host, port)
+	}
+	if err != nil {
+		conn.Close()
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, ctxErr
+		}
+		return nil, err
+	}
+
+	if !stop() {
+		conn.Close()
+		return nil, ctx.Err()
+	}
+	conn.SetDeadline(time.Time{})
+	return conn, nil
+}
+
+// connect5 performs method negotiation, optional username/password
+// authentication and a CONNECT request (RFC 1928 / RFC 1929).
+func (d *Dialer) connect5(ctx context.Context, conn net.Conn, host string, port int) error {
+	addr := host
+	if d.Protocol == "socks5" && net.ParseIP(host) == nil {
+		addrs, err := net.DefaultResolver.LookupIP(ctx, "ip", host)
+		if err != nil {
+			return fmt.Errorf("socks: resolving %s: %w", host, err)
+		}
+		if len(addrs) == 0 {
+			return fmt.Errorf("socks: no address for %s", host)
+		}
+		// Prefer IPv4, not every proxy has IPv6 connectivity
+		addr = addrs[0].String()
+		for _, ip := range addrs {
+			if ip.To4() != nil {
+				addr = ip.String()
+				break
+			}
+		}
+	}
+
+	methods := []byte{authNone}
+	if d.Username != "" || d.Password != "" {
+		methods = []byte{authUserPass, authNone}
+	}
+	greeting := append([]byte{version5, byte(len(methods))}, methods...)
+	if _, err := conn.Write(greeting); err != nil {
+		return err
+	}
+
+	var choice [2]byte
+	if _, err := io.ReadFull(conn, choice[:]); err != nil {
+		return fmt.Errorf("socks: reading method selection: %w", err)
+	}
+	if choice[0] != version5 {
+		return fmt.Errorf("socks: unexpected protocol version %d", choice[0])
+	}
+
+	switch choice[1] {
+	case authNone:
+	case authUserPass:
+		if err := d.authenticate(conn); err != nil {
+			return err
+		}
+	case authNoAcceptable:
+		return fmt.Errorf("socks: no acceptable authentication methods")
+	default:
+		return fmt.Errorf("socks: unsupported authentication method %d", choice[1])
+	}
+
+	req := []byte{version5, cmdConnect, 0x00}
+	req, err := appendAddr(req, addr, port)
+	if err != nil {
+		return err
+	}
+	if _, err := conn.Write(req); err != nil {
+		return err
+	}
+
+	var reply [4]byte
+	if _, err := io.ReadFull(conn, reply[:]); err != nil {
+		return fmt.Errorf("socks: reading reply: %w", err)
+	}
+	if reply[0] != version5 {
+		return fmt.Errorf("socks: unexpected protocol version %d", reply[0])
+	}
+	if reply[1] != ReplySucceeded {
+		return &replyError{target: net.JoinHostPort(host, fmt.Sprint(port)), code: reply[1]}
+	}
+	// Discard BND.ADDR and BND.PORT.
+	if _, err := readAddr(conn, reply[3]); err != nil {
+		return fmt.Errorf("socks: reading bound address: %w", err)
+	}
+	return nil
+}
+
+// authenticate runs the username/password sub-negotiation (RFC 1929).
+func (d *Dialer) authenticate(conn net.Conn) error {
+	if len(d.Username) > 255 || len(d.Password) > 255 {
+		return fmt.Errorf("socks: username or password too long")
+	}
+	req := []byte{userPassVersion, byte(len(d.Username))}
+	req = append(req, d.Username...)
+	req = append(req, byte(len(d.Password)))
+	req = append(req, d.Password...)
+	if _, err := conn.Write(req); err != nil {
+		return err
+	}
+
+	var resp [2]byte
+	if _, err := io.ReadFull(conn, resp[:]); err != nil {
+		return fmt.Errorf("socks: reading authentication status: %w", err)
+	}
+	if resp[1] != 0x00 {
+		return ErrAuthFailed
+	}
+	return nil
+}
+
+// connect4 sends a SOCKS4 or SOCKS4a CONNECT request.
+func (d *Dialer) connect4(ctx context.Context, conn net.Conn, host string, port int) error {
+	req := []byte{version4, cmdConnect}
+	req = binary.BigEndian.AppendUint16(req, uint16(port))
+
+	ip := net.ParseIP(host).To4()
+	remoteHost := ""
+	switch {
+	case ip != nil:
+		req = append(req, ip...)
+	case d.Protocol == "socks4a":
+		// 0.0.0.x tells the proxy to resolve the host name that follows.
+		req = append(req, 0, 0, 0, 1)
+		remoteHost = host
+	default:
+		addrs, err := net.DefaultResolver.LookupIP(ctx, "ip4", host)
+		if err != nil {
+			return fmt.Errorf("socks: resolving %s: %w", host, err)
+		}
+		if len(addrs) == 0 {
+			return fmt.Errorf("socks: no IPv4 address for %s", host)
+		}
+		req = append(req, addrs[0].To4()...)
+	}
+
+	req = append(req, d.Username...)
+	req = append(req, 0)
+	if remoteHost != "" {
+		req = append(req, remoteHost...)
+		req = append(req, 0)
+	}
+	if _, err := conn.Write(req); err != nil {
+		return err
+	}
+
+	var reply [8]byte
+	if _, err := io.ReadFull(conn, reply[:]); err != nil {
+		return fmt.Errorf("socks: reading reply: %w", err)
+	}
+	if reply[1] != socks4Granted {
+		return fmt.Errorf("socks: connect to %s rejected (code %#x)", net.JoinHostPort(host, fmt.Sprint(port)), reply[1])
+	}
+	return nil
+}
+
+// replyError is a SOCKS5 proxy's refusal of a CONNECT request. The server
+// passes its code on to its own client.
+type replyError struct {
+	target string
+	code   byte
+}
+
+func (e *replyError) Error() string {
+	msg, ok := replyMessages[e.code]
+	if !ok {
+		msg = fmt.Sprintf("unknown reply code %d", e.code)
+	}
+	return fmt.Sprintf("socks: connect to %s failed: %s", e.target, msg)
+}
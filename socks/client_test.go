@@ -0,0 +1,316 @@
+package socks
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// step is one exchange of a stub SOCKS server: it reads exactly expect,
+// then writes reply.
+type step struct {
+	expect []byte
+	reply  []byte
+}
+
+// stubServer returns the client end of a pipe whose other end plays steps.
+// The test fails if the client sends anything other than what the steps
+// expect.
+func stubServer(t *testing.T, steps []step) net.Conn {
+	t.Helper()
+	client, server := net.Pipe()
+	client.SetDeadline(time.Now().Add(5 * time.Second))
+	server.SetDeadline(time.Now().Add(5 * time.Second))
+
+	done := make(chan error, 1)
+	go func() {
+		defer server.Close()
+		for i, s := range steps {
+			got := make([]byte, len(s.expect))
+			if _, err := io.ReadFull(server, got); err != nil {
+				done <- fmt.Errorf("step %d: reading % x: %w", i, s.expect, err)
+				return
+			}
+			if !bytes.Equal(got, s.expect) {
+				done <- fmt.Errorf("step %d: client sent % x, want % x", i, got, s.expect)
+				return
+			}
+			if _, err := server.Write(s.reply); err != nil {
+				done <- fmt.Errorf("step %d: writing % x: %w", i, s.reply, err)
+				return
+			}
+		}
+		done <- nil
+	}()
+	t.Cleanup(func() {
+		client.Close()
+		if err := <-done; err != nil {
+			t.Error(err)
+		}
+	})
+	return client
+}
+
+func concat(parts ...[]byte) []byte {
+	return bytes.Join(parts, nil)
+}
+
+type connect5Test struct {
+	name     string
+	protocol string // "socks5h" when empty
+	username string
+	password string
+	host     string
+	port     int
+	steps    []step
+	wantErr  string
+}
+
+func TestConnect5(t *testing.T) {
+	noAuth := step{expect: []byte{version5, 1, authNone}, reply: []byte{version5, authNone}}
+	offerAuth := []byte{version5, 2, authUserPass, authNone}
+	credentials := concat([]byte{userPassVersion, 4}, []byte("user"), []byte{6}, []byte("secret"))
+	connectIPv4 := []byte{version5, cmdConnect, 0, atypIPv4, 10, 0, 0, 1, 0, 80}
+	connectDomain := concat([]byte{version5, cmdConnect, 0, atypDomain, 11}, []byte("example.com"), []byte{1, 187})
+	bound := []byte{version5, ReplySucceeded, 0, atypIPv4, 127, 0, 0, 1, 0x1f, 0x90}
+
+	tests := []connect5Test{
+		{
+			name: "no authentication",
+			host: "10.0.0.1", port: 80,
+			steps: []step{noAuth, {expect: connectIPv4, reply: bound}},
+		},
+		{
+			name: "host name sent unresolved",
+			host: "example.com", port: 443,
+			steps: []step{noAuth, {
+				expect: connectDomain,
+				reply:  concat([]byte{version5, ReplySucceeded, 0, atypDomain, 5}, []byte("proxy"), []byte{0, 1}),
+			}},
+		},
+		{
+			name:     "host name resolved locally",
+			protocol: "socks5",
+			host:     "localhost", port: 443,
+			steps: []step{noAuth, {expect: []byte{version5, cmdConnect, 0, atypIPv4, 127, 0, 0, 1, 1, 187}, reply: bound}},
+		},
+		{
+			name:     "host name resolved by the proxy",
+			protocol: "socks5h",
+			host:     "localhost", port: 443,
+			steps: []step{noAuth, {expect: concat([]byte{version5, cmdConnect, 0, atypDomain, 9}, []byte("localhost"), []byte{1, 187}), reply: bound}},
+		},
+		{
+			name:     "unknown host resolved locally",
+			protocol: "socks5",
+			host:     "nowhere.invalid", port: 443,
+			wantErr: "resolving nowhere.invalid",
+		},
+		{
+			name: "IPv6 target",
+			host: "2001:db8::1", port: 80,
+			steps: []step{noAuth, {
+				expect: concat([]byte{version5, cmdConnect, 0, atypIPv6}, net.ParseIP("2001:db8::1").To16(), []byte{0, 80}),
+				reply:  concat([]byte{version5, ReplySucceeded, 0, atypIPv6}, net.IPv6loopback, []byte{0, 80}),
+			}},
+		},
+		{
+			name:     "authentication required",
+			username: "user", password: "secret",
+			host: "example.com", port: 443,
+			steps: []step{
+				{expect: offerAuth, reply: []byte{version5, authUserPass}},
+				{expect: credentials, reply: []byte{userPassVersion, 0}},
+				{expect: connectDomain, reply: bound},
+			},
+		},
+		{
+			name:     "credentials offered but not required",
+			username: "user", password: "secret",
+			host: "10.0.0.1", port: 80,
+			steps: []step{
+				{expect: offerAuth, reply: []byte{version5, authNone}},
+				{expect: connectIPv4, reply: bound},
+			},
+		},
+		{
+			name:     "credentials rejected",
+			username: "user", password: "secret",
+			host: "10.0.0.1", port: 80,
+			steps: []step{
+				{expect: offerAuth, reply: []byte{version5, authUserPass}},
+				{expect: credentials, reply: []byte{userPassVersion, 1}},
+			},
+			wantErr: ErrAuthFailed.Error(),
+		},
+		{
+			name: "authentication required without credentials",
+			host: "10.0.0.1", port: 80,
+			steps:   []step{{expect: []byte{version5, 1, authNone}, reply: []byte{version5, authNoAcceptable}}},
+			wantErr: "no acceptable authentication methods",
+		},
+		{
+			name: "unsupported method",
+			host: "10.0.0.1", port: 80,
+			steps:   []step{{expect: []byte{version5, 1, authNone}, reply: []byte{version5, 0x01}}},
+			wantErr: "unsupported authentication method 1",
+		},
+		{
+			name: "wrong version",
+			host: "10.0.0.1", port: 80,
+			steps:   []step{{expect: []byte{version5, 1, authNone}, reply: []byte{version4, authNone}}},
+			wantErr: "unexpected protocol version 4",
+		},
+		{
+			name: "unknown bound address type",
+			host: "10.0.0.1", port: 80,
+			steps:   []step{noAuth, {expect: connectIPv4, reply: []byte{version5, ReplySucceeded, 0, 0x09}}},
+			wantErr: "reading bound address",
+		},
+	}
+	for code, msg := range replyMessages {
+		tests = append(tests, connect5Test{
+			name: fmt.Sprintf("reply %#x", code),
+			host: "10.0.0.1", port: 80,
+			steps:   []step{noAuth, {expect: connectIPv4, reply: []byte{version5, code, 0, atypIPv4}}},
+			wantErr: "connect to 10.0.0.1:80 failed: " + msg,
+		})
+	}
+	tests = append(tests, connect5Test{
+		name: "unknown reply code",
+		host: "10.0.0.1", port: 80,
+		steps:   []step{noAuth, {expect: connectIPv4, reply: []byte{version5, 0x42, 0, atypIPv4}}},
+		wantErr: "unknown reply code 66",
+	})
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn := stubServer(t, tt.steps)
+			protocol := tt.protocol
+			if protocol == "" {
+				protocol = "socks5h"
+			}
+			d := &Dialer{Protocol: protocol, Username: tt.username, Password: tt.password}
+			err := d.connect5(context.Background(), conn, tt.host, tt.port)
+			checkErr(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestConnect4(t *testing.T) {
+	granted := []byte{0, socks4Granted, 0, 0, 0, 0, 0, 0}
+
+	tests := []struct {
+		name     string
+		protocol string
+		username string
+		host     string
+		port     int
+		steps    []step
+		wantErr  string
+	}{
+		{
+			name:     "IPv4 target",
+			protocol: "socks4",
+			host:     "10.0.0.1", port: 80,
+			steps: []step{{expect: []byte{version4, cmdConnect, 0, 80, 10, 0, 0, 1, 0}, reply: granted}},
+		},
+		{
+			name:     "user ID",
+			protocol: "socks4",
+			username: "user",
+			host:     "10.0.0.1", port: 8080,
+			steps: []step{{
+				expect: concat([]byte{version4, cmdConnect, 0x1f, 0x90, 10, 0, 0, 1}, []byte("user"), []byte{0}),
+				reply:  granted,
+			}},
+		},
+		{
+			name:     "4a host name",
+			protocol: "socks4a",
+			username: "user",
+			host:     "example.com", port: 443,
+			steps: []step{{
+				expect: concat([]byte{version4, cmdConnect, 1, 187, 0, 0, 0, 1}, []byte("user"), []byte{0}, []byte("example.com"), []byte{0}),
+				reply:  granted,
+			}},
+		},
+		{
+			name:     "4a IPv4 target",
+			protocol: "socks4a",
+			host:     "10.0.0.1", port: 80,
+			steps: []step{{expect: []byte{version4, cmdConnect, 0, 80, 10, 0, 0, 1, 0}, reply: granted}},
+		},
+		{
+			name:     "rejected",
+			protocol: "socks4",
+			host:     "10.0.0.1", port: 80,
+			steps: []step{{
+				expect: []byte{version4, cmdConnect, 0, 80, 10, 0, 0, 1, 0},
+				reply:  []byte{0, 0x5b, 0, 0, 0, 0, 0, 0},
+			}},
+			wantErr: "connect to 10.0.0.1:80 rejected (code 0x5b)",
+		},
+		{
+			name:     "identd unreachable",
+			protocol: "socks4a",
+			host:     "example.com", port: 80,
+			steps: []step{{
+				expect: concat([]byte{version4, cmdConnect, 0, 80, 0, 0, 0, 1, 0}, []byte("example.com"), []byte{0}),
+				reply:  []byte{0, 0x5c, 0, 0, 0, 0, 0, 0},
+			}},
+			wantErr: "rejected (code 0x5c)",
+		},
+		{
+			name:     "user ID mismatch",
+			protocol: "socks4",
+			username: "user",
+			host:     "10.0.0.1", port: 80,
+			steps: []step{{
+				expect: concat([]byte{version4, cmdConnect, 0, 80, 10, 0, 0, 1}, []byte("user"), []byte{0}),
+				reply:  []byte{0, 0x5d, 0, 0, 0, 0, 0, 0},
+			}},
+			wantErr: "rejected (code 0x5d)",
+		},
+		{
+			name:     "short reply",
+			protocol: "socks4",
+			host:     "10.0.0.1", port: 80,
+			steps:   []step{{expect: []byte{version4, cmdConnect, 0, 80, 10, 0, 0, 1, 0}, reply: []byte{0, socks4Granted}}},
+			wantErr: "reading reply",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn := stubServer(t, tt.steps)
+			d := &Dialer{Protocol: tt.protocol, Username: tt.username}
+			err := d.connect4(context.Background(), conn, tt.host, tt.port)
+			checkErr(t, err, tt.wantErr)
+		})
+	}
+}
+
+func checkErr(t *testing.T, err error, want string) {
+	t.Helper()
+	if want == "" {
+		if err != nil {
+			t.Fatalf("handshake failed: %v", err)
+		}
+		return
+	}
+	if err == nil {
+		t.Fatalf("handshake succeeded, want an error containing %q", want)
+	}
+	if want == ErrAuthFailed.Error() && !errors.Is(err, ErrAuthFailed) {
+		t.Fatalf("error = %v, want ErrAuthFailed", err)
+	}
+	if !strings.Contains(err.Error(), want) {
+		t.Fatalf("error = %v, want it to contain %q", err, want)
+	}
+}
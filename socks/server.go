@@ -0,0 +1,217 @@
+package socks
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"syscall"
+	"time"
+
+	"go-proxy-rotator/tunnel"
+)
+
+// DialFunc opens the outbound connection for a client's CONNECT request.
+// target is a "host:port" string; host names are passed through unresolved.
+type DialFunc func(ctx context.Context, target string) (net.Conn, error)
+
+// Server is a SOCKS5 server that only supports the CONNECT command and
+// relays every accepted connection through Dial.
+type Server struct {
+	// Username and Password, when set, require clients to authenticate
+	// with RFC 1929 username/password authentication.
+	Username string
+	Password string
+
+	// Dial opens the outbound connection for each CONNECT request. It
+	// bounds its own attempts; the server does not time it out.
+	Dial DialFunc
+
+	// HandshakeTimeout bounds negotiation with the client and writing
+	// the reply to its request.
+	HandshakeTimeout time.Duration
+	// IdleTimeout closes relayed connections with no traffic in either
+	// direction for this long; zero disables it.
+	IdleTimeout time.Duration
+}
+
+// ListenAndServe listens on addr and serves SOCKS5 clients.
+func (s *Server) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
+
+// Serve accepts connections on l until it is closed.
+func (s *Server) Serve(l net.Listener) error {
+	defer l.Close()
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				time.Sleep(50 * time.Millisecond)
+				continue
+			}
+			return err
+		}
+		go s.serveConn(conn)
+	}
+}
+
+func (s *Server) serveConn(conn net.Conn) {
+	if s.HandshakeTimeout > 0 {
+		conn.SetDeadline(time.Now().Add(s.HandshakeTimeout))
+	}
+
+	target, err := s.handshake(conn)
+	if err != nil {
+		log.Printf("SOCKS handshake from %s failed: %v", conn.RemoteAddr(), err)
+		conn.Close()
+		return
+	}
+
+	// The client waits for the reply while Dial works through its
+	// upstream attempts, each bounded by its own timeout.
+	conn.SetDeadline(time.Time{})
+	upstream, err := s.Dial(context.Background(), target)
+	if s.HandshakeTimeout > 0 {
+		conn.SetWriteDeadline(time.Now().Add(s.HandshakeTimeout))
+	}
+	if err != nil {
+		log.Printf("SOCKS connect to %s failed: %v", target, err)
+		writeReply(conn, replyCode(err))
+		conn.Close()
+		return
+	}
+
+	if err := writeReply(conn, ReplySucceeded); err != nil {
+		conn.Close()
+		upstream.Close()
+		return
+	}
+	conn.SetDeadline(time.Time{})
+	tunnel.Splice(conn, upstream, s.IdleTimeout)
+}
+
+// replyCode returns the SOCKS5 reply that best describes a dial error. An
+// upstream SOCKS5 proxy's own reply is passed on unchanged.
+func replyCode(err error) byte {
+	var re *replyError
+	var dnsErr *net.DNSError
+	var ne net.Error
+	switch {
+	case errors.As(err, &re):
+		return re.code
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return ReplyConnectionRefused
+	case errors.Is(err, syscall.ENETUNREACH):
+		return ReplyNetworkUnreachable
+	case errors.Is(err, syscall.EHOSTUNREACH), errors.As(err, &dnsErr) && dnsErr.IsNotFound:
+		return ReplyHostUnreachable
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &ne) && ne.Timeout():
+		return ReplyTTLExpired
+	}
+	return ReplyGeneralFailure
+}
+
+// handshake negotiates authentication and reads the client's request,
+// returning the requested target address.
+func (s *Server) handshake(conn net.Conn) (string, error) {
+	var hdr [2]byte
+	if _, err := io.ReadFull(conn, hdr[:]); err != nil {
+		return "", err
+	}
+	if hdr[0] != version5 {
+		return "", fmt.Errorf("unsupported SOCKS version %d", hdr[0])
+	}
+	methods := make([]byte, hdr[1])
+	if _, err := io.ReadFull(conn, methods); err != nil {
+		return "", err
+	}
+
+	want := byte(authNone)
+	if s.Username != "" || s.Password != "" {
+		want = authUserPass
+	}
+	offered := false
+	for _, m := range methods {
+		if m == want {
+			offered = true
+			break
+		}
+	}
+	if !offered {
+		conn.Write([]byte{version5, authNoAcceptable})
+		return "", fmt.Errorf("client offered no acceptable authentication method")
+	}
+	if _, err := conn.Write([]byte{version5, want}); err != nil {
+		return "", err
+	}
+	if want == authUserPass {
+		if err := s.checkCredentials(conn); err != nil {
+			return "", err
+		}
+	}
+
+	var req [4]byte
+	if _, err := io.ReadFull(conn, req[:]); err != nil {
+		return "", err
+	}
+	if req[0] != version5 {
+		return "", fmt.Errorf("unsupported SOCKS version %d", req[0])
+	}
+	if req[1] != cmdConnect {
+		writeReply(conn, ReplyCommandNotSupported)
+		return "", fmt.Errorf("unsupported command %d", req[1])
+	}
+	target, err := readAddr(conn, req[3])
+	if err != nil {
+		writeReply(conn, ReplyAddressNotSupported)
+		return "", err
+	}
+	return target, nil
+}
+
+// checkCredentials runs the server side of RFC 1929.
+func (s *Server) checkCredentials(conn net.Conn) error {
+	var hdr [2]byte
+	if _, err := io.ReadFull(conn, hdr[:]); err != nil {
+		return err
+	}
+	if hdr[0] != userPassVersion {
+		return fmt.Errorf("unsupported authentication version %d", hdr[0])
+	}
+	username := make([]byte, hdr[1])
+	if _, err := io.ReadFull(conn, username); err != nil {
+		return err
+	}
+	var plen [1]byte
+	if _, err := io.ReadFull(conn, plen[:]); err != nil {
+		return err
+	}
+	password := make([]byte, plen[0])
+	if _, err := io.ReadFull(conn, password); err != nil {
+		return err
+	}
+
+	userOK := subtle.ConstantTimeCompare(username, []byte(s.Username)) == 1
+	passOK := subtle.ConstantTimeCompare(password, []byte(s.Password)) == 1
+	if !userOK || !passOK {
+		conn.Write([]byte{userPassVersion, 0x01})
+		return ErrAuthFailed
+	}
+	_, err := conn.Write([]byte{userPassVersion, 0x00})
+	return err
+}
+
+// writeReply sends a SOCKS5 reply with an unspecified bound address.
+func writeReply(conn net.Conn, code byte) error {
+	_, err := conn.Write([]byte{version5, code, 0x00, atypIPv4, 0, 0, 0, 0, 0, 0})
+	return err
+}
@@ -0,0 +1,100 @@
+package socks
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// startServer serves s on a loopback listener and returns its address.
+func startServer(t *testing.T, s *Server) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Serve(l)
+	t.Cleanup(func() { l.Close() })
+	return l.Addr().String()
+}
+
+func TestServerReplyCodes(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want byte
+	}{
+		{"refused", &net.OpError{Op: "dial", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}, ReplyConnectionRefused},
+		{"network unreachable", &net.OpError{Op: "dial", Err: os.NewSyscallError("connect", syscall.ENETUNREACH)}, ReplyNetworkUnreachable},
+		{"host unreachable", &net.OpError{Op: "dial", Err: os.NewSyscallError("connect", syscall.EHOSTUNREACH)}, ReplyHostUnreachable},
+		{"unknown host", &net.DNSError{Err: "no such host", Name: "nowhere.invalid", IsNotFound: true}, ReplyHostUnreachable},
+		{"timeout", fmt.Errorf("upstream 10.0.0.1:1080: %w", context.DeadlineExceeded), ReplyTTLExpired},
+		{"upstream reply", fmt.Errorf("upstream 10.0.0.1:1080: %w", &replyError{target: "example.com:443", code: ReplyNotAllowed}), ReplyNotAllowed},
+		{"other", errors.New("CONNECT example.com:443 rejected: 502 Bad Gateway"), ReplyGeneralFailure},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			addr := startServer(t, &Server{
+				Dial: func(ctx context.Context, target string) (net.Conn, error) {
+					return nil, tt.err
+				},
+				HandshakeTimeout: time.Second,
+			})
+
+			d := &Dialer{Protocol: "socks5h", ProxyAddr: addr, Timeout: 5 * time.Second}
+			_, err := d.DialContext(context.Background(), "tcp", "example.com:443")
+			var re *replyError
+			if !errors.As(err, &re) {
+				t.Fatalf("error = %v, want a SOCKS reply", err)
+			}
+			if re.code != tt.want {
+				t.Errorf("reply = %#x (%s), want %#x (%s)", re.code, replyMessages[re.code], tt.want, replyMessages[tt.want])
+			}
+		})
+	}
+}
+
+func TestServerDialOutlastsHandshakeTimeout(t *testing.T) {
+	addr := startServer(t, &Server{
+		// Dial fails over through attempts that together take longer
+		// than the handshake timeout.
+		Dial: func(ctx context.Context, target string) (net.Conn, error) {
+			if _, ok := ctx.Deadline(); ok {
+				return nil, errors.New("dial context has a deadline")
+			}
+			time.Sleep(200 * time.Millisecond)
+			client, server := net.Pipe()
+			go func() {
+				defer server.Close()
+				io.Copy(server, server)
+			}()
+			return client, nil
+		},
+		HandshakeTimeout: 50 * time.Millisecond,
+	})
+
+	d := &Dialer{Protocol: "socks5h", ProxyAddr: addr, Timeout: 5 * time.Second}
+	conn, err := d.DialContext(context.Background(), "tcp", "example.com:443")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err := conn.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	got := make([]byte, 4)
+	if _, err := io.ReadFull(conn, got); err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "ping" {
+		t.Errorf("echoed %q, want %q", got, "ping")
+	}
+}
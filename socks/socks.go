@@ -0,0 +1,142 @@
+// Package socks implements the SOCKS4, SOCKS4a and SOCKS5 protocols
+// (RFC 1928, RFC 1929): a client for dialing through SOCKS upstream proxies
+// and a SOCKS5 server for clients that cannot speak HTTP proxy.
+package socks
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+)
+
+const (
+	version4 = 0x04
+	version5 = 0x05
+
+	cmdConnect = 0x01
+
+	// SOCKS5 authentication methods (RFC 1928, section 3)
+	authNone         = 0x00
+	authUserPass     = 0x02
+	authNoAcceptable = 0xff
+
+	// Username/password sub-negotiation version (RFC 1929)
+	userPassVersion = 0x01
+
+	// SOCKS5 address types
+	atypIPv4   = 0x01
+	atypDomain = 0x03
+	atypIPv6   = 0x04
+
+	// SOCKS4 reply codes
+	socks4Granted = 0x5a
+)
+
+// Reply codes for SOCKS5 requests (RFC 1928, section 6)
+const (
+	ReplySucceeded           = 0x00
+	ReplyGeneralFailure      = 0x01
+	ReplyNotAllowed          = 0x02
+	ReplyNetworkUnreachable  = 0x03
+	ReplyHostUnreachable     = 0x04
+	ReplyConnectionRefused   = 0x05
+	ReplyTTLExpired          = 0x06
+	ReplyCommandNotSupported = 0x07
+	ReplyAddressNotSupported = 0x08
+)
+
+var replyMessages = map[byte]string{
+	ReplyGeneralFailure:      "general SOCKS server failure",
+	ReplyNotAllowed:          "connection not allowed by ruleset",
+	ReplyNetworkUnreachable:  "network unreachable",
+	ReplyHostUnreachable:     "host unreachable",
+	ReplyConnectionRefused:   "connection refused",
+	ReplyTTLExpired:          "TTL expired",
+	ReplyCommandNotSupported: "command not supported",
+	ReplyAddressNotSupported: "address type not supported",
+}
+
+// ErrAuthFailed is returned when the proxy rejects the supplied credentials.
+var ErrAuthFailed = errors.New("socks: authentication failed")
+
+// IsSOCKS reports whether protocol names one of the supported SOCKS
+// variants.
+func IsSOCKS(protocol string) bool {
+	switch protocol {
+	case "socks4", "socks4a", "socks5", "socks5h":
+		return true
+	}
+	return false
+}
+
+// appendAddr appends a SOCKS5 DST.ADDR/DST.PORT pair for host and port.
+func appendAddr(b []byte, host string, port int) ([]byte, error) {
+	if ip := net.ParseIP(host); ip != nil {
+		if ip4 := ip.To4(); ip4 != nil {
+			b = append(b, atypIPv4)
+			b = append(b, ip4...)
+		} else {
+			b = append(b, atypIPv6)
+			b = append(b, ip.To16()...)
+		}
+	} else {
+		if len(host) == 0 || len(host) > 255 {
+			return nil, fmt.Errorf("socks: invalid host name %q", host)
+		}
+		b = append(b, atypDomain, byte(len(host)))
+		b = append(b, host...)
+	}
+	return binary.BigEndian.AppendUint16(b, uint16(port)), nil
+}
+
+// readAddr reads a SOCKS5 address of the given type followed by a port.
+func readAddr(r io.Reader, atyp byte) (string, error) {
+	var host string
+	switch atyp {
+	case atypIPv4:
+		buf := make([]byte, net.IPv4len)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return "", err
+		}
+		host = net.IP(buf).String()
+	case atypIPv6:
+		buf := make([]byte, net.IPv6len)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return "", err
+		}
+		host = net.IP(buf).String()
+	case atypDomain:
+		var n [1]byte
+		if _, err := io.ReadFull(r, n[:]); err != nil {
+			return "", err
+		}
+		buf := make([]byte, n[0])
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return "", err
+		}
+		host = string(buf)
+	default:
+		return "", fmt.Errorf("socks: unknown address type %d", atyp)
+	}
+
+	var port [2]byte
+	if _, err := io.ReadFull(r, port[:]); err != nil {
+		return "", err
+	}
+	return net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port[:])))), nil
+}
+
+func splitHostPort(addr string) (string, int, error) {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", 0, err
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil || port < 1 || port > 65535 {
+		return "", 0, fmt.Errorf("socks: invalid port %q", portStr)
+	}
+	return host, port, nil
+}
@@ -0,0 +1,96 @@
+package tunnel
+
+import (
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// Splice copies data between a and b in both directions until both sides
+// have finished, then closes both connections. When one side stops sending,
+// the write half of the other side is closed so it sees EOF while the
+// opposite direction keeps flowing. If idle is positive, the tunnel is torn
+// down once no data has moved in either direction for that long.
+func Splice(a, b net.Conn, idle time.Duration) {
+	defer closeConn(a)
+	defer closeConn(b)
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		pipe(b, a, idle)
+	}()
+	go func() {
+		defer wg.Done()
+		pipe(a, b, idle)
+	}()
+	wg.Wait()
+}
+
+// pipe copies src to dst. Every successful read pushes the read deadline of
+// both connections forward, so a long one-way transfer does not time out
+// the quiet direction.
+func pipe(dst, src net.Conn, idle time.Duration) {
+	buf := make([]byte, 32*1024)
+	for {
+		if idle > 0 {
+			deadline := time.Now().Add(idle)
+			src.SetReadDeadline(deadline)
+			dst.SetReadDeadline(deadline)
+		}
+
+		n, err := src.Read(buf)
+		if n > 0 {
+			if _, werr := dst.Write(buf[:n]); werr != nil {
+				// The peer is gone; unblock the other direction too.
+				closeConn(src)
+				closeConn(dst)
+				return
+			}
+		}
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				closeWrite(dst)
+				return
+			}
+			// Reset, idle timeout or a closed peer: tear down the whole
+			// tunnel so the other direction stops as well.
+			closeConn(src)
+			closeConn(dst)
+			return
+		}
+	}
+}
+
+type closeWriter interface {
+	CloseWrite() error
+}
+
+// rawConn unwraps fasthttp's hijacked connections, whose Close is a no-op
+// and which do not expose CloseWrite themselves.
+func rawConn(conn net.Conn) net.Conn {
+	type unwrapper interface {
+		UnsafeConn() net.Conn
+	}
+	if u, ok := conn.(unwrapper); ok {
+		return u.UnsafeConn()
+	}
+	return conn
+}
+
+// closeWrite half-closes conn if the connection type supports it and fully
+// closes it otherwise.
+func closeWrite(conn net.Conn) {
+	if cw, ok := rawConn(conn).(closeWriter); ok {
+		cw.CloseWrite()
+		return
+	}
+	closeConn(conn)
+}
+
+func closeConn(conn net.Conn) {
+	rawConn(conn).Close()
+}
@@ -0,0 +1,153 @@
+package tunnel
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// tcpPair returns the two ends of a loopback TCP connection.
+func tcpPair(t *testing.T) (net.Conn, net.Conn) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, _ := l.Accept()
+		accepted <- conn
+	}()
+	dialed, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn := <-accepted
+	if conn == nil {
+		t.Fatal("accept failed")
+	}
+	t.Cleanup(func() {
+		dialed.Close()
+		conn.Close()
+	})
+	return dialed, conn
+}
+
+// splice connects a client and an origin through a tunnel with the given
+// idle timeout. done is closed once Splice returns.
+func splice(t *testing.T, idle time.Duration) (client, origin net.Conn, done <-chan struct{}) {
+	t.Helper()
+	client, front := tcpPair(t)
+	back, origin := tcpPair(t)
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		Splice(front, back, idle)
+	}()
+	client.SetDeadline(time.Now().Add(5 * time.Second))
+	origin.SetDeadline(time.Now().Add(5 * time.Second))
+	return client, origin, finished
+}
+
+func waitDone(t *testing.T, done <-chan struct{}, within time.Duration) {
+	t.Helper()
+	select {
+	case <-done:
+	case <-time.After(within):
+		t.Fatalf("Splice did not return within %v", within)
+	}
+}
+
+func TestSpliceHalfClose(t *testing.T) {
+	tests := []struct {
+		name   string
+		closer string // the side that finishes sending first
+	}{
+		{name: "client finishes first", closer: "client"},
+		{name: "origin finishes first", closer: "origin"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, origin, done := splice(t, 0)
+			first, second := client, origin
+			if tt.closer == "origin" {
+				first, second = origin, client
+			}
+
+			io.WriteString(first, "request")
+			first.(*net.TCPConn).CloseWrite()
+
+			// The other side sees the data and EOF, and can still answer
+			got, err := io.ReadAll(second)
+			if err != nil || string(got) != "request" {
+				t.Fatalf("read %q, %v; want %q, EOF", got, err, "request")
+			}
+			io.WriteString(second, "response")
+			second.Close()
+
+			got, err = io.ReadAll(first)
+			if err != nil || string(got) != "response" {
+				t.Fatalf("read %q, %v; want %q, EOF", got, err, "response")
+			}
+			waitDone(t, done, time.Second)
+		})
+	}
+}
+
+func TestSpliceIdleTimeout(t *testing.T) {
+	tests := []struct {
+		name string
+		// chunks sent by the origin, one every interval
+		chunks   int
+		interval time.Duration
+		idle     time.Duration
+		timedOut bool
+	}{
+		{name: "no traffic", idle: 100 * time.Millisecond, timedOut: true},
+		{name: "one-way traffic keeps both directions open", chunks: 6, interval: 50 * time.Millisecond,
+			idle: 150 * time.Millisecond},
+		{name: "disabled", interval: 300 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, origin, done := splice(t, tt.idle)
+			for i := 0; i < tt.chunks; i++ {
+				time.Sleep(tt.interval)
+				if _, err := io.WriteString(origin, "x"); err != nil {
+					t.Fatalf("chunk %d: %v", i, err)
+				}
+			}
+			if tt.chunks == 0 {
+				time.Sleep(tt.interval)
+			}
+
+			if tt.timedOut {
+				waitDone(t, done, time.Second)
+				if _, err := client.Read(make([]byte, 1)); err == nil {
+					t.Error("client read succeeded after the idle timeout")
+				}
+				return
+			}
+			select {
+			case <-done:
+				t.Fatal("tunnel was torn down while in use")
+			default:
+			}
+			got := make([]byte, tt.chunks)
+			if _, err := io.ReadFull(client, got); err != nil {
+				t.Fatalf("client read %q: %v", got, err)
+			}
+			// The client direction, quiet all along, still works
+			if _, err := io.WriteString(client, "ok"); err != nil {
+				t.Fatal(err)
+			}
+			reply := make([]byte, 2)
+			if _, err := io.ReadFull(origin, reply); err != nil || string(reply) != "ok" {
+				t.Fatalf("origin read %q, %v", reply, err)
+			}
+		})
+	}
+}